@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -9,6 +10,13 @@ import (
 
 func main() {
 	if err := cli.Execute(); err != nil {
+		var exit *cli.ExitError
+		if errors.As(err, &exit) {
+			if exit.Err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", exit.Err)
+			}
+			os.Exit(exit.Code)
+		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -5,19 +5,48 @@ import (
 	"os"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/cobra"
+
+	"github.com/juparave/photoptim/internal/cache"
+	"github.com/juparave/photoptim/internal/cli"
+	"github.com/juparave/photoptim/internal/config"
+	"github.com/juparave/photoptim/internal/ledger"
 	"github.com/juparave/photoptim/internal/tui"
 )
 
 func main() {
-	// Create the model
-	model := tui.NewModel()
+	cmd := &cobra.Command{
+		Use:   "tui",
+		Short: "Pick and optimize local images interactively",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rules, err := cli.FilterFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+			opts := tui.Options{Filter: rules}
+			// The ledger shares the cache database; without it every file
+			// is optimized again.
+			if dc, err := cache.Open(config.ResolvePaths().CacheDB, 0); err == nil {
+				defer dc.Close()
+				opts.Ledger = ledger.New(dc.DB())
+			}
+
+			// Create the model
+			model := tui.NewModel(opts)
 
-	// Create the program
-	program := tea.NewProgram(&model)
+			// Create the program
+			program := tea.NewProgram(&model)
 
-	// Run the program
-	if _, err := program.Run(); err != nil {
-		fmt.Printf("Error running program: %v\n", err)
+			// Run the program
+			if _, err := program.Run(); err != nil {
+				return fmt.Errorf("error running program: %w", err)
+			}
+			return nil
+		},
+	}
+	cli.AddFilterFlags(cmd, "0")
+	if err := cmd.Execute(); err != nil {
 		os.Exit(1)
 	}
 }
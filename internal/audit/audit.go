@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"os"
 	"sync"
+
+	"github.com/juparave/photoptim/internal/optimizer"
 )
 
 // Record holds audit information for a processed file.
@@ -13,8 +15,51 @@ type Record struct {
 	OptimizedSize  int64   `json:"optimizedSize"`
 	SavingsBytes   int64   `json:"savingsBytes"`
 	SavingsPercent float64 `json:"savingsPercent"`
+	DurationMs     int64   `json:"durationMs"`
 	Status         string  `json:"status"`
 	Reason         string  `json:"reason,omitempty"`
+	Error          string  `json:"error,omitempty"`   // final error of a failed file
+	Retries        int     `json:"retries,omitempty"` // transient failures retried
+
+	SourceWidth  int     `json:"sourceWidth,omitempty"`
+	SourceHeight int     `json:"sourceHeight,omitempty"`
+	Width        int     `json:"width,omitempty"`
+	Height       int     `json:"height,omitempty"`
+	InputFormat  string  `json:"inputFormat,omitempty"`
+	OutputFormat string  `json:"outputFormat,omitempty"`
+	Quality      int     `json:"quality,omitempty"`
+	Resized      bool    `json:"resized"`
+	MetadataKept bool    `json:"metadataKept"`
+	PSNR         float64 `json:"psnr,omitempty"`
+	SSIM         float64 `json:"ssim,omitempty"`
+}
+
+// NewRecord builds a record for path from an optimizer result.
+func NewRecord(path, status string, res optimizer.Result) Record {
+	r := Record{
+		Path:          path,
+		OriginalSize:  res.OriginalSize,
+		OptimizedSize: res.OptimizedSize,
+		DurationMs:    res.Duration.Milliseconds(),
+		Status:        status,
+		Reason:        res.Reason,
+		SourceWidth:   res.SourceWidth,
+		SourceHeight:  res.SourceHeight,
+		Width:         res.Width,
+		Height:        res.Height,
+		InputFormat:   res.InputFormat,
+		OutputFormat:  res.OutputFormat,
+		Quality:       res.Quality,
+		Resized:       res.Resized,
+		MetadataKept:  res.MetadataKept,
+		PSNR:          res.PSNR,
+		SSIM:          res.SSIM,
+	}
+	if !res.Skipped && res.OriginalSize > 0 {
+		r.SavingsBytes = res.OriginalSize - res.OptimizedSize
+		r.SavingsPercent = float64(r.SavingsBytes) / float64(res.OriginalSize) * 100
+	}
+	return r
 }
 
 // Logger appends records to a JSON file (array) in a simplistic manner.
@@ -0,0 +1,117 @@
+// Package backup keeps the originals a run replaces and puts them back.
+//
+// Originals of a run are stored under the run's ID, mirroring their paths
+// relative to the connection root, with their mode and mtime. Backups live
+// either next to the photos (a hidden directory on the remote) or locally in
+// the session's temp directory.
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/juparave/photoptim/internal/remotefs"
+)
+
+// RemoteDir is the hidden directory, relative to the connection root, that
+// holds remote backups: RemoteDir/<run-id>/<path>.
+const RemoteDir = ".photoptim-originals"
+
+// Store keeps the originals of one run.
+type Store interface {
+	// Save keeps data as the original of entry, with entry's mode and mtime.
+	// An original already saved for entry's path is kept as it is: when a
+	// run is resumed, a file replaced before the interruption must not
+	// have its optimized bytes saved over the original.
+	Save(ctx context.Context, entry remotefs.RemoteEntry, data []byte) error
+	// List returns the saved originals. Paths are the paths the originals
+	// were saved from; Mode and ModTime are the originals'.
+	List(ctx context.Context) ([]remotefs.RemoteEntry, error)
+	// Open opens the original saved from path.
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+	// Location describes where the backups are, for messages.
+	Location() string
+}
+
+// NewRunID returns a run ID based on the current time, with a random suffix
+// so that runs started in the same second differ, e.g.
+// "20261018-153000-1a2b3c". IDs sort by time.
+func NewRunID() string {
+	var b [3]byte
+	_, _ = rand.Read(b[:])
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b[:])
+}
+
+// ValidRunID reports whether id is usable as a single path element.
+func ValidRunID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.ContainsAny(id, `/\`)
+}
+
+// relPath makes p relative to the connection root.
+func relPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// RestoreOptions control Restore.
+type RestoreOptions struct {
+	DryRun bool // only report what would be restored
+	// Report (optional) is called for every original, with the error that
+	// restoring it produced.
+	Report func(e remotefs.RemoteEntry, err error)
+}
+
+// Restore copies every original in store back over its path on dst, through
+// a verified temporary file, with the original mode and mtime, and its owner
+// where the store knows it and the server permits it. It returns the number
+// of originals restored (or, with DryRun, found) and the first error; a
+// failed file does not stop the others.
+func Restore(ctx context.Context, store Store, dst remotefs.RemoteFS, opts RestoreOptions) (int, error) {
+	entries, err := store.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	var first error
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		var err error
+		if !opts.DryRun {
+			err = restoreOne(ctx, store, dst, e)
+		}
+		if err != nil && first == nil {
+			first = fmt.Errorf("restore %s: %w", e.Path, err)
+		}
+		if err == nil {
+			n++
+		}
+		if opts.Report != nil {
+			opts.Report(e, err)
+		}
+	}
+	return n, first
+}
+
+func restoreOne(ctx context.Context, store Store, dst remotefs.RemoteFS, e remotefs.RemoteEntry) error {
+	rc, err := store.Open(ctx, e.Path)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	af, err := remotefs.CreateAtomic(ctx, dst, e.Path)
+	if err != nil {
+		return err
+	}
+	defer af.Close()
+	if _, err := io.Copy(af, rc); err != nil {
+		return err
+	}
+	return af.Commit(ctx, remotefs.Preserve(e))
+}
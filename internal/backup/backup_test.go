@@ -0,0 +1,50 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/juparave/photoptim/internal/remotefs"
+)
+
+func TestNewRunIDUnique(t *testing.T) {
+	a, b := NewRunID(), NewRunID()
+	if a == b || !ValidRunID(a) {
+		t.Fatalf("run IDs %q and %q", a, b)
+	}
+}
+
+// TestSaveKeepsOriginal covers a resumed run: a file replaced before the
+// interruption is downloaded again, optimized, and must not be saved over
+// its original.
+func TestSaveKeepsOriginal(t *testing.T) {
+	ctx := context.Background()
+	entry := remotefs.RemoteEntry{Path: "photos/a.jpg", Name: "a.jpg", Mode: 0o640, ModTime: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}
+	stores := map[string]Store{
+		"local":  &Local{Dir: t.TempDir()},
+		"remote": NewRemote(remotefs.NewMockFS("/"), NewRunID()),
+	}
+	for name, store := range stores {
+		if err := store.Save(ctx, entry, []byte("original")); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := store.Save(ctx, entry, []byte("optimized")); err != nil {
+			t.Fatalf("%s: saving again: %v", name, err)
+		}
+		entries, err := store.List(ctx)
+		if err != nil || len(entries) != 1 || entries[0].Path != entry.Path {
+			t.Fatalf("%s: list %+v, %v", name, entries, err)
+		}
+		rc, err := store.Open(ctx, entry.Path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(data) != "original" {
+			t.Fatalf("%s: backup holds %q, want the original", name, data)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/juparave/photoptim/internal/remotefs"
+)
+
+// Local stores originals on the local disk, mirroring their remote paths.
+type Local struct {
+	Dir string
+}
+
+// SessionDir returns the session temp directory of run id,
+// $TMPDIR/photoptim_sftp_<id>; local backups go to its orig/ directory.
+func SessionDir(id string) string {
+	return filepath.Join(os.TempDir(), "photoptim_sftp_"+id)
+}
+
+// NewLocal returns the local store of run id, SessionDir(id)/orig.
+func NewLocal(id string) *Local {
+	return &Local{Dir: filepath.Join(SessionDir(id), "orig")}
+}
+
+func (l *Local) Location() string { return l.Dir }
+
+func (l *Local) Save(ctx context.Context, entry remotefs.RemoteEntry, data []byte) error {
+	dst := filepath.Join(l.Dir, filepath.FromSlash(relPath(entry.Path)))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return nil // saved before the run was resumed
+	}
+	mode := entry.Mode.Perm()
+	if mode == 0 {
+		mode = 0o644
+	}
+	// Write a hidden temporary file and link it in place: the link fails
+	// rather than replace a backup, and an interrupted save leaves no
+	// truncated one.
+	f, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		return err
+	}
+	if !entry.ModTime.IsZero() {
+		if err := os.Chtimes(tmp, entry.ModTime, entry.ModTime); err != nil {
+			return err
+		}
+	}
+	if err := os.Link(tmp, dst); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) List(ctx context.Context) ([]remotefs.RemoteEntry, error) {
+	var out []remotefs.RemoteEntry
+	err := filepath.WalkDir(l.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if name := d.Name(); strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".tmp") {
+			return nil // an interrupted save
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.Dir, p)
+		if err != nil {
+			return err
+		}
+		out = append(out, remotefs.RemoteEntry{
+			Path:    filepath.ToSlash(rel),
+			Name:    d.Name(),
+			Size:    fi.Size(),
+			Mode:    fi.Mode().Perm(),
+			ModTime: fi.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+func (l *Local) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(l.Dir, filepath.FromSlash(relPath(p))))
+}
+
+// LocalRuns lists the run IDs with local backups, oldest first.
+func LocalRuns() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(os.TempDir(), "photoptim_sftp_*", "orig"))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, m := range matches {
+		ids = append(ids, strings.TrimPrefix(filepath.Base(filepath.Dir(m)), "photoptim_sftp_"))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
@@ -0,0 +1,104 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/juparave/photoptim/internal/remotefs"
+)
+
+// Remote stores originals on the remote itself, under RemoteDir/<run-id>.
+type Remote struct {
+	FS  remotefs.RemoteFS
+	Dir string // run directory relative to the connection root
+}
+
+// NewRemote returns the remote store of run id on fsys.
+func NewRemote(fsys remotefs.RemoteFS, id string) *Remote {
+	return &Remote{FS: fsys, Dir: path.Join(RemoteDir, id)}
+}
+
+func (r *Remote) Location() string { return path.Join(r.FS.Root(), r.Dir) }
+
+func (r *Remote) Save(ctx context.Context, entry remotefs.RemoteEntry, data []byte) error {
+	dst := path.Join(r.Dir, relPath(entry.Path))
+	if _, err := r.FS.Stat(ctx, dst); err == nil {
+		return nil // saved before the run was resumed
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := r.FS.MkdirAll(ctx, path.Dir(dst)); err != nil {
+		return err
+	}
+	af, err := remotefs.CreateAtomic(ctx, r.FS, dst)
+	if err != nil {
+		return err
+	}
+	defer af.Close()
+	if _, err := af.Write(data); err != nil {
+		return err
+	}
+	return af.Commit(ctx, remotefs.Preserve(entry))
+}
+
+// List walks the run directory. Hidden files (interrupted saves) are left
+// out.
+func (r *Remote) List(ctx context.Context) ([]remotefs.RemoteEntry, error) {
+	if _, err := r.FS.Stat(ctx, r.Dir); err != nil {
+		return nil, err
+	}
+	var out []remotefs.RemoteEntry
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := r.FS.List(ctx, dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name, ".") {
+				continue
+			}
+			p := path.Join(dir, e.Name)
+			if e.IsDir {
+				if err := walk(p); err != nil {
+					return err
+				}
+				continue
+			}
+			e.Path = strings.TrimPrefix(p, r.Dir+"/")
+			out = append(out, e)
+		}
+		return nil
+	}
+	if err := walk(r.Dir); err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+func (r *Remote) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	rc, _, err := r.FS.Open(ctx, path.Join(r.Dir, relPath(p)))
+	return rc, err
+}
+
+// RemoteRuns lists the run IDs with backups on fsys, oldest first.
+func RemoteRuns(ctx context.Context, fsys remotefs.RemoteFS) ([]string, error) {
+	entries, err := fsys.List(ctx, RemoteDir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir {
+			ids = append(ids, e.Name)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
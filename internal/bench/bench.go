@@ -0,0 +1,238 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juparave/photoptim/internal/optimizer"
+)
+
+// Setting is one encoder configuration swept across qualities.
+type Setting struct {
+	Name   string
+	Params optimizer.Params
+}
+
+// Point is one measured encode.
+type Point struct {
+	File          string        `json:"file"`
+	Setting       string        `json:"setting"`
+	Quality       int           `json:"quality"` // 0 for lossless formats
+	OriginalBytes int64         `json:"originalBytes"`
+	Bytes         int64         `json:"bytes"`
+	SSIM          float64       `json:"ssim"`
+	PSNR          float64       `json:"psnr"`
+	EncodeTime    time.Duration `json:"encodeTimeNs"`
+}
+
+// Ratio returns the output size relative to the original.
+func (p Point) Ratio() float64 {
+	if p.OriginalBytes == 0 {
+		return 0
+	}
+	return float64(p.Bytes) / float64(p.OriginalBytes)
+}
+
+// Run encodes data at every quality for every setting. Lossless formats only
+// depend on the setting, so they produce one point per setting.
+// Cancellation is checked between encodes.
+func Run(ctx context.Context, opt optimizer.Optimizer, file string, data []byte, format string, qualities []int, settings []Setting) ([]Point, error) {
+	lossy := format == "jpg" || format == "jpeg"
+	if !lossy {
+		qualities = []int{0}
+	}
+	points := make([]Point, 0, len(qualities)*len(settings))
+	for _, s := range settings {
+		for _, q := range qualities {
+			if err := ctx.Err(); err != nil {
+				return points, err
+			}
+			params := s.Params
+			params.JPEGQuality = q
+			params.SkipGainCheck = true
+			params.ForceReencode = true
+			params.Score = true
+			_, res, err := opt.OptimizeBytes(ctx, data, format, params)
+			if err != nil {
+				return points, fmt.Errorf("%s (%s, q%d): %w", file, s.Name, q, err)
+			}
+			points = append(points, Point{
+				File:          file,
+				Setting:       s.Name,
+				Quality:       res.Quality,
+				OriginalBytes: res.OriginalSize,
+				Bytes:         res.OptimizedSize,
+				SSIM:          res.SSIM,
+				PSNR:          res.PSNR,
+				EncodeTime:    res.Duration,
+			})
+		}
+	}
+	return points, nil
+}
+
+// Curve is the size-vs-quality curve of one setting, averaged over files.
+type Curve struct {
+	Setting string
+	Points  []Point // File is empty; Bytes/OriginalBytes are summed, SSIM/PSNR averaged
+}
+
+// Curves groups points by setting and averages them per quality.
+func Curves(points []Point) []Curve {
+	type key struct {
+		setting string
+		quality int
+	}
+	sums := map[key]*Point{}
+	counts := map[key]int{}
+	order := []string{}
+	seen := map[string]bool{}
+	for _, p := range points {
+		k := key{p.Setting, p.Quality}
+		if !seen[p.Setting] {
+			seen[p.Setting] = true
+			order = append(order, p.Setting)
+		}
+		acc := sums[k]
+		if acc == nil {
+			acc = &Point{Setting: p.Setting, Quality: p.Quality}
+			sums[k] = acc
+		}
+		acc.OriginalBytes += p.OriginalBytes
+		acc.Bytes += p.Bytes
+		acc.SSIM += p.SSIM
+		acc.PSNR += p.PSNR
+		acc.EncodeTime += p.EncodeTime
+		counts[k]++
+	}
+	curves := make([]Curve, 0, len(order))
+	for _, name := range order {
+		c := Curve{Setting: name}
+		for k, acc := range sums {
+			if k.setting != name {
+				continue
+			}
+			n := float64(counts[k])
+			p := *acc
+			p.SSIM /= n
+			p.PSNR /= n
+			p.EncodeTime /= time.Duration(counts[k])
+			c.Points = append(c.Points, p)
+		}
+		sort.Slice(c.Points, func(i, j int) bool { return c.Points[i].Quality < c.Points[j].Quality })
+		curves = append(curves, c)
+	}
+	return curves
+}
+
+// Knee returns the index of the curve's knee: the point furthest above the
+// straight line joining the lowest and highest quality, with size ratio and
+// SSIM both normalized to [0,1]. Past the knee, extra bytes buy little SSIM.
+func Knee(points []Point) int {
+	if len(points) < 3 {
+		return len(points) - 1
+	}
+	first, last := points[0], points[len(points)-1]
+	dx := last.Ratio() - first.Ratio()
+	dy := last.SSIM - first.SSIM
+	if dx <= 0 || dy <= 0 {
+		return len(points) - 1
+	}
+	best, bestDist := len(points)-1, 0.0
+	for i, p := range points {
+		x := (p.Ratio() - first.Ratio()) / dx
+		y := (p.SSIM - first.SSIM) / dy
+		if d := y - x; d > bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// ParseQualities parses "40-95:5" (range with step), "60,70,80" or a mix.
+func ParseQualities(s string) ([]int, error) {
+	seen := map[int]bool{}
+	out := []int{}
+	add := func(q int) error {
+		if q < 1 || q > 100 {
+			return fmt.Errorf("quality %d out of range 1-100", q)
+		}
+		if !seen[q] {
+			seen[q] = true
+			out = append(out, q)
+		}
+		return nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		rng, stepStr, hasStep := strings.Cut(part, ":")
+		lo, hi, isRange := strings.Cut(rng, "-")
+		if !isRange {
+			q, err := strconv.Atoi(rng)
+			if err != nil || hasStep {
+				return nil, fmt.Errorf("invalid quality %q", part)
+			}
+			if err := add(q); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		from, err1 := strconv.Atoi(lo)
+		to, err2 := strconv.Atoi(hi)
+		step := 5
+		var err3 error
+		if hasStep {
+			step, err3 = strconv.Atoi(stepStr)
+		}
+		if err1 != nil || err2 != nil || err3 != nil || step <= 0 || from > to {
+			return nil, fmt.Errorf("invalid quality range %q", part)
+		}
+		for q := from; q <= to; q += step {
+			if err := add(q); err != nil {
+				return nil, err
+			}
+		}
+		if err := add(to); err != nil {
+			return nil, err
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no qualities in %q", s)
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+// ParseSetting parses a setting name: "default", "no-gray", "gray:N"
+// (grayscale tolerance N) or "max:WxH" (resize limits).
+func ParseSetting(s string) (Setting, error) {
+	name, arg, _ := strings.Cut(s, ":")
+	switch name {
+	case "default":
+		return Setting{Name: s}, nil
+	case "no-gray":
+		return Setting{Name: s, Params: optimizer.Params{GrayTolerance: -1}}, nil
+	case "gray":
+		tol, err := strconv.Atoi(arg)
+		if err != nil || tol < 0 || tol > 255 {
+			return Setting{}, fmt.Errorf("invalid gray tolerance in %q", s)
+		}
+		return Setting{Name: s, Params: optimizer.Params{GrayTolerance: tol}}, nil
+	case "max":
+		ws, hs, ok := strings.Cut(arg, "x")
+		w, err1 := strconv.Atoi(ws)
+		h, err2 := strconv.Atoi(hs)
+		if !ok || err1 != nil || err2 != nil || w < 0 || h < 0 {
+			return Setting{}, fmt.Errorf("invalid size in %q (want max:WxH)", s)
+		}
+		return Setting{Name: s, Params: optimizer.Params{MaxWidth: w, MaxHeight: h}}, nil
+	}
+	return Setting{}, fmt.Errorf("unknown setting %q (want default, no-gray, gray:N or max:WxH)", s)
+}
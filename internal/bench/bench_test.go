@@ -0,0 +1,36 @@
+package bench
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQualities(t *testing.T) {
+	got, err := ParseQualities("40-60:10,75,95-100:10")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := []int{40, 50, 60, 75, 95, 100}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	for _, bad := range []string{"", "0-50", "80-40", "a", "50:5"} {
+		if _, err := ParseQualities(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestKnee(t *testing.T) {
+	// SSIM saturates early while size keeps growing: the knee is at q60.
+	pts := []Point{
+		{Quality: 20, OriginalBytes: 100, Bytes: 10, SSIM: 0.80},
+		{Quality: 40, OriginalBytes: 100, Bytes: 15, SSIM: 0.93},
+		{Quality: 60, OriginalBytes: 100, Bytes: 20, SSIM: 0.98},
+		{Quality: 80, OriginalBytes: 100, Bytes: 40, SSIM: 0.99},
+		{Quality: 95, OriginalBytes: 100, Bytes: 90, SSIM: 0.995},
+	}
+	if k := Knee(pts); pts[k].Quality != 60 {
+		t.Fatalf("knee at q%d, want q60", pts[k].Quality)
+	}
+}
@@ -3,15 +3,21 @@ package cache
 import (
 	"encoding/json"
 	"errors"
+	"log/slog"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
+
+	"github.com/juparave/photoptim/internal/logging"
 )
 
 // DirectoryCache stores serialized directory listings with TTL.
 type DirectoryCache struct {
 	db  *bolt.DB
 	ttl time.Duration
+
+	// Logger receives hit/miss details at debug level; nil = silent.
+	Logger *slog.Logger
 }
 
 type record struct {
@@ -64,19 +70,27 @@ func (c *DirectoryCache) Get(dir string, target any) (bool, error) { //nolint:ir
 	}); err != nil {
 		return false, err
 	}
+	log := logging.OrDiscard(c.Logger)
 	if rb == nil {
+		log.Debug("cache miss", "dir", dir)
 		return false, nil
 	}
 	var rec record
 	if err := json.Unmarshal(rb, &rec); err != nil {
 		return false, err
 	}
-	if time.Since(rec.StoredAt) > c.ttl {
+	if age := time.Since(rec.StoredAt); age > c.ttl {
+		log.Debug("cache stale", "dir", dir, "age", age, "ttl", c.ttl)
 		return false, nil
 	}
+	log.Debug("cache hit", "dir", dir)
 	return true, json.Unmarshal(rec.Data, target)
 }
 
+// DB returns the underlying database, which other stores (such as run
+// journals) share: bbolt allows only one open handle per file.
+func (c *DirectoryCache) DB() *bolt.DB { return c.db }
+
 func (c *DirectoryCache) Close() error {
 	if c.db != nil {
 		return c.db.Close()
@@ -2,9 +2,11 @@ package cli
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
-	"strings"
+	"time"
 
+	"github.com/juparave/photoptim/internal/ledger"
 	"github.com/juparave/photoptim/internal/optimizer"
 
 	"github.com/spf13/cobra"
@@ -28,6 +30,38 @@ var batchCmd = &cobra.Command{
 			return err
 		}
 		opt.Quality = quality
+		opt.GrayTolerance, _ = cmd.Flags().GetInt("gray-tolerance")
+		opt.Allow16To8, _ = cmd.Flags().GetBool("reduce-16bit")
+		opt.ForceReencode, _ = cmd.Flags().GetBool("force")
+		opt.Timeout, _ = cmd.Flags().GetDuration("image-timeout")
+		opt.Logger = logger
+		rescan, _ := cmd.Flags().GetBool("rescan")
+		rules, err := FilterFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		dryRun, format, err := dryRunFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		var rep *dryRunReport
+		if dryRun {
+			rep = newDryRunReport()
+		}
+		skip := func(filename string, size int64, reason, why string) {
+			if rep != nil {
+				rep.skip(filename, size, reason)
+				return
+			}
+			fmt.Printf("Skipped %s: %s\n", filename, why)
+		}
+
+		// The ledger shares the cache database.
+		var ld *ledger.Ledger
+		if dc := openListingCache(0); dc != nil {
+			defer dc.Close()
+			ld = ledger.New(dc.DB())
+		}
 
 		// Read all files in input directory
 		files, err := filepath.Glob(filepath.Join(inputDir, "*"))
@@ -38,22 +72,53 @@ var batchCmd = &cobra.Command{
 		// Process each file
 		count := 0
 		for _, file := range files {
-			// Check if it's an image file
-			ext := strings.ToLower(filepath.Ext(file))
-			if ext == ".jpg" || ext == ".jpeg" || ext == ".png" {
-				// Generate output path
-				filename := filepath.Base(file)
-				outputPath := filepath.Join(outputDir, filename)
+			info, err := os.Stat(file)
+			if err != nil {
+				fmt.Printf("Warning: failed to optimize %s: %v\n", file, err)
+				continue
+			}
+			if info.IsDir() {
+				continue
+			}
+			// Generate output path
+			filename := filepath.Base(file)
+			outputPath := filepath.Join(outputDir, filename)
+
+			if reason := rules.MatchLocal(file, filename, info); reason != "" {
+				skip(filename, info.Size(), reason, reason)
+				continue
+			}
+			if !rescan && ld.LocalUnchanged(file, outputPath) {
+				skip(filename, info.Size(), "previously-optimized", "previously optimized")
+				continue
+			}
+			if rep != nil {
+				start := time.Now()
+				res, err := opt.Estimate(file)
+				rep.result(filename, info.Size(), res, err, time.Since(start))
+				continue
+			}
 
-				// Optimize image
-				if err := opt.Optimize(file, outputPath); err != nil {
-					fmt.Printf("Warning: failed to optimize %s: %v\n", file, err)
-					continue
-				}
+			// Optimize image
+			res, err := opt.Optimize(file, outputPath)
+			if err != nil {
+				fmt.Printf("Warning: failed to optimize %s: %v\n", file, err)
+				continue
+			}
+			if err := ld.RecordLocal(file, info, outputPath, res.Reason); err != nil {
+				logger.Warn("ledger update failed", "file", outputPath, "err", err)
+			}
+			if res.KeptOriginal() {
+				fmt.Printf("Skipped %s: %s\n", filename, keptReason(res))
+			} else {
+				fmt.Printf("Optimized %s (%d bytes) -> %s (%d bytes)\n", filename, res.OriginalSize, outputPath, res.OptimizedSize)
 				count++
 			}
 		}
 
+		if rep != nil {
+			return rep.write(cmd.OutOrStdout(), format)
+		}
 		fmt.Printf("Successfully optimized %d images\n", count)
 		return nil
 	},
@@ -62,4 +127,11 @@ var batchCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(batchCmd)
 	batchCmd.Flags().IntP("quality", "q", 80, "Quality for JPEG compression (1-100)")
+	batchCmd.Flags().Int("gray-tolerance", 0, "Max channel spread (0-255) still encoded as grayscale; -1 disables")
+	batchCmd.Flags().Bool("reduce-16bit", false, "Reduce 16-bit PNGs to 8-bit even when lossy")
+	batchCmd.Flags().Bool("force", false, "Re-encode JPEGs even when already at or below the target quality")
+	AddFilterFlags(batchCmd, "0")
+	addDryRunFlags(batchCmd)
+	batchCmd.Flags().Bool("rescan", false, "Optimize files the ledger has as optimized and unchanged since")
+	batchCmd.Flags().Duration("image-timeout", 0, "Per-image optimization deadline (e.g. 30s); 0 disables")
 }
@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/juparave/photoptim/internal/bench"
+	"github.com/juparave/photoptim/internal/optimizer"
+
+	"github.com/spf13/cobra"
+)
+
+var benchCmd = &cobra.Command{
+	Use:   "bench [image|directory]",
+	Short: "Measure size vs. quality across JPEG qualities and settings",
+	Long: `Encode each image across a range of qualities and settings and report
+output size, SSIM/PSNR and encode time. The knee of the size-vs-SSIM curve is
+suggested as a quality setting. Nothing is written to disk.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		qualitySpec, _ := cmd.Flags().GetString("qualities")
+		settingSpecs, _ := cmd.Flags().GetStringSlice("settings")
+		format, _ := cmd.Flags().GetString("format")
+
+		qualities, err := bench.ParseQualities(qualitySpec)
+		if err != nil {
+			return err
+		}
+		settings := make([]bench.Setting, 0, len(settingSpecs))
+		for _, spec := range settingSpecs {
+			s, err := bench.ParseSetting(spec)
+			if err != nil {
+				return err
+			}
+			settings = append(settings, s)
+		}
+
+		files, err := imageFiles(args[0])
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no images found in %s", args[0])
+		}
+
+		opt := optimizer.New()
+		opt.Logger = logger
+		var points []bench.Point
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(file)), ".")
+			logger.Info("bench file", "file", file, "qualities", len(qualities), "settings", len(settings))
+			pts, err := bench.Run(cmd.Context(), opt, file, data, ext, qualities, settings)
+			if err != nil {
+				return err
+			}
+			points = append(points, pts...)
+		}
+
+		curves := bench.Curves(points)
+		out := cmd.OutOrStdout()
+		switch format {
+		case "table":
+			return writeBenchTable(out, curves)
+		case "csv":
+			return writeBenchCSV(out, points)
+		case "json":
+			return writeBenchJSON(out, points, curves)
+		}
+		return fmt.Errorf("unknown format %q (want table, csv or json)", format)
+	},
+}
+
+// imageFiles returns path itself, or the supported images directly inside it.
+func imageFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := filepath.Glob(filepath.Join(path, "*"))
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e))
+		if ext == ".jpg" || ext == ".jpeg" || ext == ".png" {
+			files = append(files, e)
+		}
+	}
+	return files, nil
+}
+
+func writeBenchTable(w io.Writer, curves []bench.Curve) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tQUALITY\tBYTES\tRATIO\tSSIM\tPSNR\tENCODE\t")
+	for _, c := range curves {
+		knee := bench.Knee(c.Points)
+		for i, p := range c.Points {
+			mark := ""
+			if i == knee && len(c.Points) > 1 {
+				mark = "← knee"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f%%\t%.4f\t%.2fdB\t%s\t%s\n", c.Setting, qualityLabel(p.Quality), p.Bytes, p.Ratio()*100, p.SSIM, p.PSNR, p.EncodeTime.Round(1e6), mark)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	for _, c := range curves {
+		if len(c.Points) < 2 {
+			continue
+		}
+		p := c.Points[bench.Knee(c.Points)]
+		fmt.Fprintf(w, "Suggested for %s: --quality %d (%.1f%% of original size, SSIM %.4f)\n", c.Setting, p.Quality, p.Ratio()*100, p.SSIM)
+	}
+	return nil
+}
+
+func writeBenchCSV(w io.Writer, points []bench.Point) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"file", "setting", "quality", "original_bytes", "bytes", "ssim", "psnr", "encode_ms"})
+	for _, p := range points {
+		_ = cw.Write([]string{
+			p.File, p.Setting, strconv.Itoa(p.Quality),
+			strconv.FormatInt(p.OriginalBytes, 10), strconv.FormatInt(p.Bytes, 10),
+			strconv.FormatFloat(p.SSIM, 'f', 5, 64), strconv.FormatFloat(p.PSNR, 'f', 3, 64),
+			strconv.FormatInt(p.EncodeTime.Milliseconds(), 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeBenchJSON(w io.Writer, points []bench.Point, curves []bench.Curve) error {
+	type suggestion struct {
+		Setting string  `json:"setting"`
+		Quality int     `json:"quality"`
+		Ratio   float64 `json:"ratio"`
+		SSIM    float64 `json:"ssim"`
+	}
+	report := struct {
+		Points      []bench.Point `json:"points"`
+		Suggestions []suggestion  `json:"suggestions"`
+	}{Points: points, Suggestions: []suggestion{}}
+	for _, c := range curves {
+		if len(c.Points) < 2 {
+			continue
+		}
+		p := c.Points[bench.Knee(c.Points)]
+		report.Suggestions = append(report.Suggestions, suggestion{Setting: c.Setting, Quality: p.Quality, Ratio: p.Ratio(), SSIM: p.SSIM})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func qualityLabel(q int) string {
+	if q == 0 {
+		return "lossless"
+	}
+	return strconv.Itoa(q)
+}
+
+func init() {
+	rootCmd.AddCommand(benchCmd)
+	benchCmd.Flags().String("qualities", "40-95:5", "Qualities to sweep: ranges with step (40-95:5) and/or lists (70,80,90)")
+	benchCmd.Flags().StringSlice("settings", []string{"default"}, "Settings to compare: default, no-gray, gray:N, max:WxH")
+	benchCmd.Flags().String("format", "table", "Output format: table, csv or json")
+}
@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+
+	"github.com/juparave/photoptim/internal/similarity"
+
+	"github.com/spf13/cobra"
+)
+
+// visibleDiff is the per-pixel difference (fraction of full scale) counted as
+// a changed pixel in the summary.
+const visibleDiff = 0.02
+
+// compareSummary is the metrics block printed by compare.
+type compareSummary struct {
+	Original      string  `json:"original"`
+	Optimized     string  `json:"optimized"`
+	OriginalSize  int64   `json:"originalSize"`
+	OptimizedSize int64   `json:"optimizedSize"`
+	Width         int     `json:"width"`
+	Height        int     `json:"height"`
+	PSNR          float64 `json:"psnr"`
+	SSIM          float64 `json:"ssim"`
+	MeanDiff      float64 `json:"meanDiff"`
+	MaxDiff       float64 `json:"maxDiff"`
+	ChangedPixels float64 `json:"changedPixels"` // fraction with a difference above visibleDiff
+	WorstSSIM     float64 `json:"worstSsim"`
+	WorstX        int     `json:"worstX"`
+	WorstY        int     `json:"worstY"`
+	Output        string  `json:"output,omitempty"`
+}
+
+var compareCmd = &cobra.Command{
+	Use:   "compare [original] [optimized]",
+	Short: "Show where an optimized image differs from its original",
+	Long: `Compute per-pixel difference and SSIM maps between two images of the same
+size, print summary metrics and write a heatmap, side-by-side or slider
+composite PNG.
+
+Either image may be remote: sftp://user@host[:port]/path. Use --key or
+--password for credentials (ssh-agent is tried first).`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mode, _ := cmd.Flags().GetString("mode")
+		mapKind, _ := cmd.Flags().GetString("map")
+		scale, _ := cmd.Flags().GetFloat64("scale")
+		split, _ := cmd.Flags().GetFloat64("split")
+		output, _ := cmd.Flags().GetString("output")
+		format, _ := cmd.Flags().GetString("format")
+		switch mode {
+		case "heatmap", "side-by-side", "slider":
+		default:
+			return fmt.Errorf("unknown mode %q (want heatmap, side-by-side or slider)", mode)
+		}
+		if mapKind != "ssim" && mapKind != "diff" {
+			return fmt.Errorf("unknown map %q (want ssim or diff)", mapKind)
+		}
+		if format != "text" && format != "json" {
+			return fmt.Errorf("unknown format %q (want text or json)", format)
+		}
+
+		srcs := newSources(cmd)
+		defer srcs.Close()
+		imgs := [2]image.Image{}
+		sum := compareSummary{Original: args[0], Optimized: args[1], Output: output}
+		for i, arg := range args {
+			src, err := parseSource(arg)
+			if err != nil {
+				return err
+			}
+			data, err := srcs.ReadAll(cmd.Context(), src)
+			if err != nil {
+				return err
+			}
+			img, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				return fmt.Errorf("decode %s: %w", arg, err)
+			}
+			imgs[i] = img
+			if i == 0 {
+				sum.OriginalSize = int64(len(data))
+			} else {
+				sum.OptimizedSize = int64(len(data))
+			}
+		}
+		ref, dist := imgs[0], imgs[1]
+		if ref.Bounds().Size() != dist.Bounds().Size() {
+			return fmt.Errorf("images differ in size (%v vs %v); compare needs the same dimensions", ref.Bounds().Size(), dist.Bounds().Size())
+		}
+		sum.Width, sum.Height = ref.Bounds().Dx(), ref.Bounds().Dy()
+
+		scores, err := similarity.Compare(ref, dist)
+		if err != nil {
+			return err
+		}
+		diff, err := similarity.DiffMap(ref, dist)
+		if err != nil {
+			return err
+		}
+		ssim, err := similarity.SSIMMap(ref, dist)
+		if err != nil {
+			return err
+		}
+		sum.PSNR, sum.SSIM = scores.PSNR, scores.SSIM
+		sum.MeanDiff = diff.Mean()
+		sum.MaxDiff, _ = diff.Max()
+		sum.ChangedPixels = diff.Above(visibleDiff)
+		worst, at := ssim.Max()
+		sum.WorstSSIM, sum.WorstX, sum.WorstY = 1-worst, at.X, at.Y
+
+		m := ssim
+		if mapKind == "diff" {
+			m = diff
+		}
+		heat := similarity.Heatmap(m, scale)
+		var composite image.Image = heat
+		switch mode {
+		case "side-by-side":
+			composite = similarity.SideBySide(ref, dist, heat)
+		case "slider":
+			composite = similarity.Slider(ref, dist, split)
+		}
+		if output != "" {
+			if err := writePNG(output, composite); err != nil {
+				return err
+			}
+		}
+
+		out := cmd.OutOrStdout()
+		if format == "json" {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(sum)
+		}
+		writeCompareText(out, sum)
+		return nil
+	},
+}
+
+func writeCompareText(w io.Writer, s compareSummary) {
+	fmt.Fprintf(w, "Original:   %s (%d bytes)\n", s.Original, s.OriginalSize)
+	fmt.Fprintf(w, "Optimized:  %s (%d bytes, %.1f%%)\n", s.Optimized, s.OptimizedSize, 100*float64(s.OptimizedSize)/float64(max(1, s.OriginalSize)))
+	fmt.Fprintf(w, "Dimensions: %dx%d\n", s.Width, s.Height)
+	fmt.Fprintf(w, "PSNR:       %.2f dB\n", s.PSNR)
+	fmt.Fprintf(w, "SSIM:       %.4f (worst %.4f at %d,%d)\n", s.SSIM, s.WorstSSIM, s.WorstX, s.WorstY)
+	fmt.Fprintf(w, "Difference: mean %.2f%%, max %.2f%%, %.2f%% of pixels above %.0f%%\n", 100*s.MeanDiff, 100*s.MaxDiff, 100*s.ChangedPixels, 100*visibleDiff)
+	if s.Output != "" {
+		fmt.Fprintf(w, "Wrote %s\n", s.Output)
+	}
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("encode %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+	compareCmd.Flags().StringP("output", "o", "compare.png", "Composite PNG to write; empty prints metrics only")
+	compareCmd.Flags().String("mode", "heatmap", "Composite: heatmap, side-by-side (original|optimized|heatmap) or slider")
+	compareCmd.Flags().String("map", "ssim", "Error map for the heatmap: ssim (1-SSIM per window) or diff (max channel difference)")
+	compareCmd.Flags().Float64("scale", 0, "Error mapped to the hottest color (0-1); 0 = the map's maximum")
+	compareCmd.Flags().Float64("split", 0.5, "Slider position as a fraction of the width")
+	compareCmd.Flags().String("format", "text", "Metrics output: text or json")
+	addSourceFlags(compareCmd)
+}
@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/juparave/photoptim/internal/optimizer"
+)
+
+// Dry-run file statuses.
+const (
+	dryRunOptimize = "would-optimize"
+	dryRunKeep     = "would-keep" // the original is already as good as it gets
+	dryRunSkip     = "would-skip"
+	dryRunFail     = "failed"
+)
+
+// dryRunFile is the projected outcome of one file.
+type dryRunFile struct {
+	Path          string        `json:"path"`
+	Status        string        `json:"status"`
+	Reason        string        `json:"reason,omitempty"`
+	Error         string        `json:"error,omitempty"`
+	OriginalSize  int64         `json:"originalSize"`
+	ProjectedSize int64         `json:"projectedSize"`
+	Savings       float64       `json:"savings"` // fraction of OriginalSize
+	Duration      time.Duration `json:"durationNs"`
+}
+
+// dryRunReport collects what a --dry-run would have done.
+type dryRunReport struct {
+	Files          []dryRunFile  `json:"files"`
+	Optimized      int           `json:"optimized"`
+	Kept           int           `json:"kept"`
+	Skipped        int           `json:"skipped"`
+	Failed         int           `json:"failed"`
+	OriginalBytes  int64         `json:"originalBytes"`  // of optimized and kept files
+	ProjectedBytes int64         `json:"projectedBytes"` // of optimized and kept files
+	SavedBytes     int64         `json:"savedBytes"`
+	Savings        float64       `json:"savings"`
+	Duration       time.Duration `json:"durationNs"`
+
+	started time.Time
+}
+
+func newDryRunReport() *dryRunReport {
+	return &dryRunReport{Files: []dryRunFile{}, started: time.Now()}
+}
+
+// skip records a file left out before optimizing.
+func (r *dryRunReport) skip(path string, size int64, reason string) {
+	r.add(dryRunFile{Path: path, Status: dryRunSkip, Reason: reason, OriginalSize: size, ProjectedSize: size})
+}
+
+// result records the outcome of optimizing path, of size bytes, in memory.
+func (r *dryRunReport) result(path string, size int64, res optimizer.Result, err error, d time.Duration) {
+	if res.OriginalSize == 0 {
+		res.OriginalSize = size
+	}
+	f := dryRunFile{Path: path, Reason: res.Reason, OriginalSize: res.OriginalSize, ProjectedSize: res.OptimizedSize, Duration: d}
+	switch {
+	case err != nil:
+		f.Status, f.Error, f.ProjectedSize = dryRunFail, err.Error(), res.OriginalSize
+	case res.KeptOriginal():
+		f.Status, f.ProjectedSize = dryRunKeep, res.OriginalSize
+	case res.Skipped:
+		f.Status, f.ProjectedSize = dryRunSkip, res.OriginalSize
+	default:
+		f.Status = dryRunOptimize
+	}
+	r.add(f)
+}
+
+func (r *dryRunReport) add(f dryRunFile) {
+	if f.OriginalSize > 0 {
+		f.Savings = 1 - float64(f.ProjectedSize)/float64(f.OriginalSize)
+	}
+	switch f.Status {
+	case dryRunOptimize:
+		r.Optimized++
+	case dryRunKeep:
+		r.Kept++
+	case dryRunSkip:
+		r.Skipped++
+	default:
+		r.Failed++
+	}
+	if f.Status == dryRunOptimize || f.Status == dryRunKeep {
+		r.OriginalBytes += f.OriginalSize
+		r.ProjectedBytes += f.ProjectedSize
+		r.SavedBytes = r.OriginalBytes - r.ProjectedBytes
+		if r.OriginalBytes > 0 {
+			r.Savings = float64(r.SavedBytes) / float64(r.OriginalBytes)
+		}
+	}
+	r.Files = append(r.Files, f)
+}
+
+// write prints the report as a table or JSON.
+func (r *dryRunReport) write(w io.Writer, format string) error {
+	r.Duration = time.Since(r.started)
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tSTATUS\tORIGINAL\tPROJECTED\tSAVINGS\tTIME\tREASON\t")
+	for _, f := range r.Files {
+		reason := f.Reason
+		if f.Error != "" {
+			reason = f.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.1f%%\t%s\t%s\t\n", f.Path, f.Status, humanBytes(f.OriginalSize), humanBytes(f.ProjectedSize), f.Savings*100, f.Duration.Round(time.Millisecond), reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\nDry run: %d would be optimized, %d kept, %d skipped, %d failed; projected %s -> %s, saving %s (%.1f%%) in %s; nothing was written\n",
+		r.Optimized, r.Kept, r.Skipped, r.Failed, humanBytes(r.OriginalBytes), humanBytes(r.ProjectedBytes), humanBytes(r.SavedBytes), r.Savings*100, r.Duration.Round(time.Millisecond))
+	return err
+}
+
+// addDryRunFlags registers --dry-run and its report --format.
+func addDryRunFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("dry-run", false, "Optimize in memory only and report projected savings; nothing is written")
+	cmd.Flags().String("format", "table", "Dry-run report format: table or json")
+}
+
+// dryRunFromFlags returns --dry-run and a validated --format.
+func dryRunFromFlags(cmd *cobra.Command) (bool, string, error) {
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	format, _ := cmd.Flags().GetString("format")
+	if format != "table" && format != "json" {
+		return false, "", fmt.Errorf("unknown format %q (want table or json)", format)
+	}
+	return dryRun, format, nil
+}
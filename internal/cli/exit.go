@@ -0,0 +1,31 @@
+package cli
+
+import "fmt"
+
+// Exit codes for non-interactive runs (see SFTP_EXTENSION_PRD.md).
+const (
+	ExitOK             = 0
+	ExitNothingToDo    = 3   // no file needed optimizing: none found, or all skipped or kept
+	ExitPartialFailure = 4   // some files failed
+	ExitConnection     = 5   // connection / authentication failure
+	ExitInternal       = 6   // internal pipeline error
+	ExitInterrupted    = 130 // interrupted by SIGINT or SIGTERM (128 + SIGINT)
+)
+
+// ExitError carries a process exit code out of a command. A nil Err means
+// the command already reported the outcome and only the code matters.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+func exitErr(code int, err error) error { return &ExitError{Code: code, Err: err} }
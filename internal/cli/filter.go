@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/juparave/photoptim/internal/filter"
+)
+
+// AddFilterFlags registers the file selection flags shared by batch, sftp
+// and the local TUI binary. minSize is the --size-threshold default: sftp
+// skips tiny files ("15KB"), the local commands process everything ("0").
+func AddFilterFlags(cmd *cobra.Command, minSize string) {
+	cmd.Flags().String("size-threshold", minSize, "Inclusive minimum size e.g. 500KB, 2MB; 0 = all")
+	cmd.Flags().String("max-size", "", "Inclusive maximum size e.g. 40MB; empty = none")
+	cmd.Flags().StringSlice("include", nil, "Only process files whose path or name matches one of these globs")
+	cmd.Flags().StringSlice("exclude", nil, "Skip files whose path or name matches one of these globs")
+	cmd.Flags().String("modified-after", "", "Only process files modified after this date (2006-01-02), RFC 3339 time or age (36h, 30d)")
+	cmd.Flags().String("modified-before", "", "Only process files modified before this date (2006-01-02), RFC 3339 time or age (36h, 30d)")
+	cmd.Flags().String("ext", "", "Comma-separated extensions to process (default jpg,jpeg,png)")
+	cmd.Flags().String("min-dimensions", "", "Skip images smaller than WxH, read from the image header (e.g. 800x600)")
+	cmd.Flags().Bool("hidden", false, "Process dotfiles too")
+}
+
+// FilterFromFlags builds the selection rules from the AddFilterFlags flags.
+func FilterFromFlags(cmd *cobra.Command) (filter.Rules, error) {
+	var r filter.Rules
+	var err error
+	minSize, _ := cmd.Flags().GetString("size-threshold")
+	if r.MinSize, err = filter.ParseSize(minSize); err != nil {
+		return r, fmt.Errorf("--size-threshold: %w", err)
+	}
+	maxSize, _ := cmd.Flags().GetString("max-size")
+	if r.MaxSize, err = filter.ParseSize(maxSize); err != nil {
+		return r, fmt.Errorf("--max-size: %w", err)
+	}
+	r.Include, _ = cmd.Flags().GetStringSlice("include")
+	r.Exclude, _ = cmd.Flags().GetStringSlice("exclude")
+	now := time.Now()
+	after, _ := cmd.Flags().GetString("modified-after")
+	if r.ModifiedAfter, err = filter.ParseTime(after, now); err != nil {
+		return r, fmt.Errorf("--modified-after: %w", err)
+	}
+	before, _ := cmd.Flags().GetString("modified-before")
+	if r.ModifiedBefore, err = filter.ParseTime(before, now); err != nil {
+		return r, fmt.Errorf("--modified-before: %w", err)
+	}
+	ext, _ := cmd.Flags().GetString("ext")
+	r.Extensions = filter.ParseExtensions(ext)
+	dims, _ := cmd.Flags().GetString("min-dimensions")
+	if r.MinWidth, r.MinHeight, err = filter.ParseDimensions(dims); err != nil {
+		return r, fmt.Errorf("--min-dimensions: %w", err)
+	}
+	r.Hidden, _ = cmd.Flags().GetBool("hidden")
+	return r, nil
+}
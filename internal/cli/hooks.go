@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/juparave/photoptim/internal/config"
+	"github.com/juparave/photoptim/internal/pipeline"
+)
+
+// addHookFlags registers the run hook flags. Unset flags fall back to the
+// hooks section of the config file.
+func addHookFlags(cmd *cobra.Command) {
+	cmd.Flags().String("pre-run", "", "Shell command run before a batch run starts (config: hooks.pre_run)")
+	cmd.Flags().String("post-file", "", "Shell command run after every file; gets PHOTOPTIM_PATH, PHOTOPTIM_STATUS, sizes and a JSON event on stdin (config: hooks.post_file)")
+	cmd.Flags().String("post-run", "", "Shell command run after a batch run with its summary, e.g. to purge a CDN (config: hooks.post_run)")
+	cmd.Flags().String("hook-timeout", "", "Time limit of each hook command; empty = 30s (config: hooks.timeout)")
+	cmd.Flags().Bool("hooks-required", false, "Abort the run when a hook fails or times out instead of only reporting it (config: hooks.required)")
+}
+
+// hooksFromFlags builds the pipeline hooks from the addHookFlags flags and
+// the config file.
+func hooksFromFlags(cmd *cobra.Command) (pipeline.Hooks, error) {
+	var hooks pipeline.Hooks
+	file, err := config.Load(config.ResolvePaths().ConfigFile)
+	if err != nil {
+		return hooks, fmt.Errorf("config: %w", err)
+	}
+	setting := func(flag, key string) string {
+		if cmd.Flags().Changed(flag) {
+			return cmd.Flags().Lookup(flag).Value.String()
+		}
+		return file[key]
+	}
+	var timeout time.Duration
+	if s := setting("hook-timeout", "hooks.timeout"); s != "" {
+		if timeout, err = time.ParseDuration(s); err != nil || timeout <= 0 {
+			return hooks, fmt.Errorf("invalid --hook-timeout %q", s)
+		}
+	}
+	var required bool
+	if s := setting("hooks-required", "hooks.required"); s != "" {
+		if required, err = strconv.ParseBool(s); err != nil {
+			return hooks, fmt.Errorf("invalid --hooks-required %q", s)
+		}
+	}
+	for _, h := range []struct {
+		flag, key string
+		dst       *[]pipeline.Hook
+	}{
+		{"pre-run", "hooks.pre_run", &hooks.PreRun},
+		{"post-file", "hooks.post_file", &hooks.PostFile},
+		{"post-run", "hooks.post_run", &hooks.PostRun},
+	} {
+		if c := setting(h.flag, h.key); c != "" {
+			*h.dst = []pipeline.Hook{{Name: h.flag, Command: c, Timeout: timeout, Required: required}}
+		}
+	}
+	return hooks, nil
+}
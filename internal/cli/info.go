@@ -0,0 +1,189 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/juparave/photoptim/internal/imageinfo"
+	"github.com/juparave/photoptim/internal/optimizer"
+
+	"github.com/spf13/cobra"
+)
+
+// infoReport is the output of the info command for one file.
+type infoReport struct {
+	File string `json:"file"`
+	Size int64  `json:"size"`
+	*imageinfo.Info
+	Estimate *savingsEstimate `json:"estimate,omitempty"`
+}
+
+// savingsEstimate is what optimizing with the current defaults would do.
+type savingsEstimate struct {
+	Quality       int     `json:"quality"`
+	OptimizedSize int64   `json:"optimizedSize"`
+	Savings       float64 `json:"savings"` // fraction of the original size
+	Reason        string  `json:"reason,omitempty"`
+	SSIM          float64 `json:"ssim,omitempty"`
+	PSNR          float64 `json:"psnr,omitempty"`
+	Error         string  `json:"error,omitempty"`
+}
+
+var infoCmd = &cobra.Command{
+	Use:   "info [image...]",
+	Short: "Inspect images without modifying them",
+	Long: `Print format, dimensions, color model and bit depth, estimated JPEG quality
+and subsampling, progressive/interlaced encoding, metadata present (EXIF, GPS,
+ICC, XMP, ...) with sizes, and the savings optimizing at --quality would give.
+
+Images may be remote: sftp://user@host[:port]/path. Use --key or --password
+for credentials (ssh-agent is tried first).`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		quality, _ := cmd.Flags().GetInt("quality")
+		estimate, _ := cmd.Flags().GetBool("estimate")
+		if format != "text" && format != "json" {
+			return fmt.Errorf("unknown format %q (want text or json)", format)
+		}
+
+		srcs := newSources(cmd)
+		defer srcs.Close()
+		opt := optimizer.New()
+		opt.Logger = logger
+		reports := make([]infoReport, 0, len(args))
+		for _, arg := range args {
+			src, err := parseSource(arg)
+			if err != nil {
+				return err
+			}
+			data, err := srcs.ReadAll(cmd.Context(), src)
+			if err != nil {
+				return err
+			}
+			info, err := imageinfo.Inspect(bytes.NewReader(data))
+			if err != nil {
+				return fmt.Errorf("%s: %w", arg, err)
+			}
+			rep := infoReport{File: arg, Size: int64(len(data)), Info: info}
+			if estimate {
+				rep.Estimate = estimateSavings(cmd, opt, data, info.Format, quality)
+			}
+			reports = append(reports, rep)
+		}
+
+		out := cmd.OutOrStdout()
+		if format == "json" {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			if len(reports) == 1 {
+				return enc.Encode(reports[0])
+			}
+			return enc.Encode(reports)
+		}
+		for i, rep := range reports {
+			if i > 0 {
+				fmt.Fprintln(out)
+			}
+			writeInfoText(out, rep)
+		}
+		return nil
+	},
+}
+
+func estimateSavings(cmd *cobra.Command, opt *optimizer.ImageOptimizer, data []byte, format string, quality int) *savingsEstimate {
+	_, res, err := opt.OptimizeBytes(cmd.Context(), data, format, optimizer.Params{JPEGQuality: quality, Score: true})
+	est := &savingsEstimate{Quality: quality, OptimizedSize: res.OptimizedSize, Reason: res.Reason, SSIM: res.SSIM, PSNR: res.PSNR}
+	if err != nil {
+		est.Error = err.Error()
+		return est
+	}
+	if res.KeptOriginal() {
+		est.OptimizedSize = res.OriginalSize
+	}
+	if res.OriginalSize > 0 {
+		est.Savings = 1 - float64(est.OptimizedSize)/float64(res.OriginalSize)
+	}
+	return est
+}
+
+func writeInfoText(w io.Writer, r infoReport) {
+	fmt.Fprintf(w, "File:        %s (%d bytes)\n", r.File, r.Size)
+	enc := "baseline"
+	if r.Format == "png" {
+		enc = "non-interlaced"
+		if r.Progressive {
+			enc = "interlaced"
+		}
+	} else if r.Progressive {
+		enc = "progressive"
+	}
+	fmt.Fprintf(w, "Format:      %s (%s)\n", strings.ToUpper(r.Format), enc)
+	fmt.Fprintf(w, "Dimensions:  %dx%d\n", r.Width, r.Height)
+	color := fmt.Sprintf("%s, %d-bit", r.ColorModel, r.BitDepth)
+	if r.Subsampling != "" {
+		color += ", " + r.Subsampling
+	}
+	fmt.Fprintf(w, "Color:       %s\n", color)
+	if r.Quality > 0 {
+		how := "closest IJG match"
+		if r.QualityExact {
+			how = "IJG tables"
+		}
+		fmt.Fprintf(w, "Quality:     ~%d (%s)\n", r.Quality, how)
+	}
+	if r.Orientation > 1 {
+		fmt.Fprintf(w, "Orientation: %d\n", r.Orientation)
+	}
+	md := make([]string, 0, len(r.Metadata))
+	for _, m := range r.Metadata {
+		s := fmt.Sprintf("%s %s", m.Kind, humanBytes(m.Size))
+		if m.Count > 1 {
+			s += fmt.Sprintf(" in %d blocks", m.Count)
+		}
+		if m.Kind == "EXIF" && r.GPS {
+			s += " (with GPS)"
+		}
+		md = append(md, s)
+	}
+	if len(md) == 0 {
+		md = append(md, "none")
+	}
+	fmt.Fprintf(w, "Metadata:    %s\n", strings.Join(md, "; "))
+	if e := r.Estimate; e != nil {
+		switch {
+		case e.Error != "":
+			fmt.Fprintf(w, "Estimate:    q%d failed: %s\n", e.Quality, e.Error)
+		case e.Reason == "already-optimized" || e.Reason == "no-compression-gain":
+			fmt.Fprintf(w, "Estimate:    q%d keeps the original (%s)\n", e.Quality, e.Reason)
+		default:
+			fmt.Fprintf(w, "Estimate:    q%d -> %d bytes (%.1f%% smaller, SSIM %.4f)", e.Quality, e.OptimizedSize, 100*e.Savings, e.SSIM)
+			if e.Reason != "" {
+				fmt.Fprintf(w, ", %s", e.Reason)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// humanBytes formats n with a binary unit.
+func humanBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+func init() {
+	rootCmd.AddCommand(infoCmd)
+	infoCmd.Flags().String("format", "text", "Output: text or json")
+	infoCmd.Flags().IntP("quality", "q", 80, "JPEG quality used for the savings estimate")
+	infoCmd.Flags().Bool("estimate", true, "Estimate savings by optimizing in memory")
+	addSourceFlags(infoCmd)
+}
@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"io"
+	"os"
+
+	"github.com/juparave/photoptim/internal/logging"
+
+	"github.com/spf13/cobra"
+)
+
+// logger is configured from the persistent logging flags before any command
+// runs. Library code receives it explicitly; it never writes to stdout.
+var (
+	logger   = logging.Discard()
+	closeLog = func() error { return nil }
+)
+
+// setupLogging builds the process logger from the persistent flags.
+func setupLogging(cmd *cobra.Command) error {
+	verbose, _ := cmd.Flags().GetBool("verbose")
+	level, _ := cmd.Flags().GetString("log-level")
+	file, _ := cmd.Flags().GetString("log-file")
+
+	var console io.Writer = os.Stderr
+	if !consoleLogging(cmd) {
+		console = nil
+	}
+	l, closeFn, err := logging.New(logging.Options{Level: level, Verbose: verbose, File: file, Console: console})
+	if err != nil {
+		return err
+	}
+	logger, closeLog = l, closeFn
+	return nil
+}
+
+// consoleLogging reports whether cmd may log to the terminal. Interactive
+// TUIs own the terminal, so they only log to --log-file.
+func consoleLogging(cmd *cobra.Command) bool {
+	if cmd == sftpCmd {
+		batch, _ := cmd.Flags().GetBool("batch")
+		return batch
+	}
+	return true
+}
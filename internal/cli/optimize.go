@@ -2,6 +2,9 @@ package cli
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/juparave/photoptim/internal/optimizer"
 
@@ -26,13 +29,39 @@ var optimizeCmd = &cobra.Command{
 			return err
 		}
 		opt.Quality = quality
+		opt.GrayTolerance, _ = cmd.Flags().GetInt("gray-tolerance")
+		opt.Allow16To8, _ = cmd.Flags().GetBool("reduce-16bit")
+		opt.ForceReencode, _ = cmd.Flags().GetBool("force")
+		opt.Timeout, _ = cmd.Flags().GetDuration("image-timeout")
+
+		opt.Logger = logger
+
+		dryRun, format, err := dryRunFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			rep := newDryRunReport()
+			var size int64
+			if fi, err := os.Stat(inputPath); err == nil {
+				size = fi.Size()
+			}
+			res, err := opt.Estimate(inputPath)
+			rep.result(filepath.Base(inputPath), size, res, err, time.Since(rep.started))
+			return rep.write(cmd.OutOrStdout(), format)
+		}
 
 		// Optimize image
-		if err := opt.Optimize(inputPath, outputPath); err != nil {
+		res, err := opt.Optimize(inputPath, outputPath)
+		if err != nil {
 			return fmt.Errorf("optimization failed: %w", err)
 		}
+		if res.KeptOriginal() {
+			fmt.Printf("Skipped %s: %s\n", filepath.Base(inputPath), keptReason(res))
+			return nil
+		}
 
-		fmt.Printf("Successfully optimized %s -> %s\n", inputPath, outputPath)
+		fmt.Printf("Successfully optimized %s (%d bytes) -> %s (%d bytes)\n", inputPath, res.OriginalSize, outputPath, res.OptimizedSize)
 		return nil
 	},
 }
@@ -40,4 +69,17 @@ var optimizeCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(optimizeCmd)
 	optimizeCmd.Flags().IntP("quality", "q", 80, "Quality for JPEG compression (1-100)")
+	optimizeCmd.Flags().Int("gray-tolerance", 0, "Max channel spread (0-255) still encoded as grayscale; -1 disables")
+	optimizeCmd.Flags().Bool("reduce-16bit", false, "Reduce 16-bit PNGs to 8-bit even when lossy")
+	optimizeCmd.Flags().Bool("force", false, "Re-encode JPEGs even when already at or below the target quality")
+	optimizeCmd.Flags().Duration("image-timeout", 0, "Per-image optimization deadline (e.g. 30s); 0 disables")
+	addDryRunFlags(optimizeCmd)
+}
+
+// keptReason explains why the original was kept.
+func keptReason(res optimizer.Result) string {
+	if res.Reason == "already-optimized" {
+		return fmt.Sprintf("already optimized (estimated JPEG quality %d; use --force to re-encode)", res.Quality)
+	}
+	return "no compression gain (original is smaller or equal)"
 }
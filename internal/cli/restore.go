@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/juparave/photoptim/internal/backup"
+	"github.com/juparave/photoptim/internal/remotefs"
+	sftpfs "github.com/juparave/photoptim/internal/sftp"
+
+	"github.com/spf13/cobra"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Put back the originals an sftp --batch run replaced",
+	Long: `Restore copies the originals saved by "sftp --batch --backup remote|local"
+back over the optimized files, keeping their mode and mtime. Connect with the
+same --host, --user and --remote-path as the run. --list shows the runs with
+backups.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		host, _ := cmd.Flags().GetString("host")
+		port, _ := cmd.Flags().GetInt("port")
+		user, _ := cmd.Flags().GetString("user")
+		remotePath, _ := cmd.Flags().GetString("remote-path")
+		keyPath, _ := cmd.Flags().GetString("key")
+		password, _ := cmd.Flags().GetString("password")
+		runID, _ := cmd.Flags().GetString("run")
+		from, _ := cmd.Flags().GetString("from")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		list, _ := cmd.Flags().GetBool("list")
+
+		missing := []string{}
+		if host == "" {
+			missing = append(missing, "--host")
+		}
+		if user == "" {
+			missing = append(missing, "--user")
+		}
+		if runID == "" && !list {
+			missing = append(missing, "--run")
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing required flags: %s", strings.Join(missing, ", "))
+		}
+		if runID != "" && !backup.ValidRunID(runID) {
+			return fmt.Errorf("invalid --run %q", runID)
+		}
+		if from != "remote" && from != "local" {
+			return fmt.Errorf("invalid --from %q (want remote or local)", from)
+		}
+
+		cmd.SilenceUsage, cmd.SilenceErrors = true, true
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		cfg := remotefs.ConnectionConfig{Host: host, Port: port, User: user, Password: password, KeyPath: keyPath, RemotePath: remotePath}
+		client := &sftpfs.Client{Logger: logger}
+		connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		if err := client.Connect(connectCtx, cfg); err != nil {
+			return exitErr(ExitConnection, fmt.Errorf("sftp connect failed: %w", err))
+		}
+		defer client.Close()
+
+		out := cmd.OutOrStdout()
+		if list {
+			return listBackupRuns(ctx, client, from, out)
+		}
+		var store backup.Store = backup.NewLocal(runID)
+		if from == "remote" {
+			store = backup.NewRemote(client, runID)
+		}
+		return runRestore(ctx, client, store, dryRun, out)
+	},
+}
+
+// runRestore restores every original in store onto fsys, printing one line
+// per file and a summary, and returns an *ExitError for anything but full
+// success.
+func runRestore(ctx context.Context, fsys remotefs.RemoteFS, store backup.Store, dryRun bool, out io.Writer) error {
+	var seen, failed int
+	n, err := backup.Restore(ctx, store, fsys, backup.RestoreOptions{
+		DryRun: dryRun,
+		Report: func(e remotefs.RemoteEntry, err error) {
+			seen++
+			switch {
+			case err != nil:
+				failed++
+				fmt.Fprintf(out, "FAIL %s: %v\n", e.Path, err)
+			case dryRun:
+				fmt.Fprintf(out, "WOULD RESTORE %s (%s)\n", e.Path, humanBytes(e.Size))
+			default:
+				fmt.Fprintf(out, "RESTORED %s (%s)\n", e.Path, humanBytes(e.Size))
+			}
+		},
+	})
+	switch {
+	case seen == 0 && (err == nil || errors.Is(err, fs.ErrNotExist)):
+		fmt.Fprintf(out, "No originals in %s\n", store.Location())
+		return exitErr(ExitNothingToDo, nil)
+	case seen == 0:
+		return exitErr(ExitInternal, fmt.Errorf("list %s: %w", store.Location(), err))
+	}
+	verb := "restored"
+	if dryRun {
+		verb = "would be restored"
+	}
+	fmt.Fprintf(out, "\nSummary: %d %s, %d failed\n", n, verb, failed)
+	switch {
+	case ctx.Err() != nil:
+		return exitErr(ExitInternal, fmt.Errorf("restore interrupted: %w", ctx.Err()))
+	case failed > 0:
+		return exitErr(ExitPartialFailure, fmt.Errorf("%d of %d originals not restored", failed, seen))
+	}
+	return nil
+}
+
+// listBackupRuns prints the run IDs that have backups.
+func listBackupRuns(ctx context.Context, fsys remotefs.RemoteFS, from string, out io.Writer) error {
+	var ids []string
+	var err error
+	if from == "remote" {
+		ids, err = backup.RemoteRuns(ctx, fsys)
+	} else {
+		ids, err = backup.LocalRuns()
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return exitErr(ExitInternal, err)
+	}
+	if len(ids) == 0 {
+		fmt.Fprintln(out, "No backups found")
+		return exitErr(ExitNothingToDo, nil)
+	}
+	for _, id := range ids {
+		fmt.Fprintln(out, id)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+	restoreCmd.Flags().String("host", "", "SFTP host")
+	restoreCmd.Flags().Int("port", 22, "SFTP port")
+	restoreCmd.Flags().String("user", "", "Username")
+	restoreCmd.Flags().String("remote-path", "/", "Remote path (chroot) the run used")
+	restoreCmd.Flags().String("key", "", "Private key path")
+	restoreCmd.Flags().String("password", "", "Password (fallback)")
+	restoreCmd.Flags().String("run", "", "Run ID to restore, as printed by sftp --batch")
+	restoreCmd.Flags().String("from", "remote", "Where the run kept its backups: remote or local")
+	restoreCmd.Flags().Bool("dry-run", false, "Only list what would be restored")
+	restoreCmd.Flags().Bool("list", false, "List the runs that have backups")
+}
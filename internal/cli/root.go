@@ -15,12 +15,25 @@ It supports various formats and optimization techniques.`,
 		fmt.Println("Welcome to Photoptim!")
 		fmt.Println("Use 'photoptim --help' for more information.")
 	},
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return setupLogging(cmd)
+	},
 }
 
-func Execute() error {
+// Execute runs the root command. The log file is closed afterwards even when
+// the command fails, so its last records are flushed.
+func Execute() (err error) {
+	defer func() {
+		if cerr := closeLog(); err == nil {
+			err = cerr
+		}
+	}()
 	return rootCmd.Execute()
 }
 
 func init() {
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "Verbose logging (same as --log-level debug)")
+	rootCmd.PersistentFlags().String("log-level", "", "Log level: debug, info, warn, error (default warn)")
+	rootCmd.PersistentFlags().String("log-file", "", "Also write JSON logs to this file")
 }
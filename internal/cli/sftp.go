@@ -3,10 +3,18 @@ package cli
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/juparave/photoptim/internal/backup"
+	"github.com/juparave/photoptim/internal/filter"
+	"github.com/juparave/photoptim/internal/journal"
+	"github.com/juparave/photoptim/internal/ledger"
 	"github.com/juparave/photoptim/internal/remotefs"
 	sftpfs "github.com/juparave/photoptim/internal/sftp"
 	"github.com/juparave/photoptim/internal/tui"
@@ -27,6 +35,10 @@ var sftpCmd = &cobra.Command{
 		keyPath, _ := cmd.Flags().GetString("key")
 		password, _ := cmd.Flags().GetString("password")
 		batch, _ := cmd.Flags().GetBool("batch")
+		limiter, err := throttleFromFlags(cmd)
+		if err != nil {
+			return err
+		}
 
 		if batch {
 			// batch mode validation
@@ -41,27 +53,145 @@ var sftpCmd = &cobra.Command{
 				return fmt.Errorf("missing required flags in batch mode: %s", strings.Join(missing, ", "))
 			}
 
-			// TODO: Implement full batch-mode pipeline here.
-			// For now, we can leave the existing connectivity test as a placeholder.
-			fmt.Printf("Connecting to %s@%s:%d (path=%s) ...\n", user, host, port, func() string {
+			quality, _ := cmd.Flags().GetInt("quality")
+			if quality < 1 || quality > 100 {
+				return fmt.Errorf("invalid --quality %d (want 1-100)", quality)
+			}
+			rules, err := FilterFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			recursive, _ := cmd.Flags().GetBool("recursive")
+			maxDepth, _ := cmd.Flags().GetInt("max-depth")
+			if maxDepth < 0 {
+				return fmt.Errorf("invalid --max-depth %d", maxDepth)
+			}
+			followSymlinks, _ := cmd.Flags().GetBool("follow-symlinks")
+			concurrencyFlag, _ := cmd.Flags().GetInt("concurrency")
+			concurrency, err := concurrencyFrom(concurrencyFlag, cmd.Flags().Changed("concurrency"))
+			if err != nil {
+				return err
+			}
+			encoders, _ := cmd.Flags().GetInt("encoders")
+			uploaders, _ := cmd.Flags().GetInt("uploaders")
+			if encoders < 0 || uploaders < 0 {
+				return fmt.Errorf("--encoders and --uploaders must not be negative")
+			}
+			maxMemoryStr, _ := cmd.Flags().GetString("max-memory")
+			maxMemory, err := filter.ParseSize(maxMemoryStr)
+			if err != nil {
+				return fmt.Errorf("invalid --max-memory %q: %w", maxMemoryStr, err)
+			}
+			ttlStr, _ := cmd.Flags().GetString("ttl")
+			ttl, err := time.ParseDuration(ttlStr)
+			if err != nil {
+				return fmt.Errorf("invalid --ttl %q: %w", ttlStr, err)
+			}
+			skipCache, _ := cmd.Flags().GetBool("skip-cache")
+			auditOn, _ := cmd.Flags().GetBool("audit")
+			imageTimeout, _ := cmd.Flags().GetDuration("image-timeout")
+			verifyHash, _ := cmd.Flags().GetBool("verify-hash")
+			touchModTime, _ := cmd.Flags().GetBool("touch-mtime")
+			rescan, _ := cmd.Flags().GetBool("rescan")
+			dryRun, format, err := dryRunFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			hooks, err := hooksFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			resume, _ := cmd.Flags().GetString("resume")
+			if resume != "" && !backup.ValidRunID(resume) {
+				return fmt.Errorf("invalid --resume %q", resume)
+			}
+			backupMode, _ := cmd.Flags().GetString("backup")
+			switch backupMode {
+			case "none", "remote", "local":
+			default:
+				return fmt.Errorf("invalid --backup %q (want none, remote or local)", backupMode)
+			}
+
+			// Flags are valid: from here on failures are reported through
+			// exit codes, not usage.
+			cmd.SilenceUsage, cmd.SilenceErrors = true, true
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			out := cmd.OutOrStdout()
+			status := out
+			if dryRun {
+				// Keep stdout for the report.
+				status = cmd.ErrOrStderr()
+			}
+			fmt.Fprintf(status, "Connecting to %s@%s:%d (path=%s) ...\n", user, host, port, func() string {
 				if remotePath == "" {
 					return "<home>"
 				}
 				return remotePath
 			}())
 			cfg := remotefs.ConnectionConfig{Host: host, Port: port, User: user, Password: password, KeyPath: keyPath, RemotePath: remotePath}
-			client := &sftpfs.Client{}
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			client := &sftpfs.Client{Logger: logger}
+			connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 			defer cancel()
-			if err := client.Connect(ctx, cfg); err != nil {
-				return fmt.Errorf("sftp connect failed: %w", err)
+			if err := client.Connect(connectCtx, cfg); err != nil {
+				return exitErr(ExitConnection, fmt.Errorf("sftp connect failed: %w", err))
 			}
 			defer client.Close()
-			fmt.Println("SFTP basic connectivity verified. (Batch pipeline not yet wired here.)")
+
+			opts := batchOptions{
+				Dir:            ".",
+				Quality:        quality,
+				Filter:         rules,
+				Recursive:      recursive,
+				MaxDepth:       maxDepth,
+				FollowSymlinks: followSymlinks,
+				Concurrency:    concurrency,
+				Throttle:       limiter,
+				Encoders:       encoders,
+				Uploaders:      uploaders,
+				MaxMemory:      maxMemory,
+				Hooks:          hooks,
+				ImageTimeout:   imageTimeout,
+				Audit:          auditOn,
+				VerifyHash:     verifyHash,
+				TouchModTime:   touchModTime,
+				Backup:         backupMode,
+				Resume:         resume,
+				LedgerHost:     ledger.Host(user, host, port),
+				Rescan:         rescan,
+				DryRun:         dryRun,
+				Format:         format,
+			}
+			opts.Target = opts.LedgerHost + client.Root()
+			// The cache database also holds the run journals and the ledger.
+			if dc := openListingCache(ttl); dc != nil {
+				defer dc.Close()
+				opts.Journal = journal.NewStore(dc.DB())
+				opts.Ledger = ledger.New(dc.DB())
+				if !skipCache {
+					opts.Cache = dc
+					opts.CacheKey = opts.Target
+				}
+			}
+			if resume != "" && opts.Journal == nil {
+				return exitErr(ExitInternal, errors.New("--resume: run journal unavailable"))
+			}
+			return runBatch(ctx, client, opts, out)
 
 		} else {
 			// Interactive TUI mode
-			model := tui.NewSFTPModel()
+			rules, err := FilterFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			touchModTime, _ := cmd.Flags().GetBool("touch-mtime")
+			sftpOpts := tui.SFTPOptions{Logger: logger, Filter: rules, Throttle: limiter, TouchModTime: touchModTime}
+			if dc := openListingCache(0); dc != nil {
+				defer dc.Close()
+				sftpOpts.Ledger = ledger.New(dc.DB())
+			}
+			model := tui.NewSFTPModel(sftpOpts)
 			program := tea.NewProgram(&model)
 
 			// Run the program
@@ -99,13 +229,27 @@ func init() {
 	sftpCmd.Flags().String("key", "", "Private key path")
 	sftpCmd.Flags().String("password", "", "Password (fallback)")
 	sftpCmd.Flags().Int("quality", 80, "JPEG quality (1-100)")
-	sftpCmd.Flags().String("size-threshold", "", "Inclusive size threshold e.g. 500KB, 2MB")
-	sftpCmd.Flags().Int("concurrency", 4, "Worker concurrency")
+	AddFilterFlags(sftpCmd, "15KB")
+	addDryRunFlags(sftpCmd)
+	addThrottleFlags(sftpCmd)
+	addHookFlags(sftpCmd)
+	sftpCmd.Flags().Int("concurrency", 4, "Files downloaded at once; PHOTOPTIM_CONCURRENCY is used when not given")
+	sftpCmd.Flags().Int("encoders", 0, "Files optimized at once (batch); 0 = number of CPUs")
+	sftpCmd.Flags().Int("uploaders", 0, "Files uploaded at once (batch); 0 = --concurrency")
+	sftpCmd.Flags().String("max-memory", "", "Memory budget for the images in flight, e.g. 1GB (batch); files wait for room beyond it; empty = unlimited")
+	sftpCmd.Flags().Duration("image-timeout", 0, "Per-image optimization deadline (e.g. 30s); 0 disables")
 	sftpCmd.Flags().String("ttl", "2m", "Directory cache TTL")
 	sftpCmd.Flags().Bool("save-config", false, "Persist settings to config file")
-	sftpCmd.Flags().Bool("verbose", false, "Verbose logging")
-	sftpCmd.Flags().Bool("audit", false, "Enable audit logging")
+	sftpCmd.Flags().Bool("audit", false, "Write a JSON audit log to a temp directory (path is printed)")
 	sftpCmd.Flags().Bool("skip-cache", false, "Skip directory cache")
+	sftpCmd.Flags().String("backup", "none", "Keep originals before replacing them (batch): none, remote (.photoptim-originals/<run-id>/) or local (temp dir orig/)")
+	sftpCmd.Flags().String("resume", "", "Continue the pending and failed files of an interrupted batch run (run ID as printed)")
+	sftpCmd.Flags().Bool("rescan", false, "Optimize files the ledger has as optimized and unchanged since (batch)")
+	sftpCmd.Flags().Bool("verify-hash", false, "Read uploads back and compare SHA-256 before replacing originals (batch)")
+	sftpCmd.Flags().Bool("touch-mtime", false, "Give replaced files the upload time as mtime (cache busting) instead of keeping the original's")
 	sftpCmd.Flags().Bool("keep-temp", false, "Keep temp files after completion")
 	sftpCmd.Flags().Bool("batch", false, "Run in non-interactive batch mode")
+	sftpCmd.Flags().BoolP("recursive", "r", false, "Include subdirectories of --remote-path (batch)")
+	sftpCmd.Flags().Int("max-depth", 0, "With --recursive, directory levels to walk; 1 = --remote-path only, 0 = no limit")
+	sftpCmd.Flags().Bool("follow-symlinks", false, "With --recursive, follow symlinks that stay inside --remote-path; files are optimized at their real path")
 }
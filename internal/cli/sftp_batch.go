@@ -0,0 +1,504 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/juparave/photoptim/internal/audit"
+	"github.com/juparave/photoptim/internal/backup"
+	"github.com/juparave/photoptim/internal/cache"
+	"github.com/juparave/photoptim/internal/config"
+	"github.com/juparave/photoptim/internal/filter"
+	"github.com/juparave/photoptim/internal/journal"
+	"github.com/juparave/photoptim/internal/ledger"
+	"github.com/juparave/photoptim/internal/metrics"
+	"github.com/juparave/photoptim/internal/optimizer"
+	"github.com/juparave/photoptim/internal/pipeline"
+	"github.com/juparave/photoptim/internal/progress"
+	"github.com/juparave/photoptim/internal/remotefs"
+	"github.com/juparave/photoptim/internal/throttle"
+)
+
+// batchOptions are the sftp --batch settings after flag parsing.
+type batchOptions struct {
+	Dir            string // remote directory, relative to the connection root
+	Quality        int
+	Filter         filter.Rules
+	Recursive      bool // walk subdirectories (pipeline.Walk)
+	MaxDepth       int  // with Recursive: 1 = opts.Dir only; 0 = no limit
+	FollowSymlinks bool // with Recursive: follow links inside the root
+	Concurrency    int
+	Throttle       *throttle.Limiter // nil = unlimited
+	Encoders       int               // 0 = GOMAXPROCS
+	Uploaders      int               // 0 = Concurrency
+	MaxMemory      int64             // bytes; 0 = unlimited
+	Hooks          pipeline.Hooks
+	ImageTimeout   time.Duration
+	Audit          bool
+	VerifyHash     bool
+	TouchModTime   bool                  // replaced files get the upload time as mtime
+	Backup         string                // "none", "remote" or "local"
+	Target         string                // user@host:port/root, identifies the remote in journals
+	Journal        *journal.Store        // nil = runs are not journaled
+	Resume         string                // run ID to resume; "" = new run
+	Ledger         *ledger.Ledger        // nil = no ledger
+	LedgerHost     string                // see ledger.Host
+	Rescan         bool                  // ignore the ledger's unchanged files
+	CacheKey       string                // listing cache key; empty disables the cache
+	Cache          *cache.DirectoryCache // nil = always list
+	DryRun         bool                  // optimize in memory and print a report instead of per-file lines
+	Format         string                // dry-run report format: "table" or "json"
+}
+
+// runBatch optimizes every supported image in opts.Dir on fs, prints one line
+// per file and a summary to out, and returns an *ExitError for anything but
+// full success. A dry run writes nothing back, backs nothing up, journals
+// and audits nothing, and prints only its report.
+func runBatch(ctx context.Context, fs remotefs.RemoteFS, opts batchOptions, out io.Writer) (err error) {
+	var rep *dryRunReport
+	if opts.DryRun {
+		rep = newDryRunReport()
+		w := out
+		out = io.Discard
+		opts.Backup, opts.Audit = "none", false
+		defer func() {
+			if werr := rep.write(w, opts.Format); werr != nil && err == nil {
+				err = exitErr(ExitInternal, werr)
+			}
+		}()
+	}
+	runID := backup.NewRunID()
+	var tasks []pipeline.FileTask
+	var jr *journal.Journal
+	filtered := 0 // skipped by opts.Filter before the run
+	skip := func(e remotefs.RemoteEntry, reason string) {
+		fmt.Fprintf(out, "SKIP %s: %s\n", e.Path, reason)
+		if rep != nil {
+			rep.skip(e.Path, e.Size, reason)
+		}
+		filtered++
+	}
+	// A recursive walk streams: files are journaled and run as they are
+	// found, and its skips are reported from the run loop.
+	var walked <-chan pipeline.FileTask
+	var walkDone <-chan error
+	var walkSkips chan walkSkip
+	walkCtx, cancelWalk := context.WithCancel(ctx)
+	defer cancelWalk()
+	if opts.Resume != "" {
+		var err error
+		if jr, err = resumeJournal(opts); err != nil {
+			return exitErr(ExitInternal, err)
+		}
+		files, err := jr.Unfinished()
+		if err != nil {
+			return exitErr(ExitInternal, fmt.Errorf("journal: %w", err))
+		}
+		runID = opts.Resume
+		if opts.Backup == "none" && jr.Run().Backup != "" {
+			// Keep backing up where the run started to.
+			opts.Backup = jr.Run().Backup
+		}
+		if files, err = restatUnfinished(ctx, fs, jr, files, skip, opts.DryRun); err != nil {
+			return exitErr(ExitConnection, err)
+		}
+		for _, f := range files {
+			tasks = append(tasks, pipeline.FileTask{Entry: f})
+		}
+		if len(tasks) == 0 {
+			fmt.Fprintf(out, "Run %s has no pending or failed files\n", runID)
+			return exitErr(ExitNothingToDo, nil)
+		}
+		fmt.Fprintf(out, "Resuming run %s: %d of %d files left\n", runID, len(tasks), jr.Run().Files)
+	} else {
+		var files []remotefs.RemoteEntry
+		if opts.Recursive {
+			walkSkips = make(chan walkSkip)
+			walked, walkDone = pipeline.Walk(walkCtx, fs, opts.Dir, pipeline.WalkOptions{
+				MaxDepth:       opts.MaxDepth,
+				Filter:         opts.Filter,
+				FollowSymlinks: opts.FollowSymlinks,
+				Skipped: func(e remotefs.RemoteEntry, reason string) {
+					select {
+					case walkSkips <- walkSkip{e, reason}:
+					case <-walkCtx.Done():
+					}
+				},
+				Logger: logger,
+			})
+		} else {
+			var err error
+			if files, err = selectFiles(ctx, fs, opts, skip); err != nil {
+				return exitErr(ExitConnection, fmt.Errorf("list %s: %w", opts.Dir, err))
+			}
+			for _, e := range files {
+				tasks = append(tasks, pipeline.FileTask{Entry: e})
+			}
+			if len(tasks) == 0 {
+				fmt.Fprintf(out, "No optimizable images in %s (of %d files)\n", opts.Dir, filtered)
+				return exitErr(ExitNothingToDo, nil)
+			}
+		}
+		if opts.Journal != nil && !opts.DryRun {
+			run := journal.Run{ID: runID, Target: opts.Target, Dir: opts.Dir}
+			if opts.Backup != "none" {
+				run.Backup = opts.Backup
+			}
+			var err error
+			if jr, err = opts.Journal.Create(run, files); err != nil {
+				logger.Warn("run journal unavailable", "err", err)
+				jr = nil
+			} else {
+				fmt.Fprintf(out, "Run %s (continue an interrupted run with --resume %s)\n", runID, runID)
+			}
+		}
+	}
+	if walked != nil {
+		fmt.Fprintf(out, "Optimizing images under %s with concurrency %d ...\n", opts.Dir, opts.Concurrency)
+	} else {
+		fmt.Fprintf(out, "Optimizing %d images with concurrency %d ...\n", len(tasks), opts.Concurrency)
+	}
+
+	var store backup.Store
+	switch opts.Backup {
+	case "remote":
+		store = backup.NewRemote(fs, runID)
+	case "local":
+		store = backup.NewLocal(runID)
+	}
+	if store != nil {
+		fmt.Fprintf(out, "Backing up originals to %s (undo with: photoptim restore --run %s --from %s)\n", store.Location(), runID, opts.Backup)
+	}
+
+	var auditLog *audit.Logger
+	if opts.Audit {
+		var dir string
+		var err error
+		if opts.Backup == "local" {
+			// Local backups and the audit log share the session directory.
+			dir = backup.SessionDir(runID)
+			err = os.MkdirAll(dir, 0o700)
+		} else {
+			dir, err = os.MkdirTemp("", "photoptim_sftp_"+runID+"_")
+		}
+		if err != nil {
+			return exitErr(ExitInternal, fmt.Errorf("audit: %w", err))
+		}
+		if auditLog, err = audit.NewLogger(filepath.Join(dir, "audit.json")); err != nil {
+			return exitErr(ExitInternal, fmt.Errorf("audit: %w", err))
+		}
+		defer auditLog.Close()
+		fmt.Fprintf(out, "Audit log: %s\n", filepath.Join(dir, "audit.json"))
+	}
+
+	if opts.DryRun {
+		jr = nil // a dry run leaves a resumed journal as it is
+	}
+	opt := optimizer.New()
+	opt.Logger = logger
+	orch := pipeline.Orchestrator{
+		FS:           fs,
+		Opt:          opt,
+		Concurrency:  opts.Concurrency,
+		Throttle:     opts.Throttle,
+		Encoders:     opts.Encoders,
+		Uploaders:    opts.Uploaders,
+		MaxMemory:    opts.MaxMemory,
+		JPEGQuality:  opts.Quality,
+		ImageTimeout: opts.ImageTimeout,
+		// opts.Filter.MinSize, from --size-threshold, replaces the tiny
+		// threshold.
+		TinyThreshold: -1,
+		Filter:        opts.Filter,
+		VerifyHash:    opts.VerifyHash,
+		TouchModTime:  opts.TouchModTime,
+		Backup:        store,
+		Journal:       jr,
+		DryRun:        opts.DryRun,
+		Ledger:        opts.Ledger,
+		LedgerHost:    opts.LedgerHost,
+		Rescan:        opts.Rescan,
+		Hooks:         opts.Hooks,
+		Logger:        logger,
+	}
+	var m metrics.Metrics
+	m.Skipped.Add(int64(filtered))
+	orch.Metrics, orch.Audit = &m, auditLog
+	tracker := pipeline.NewProgress(tasks)
+	var prog <-chan pipeline.ProgressEvent
+	var done <-chan pipeline.Summary
+	var arrived <-chan pipeline.FileTask // walked files, before the run gets them
+	if walked != nil {
+		var feed <-chan pipeline.FileTask
+		arrived, feed = feedWalk(walkCtx, walked, jr)
+		prog, done = orch.RunStream(ctx, feed)
+	} else {
+		prog, done = orch.Run(ctx, tasks)
+	}
+	for prog != nil {
+		var ev pipeline.ProgressEvent
+		select {
+		case t := <-arrived:
+			tracker.Add(len(tasks), t)
+			tasks = append(tasks, t)
+			continue
+		case sk := <-walkSkips:
+			skip(sk.entry, sk.reason)
+			m.Skipped.Add(1)
+			continue
+		case e, ok := <-prog:
+			if !ok {
+				prog = nil
+				continue
+			}
+			ev = e
+		}
+		tracker.Observe(ev)
+		if !ev.Done && ev.Err != nil {
+			fmt.Fprintf(out, "RETRY %s: %s failed (%v), retry %d\n", tasks[ev.FileID].Entry.Path, ev.Phase, ev.Err, ev.Retries)
+		}
+		oc := ev.Outcome
+		if oc == nil {
+			continue
+		}
+		fmt.Fprintf(out, "[%s] ", formatProgress(tracker.Snapshot()))
+		reportOutcome(out, oc)
+		if rep != nil {
+			rep.result(oc.Entry.Path, oc.Entry.Size, oc.Result, oc.Err, oc.Duration)
+		}
+	}
+	sum := <-done
+	if walkDone != nil {
+		if sum.Err != nil || ctx.Err() != nil {
+			cancelWalk() // the run stopped reading it
+		}
+		if err := <-walkDone; err != nil && sum.Err == nil && ctx.Err() == nil {
+			return exitErr(ExitConnection, fmt.Errorf("list %s: %w", opts.Dir, err))
+		}
+		if sum.Files == 0 && sum.Err == nil && ctx.Err() == nil {
+			fmt.Fprintf(out, "No optimizable images in %s (of %d files)\n", opts.Dir, filtered)
+			return exitErr(ExitNothingToDo, nil)
+		}
+	}
+	for _, err := range sum.HookErrors {
+		fmt.Fprintf(out, "HOOK %v\n", err)
+	}
+
+	pending := sum.NotProcessed
+	saved := m.BytesSave.Load()
+	pct := 0.0
+	if in := m.BytesIn.Load(); in > 0 {
+		pct = float64(saved) / float64(in) * 100
+	}
+	fmt.Fprintf(out, "\nSummary: %d optimized, %d skipped, %d failed", m.Processed.Load(), m.Skipped.Load(), m.Failed.Load())
+	if pending > 0 {
+		fmt.Fprintf(out, ", %d not processed", pending)
+	}
+	if n := len(sum.HookErrors); n > 0 {
+		fmt.Fprintf(out, ", %d hook failures", n)
+	}
+	snap := tracker.Snapshot()
+	fmt.Fprintf(out, "; saved %s (%.1f%%); transferred %s in %s\n", humanBytes(saved), pct, humanBytes(snap.Done), snap.Elapsed.Round(time.Millisecond))
+
+	switch {
+	case sum.Err != nil:
+		return exitErr(ExitInternal, sum.Err)
+	case ctx.Err() != nil:
+		return exitErr(ExitInterrupted, fmt.Errorf("run interrupted: %w", ctx.Err()))
+	case m.Failed.Load() > 0 || pending > 0:
+		return exitErr(ExitPartialFailure, fmt.Errorf("%d of %d files failed", m.Failed.Load()+int64(pending), sum.Files))
+	case m.Processed.Load() == 0:
+		return exitErr(ExitNothingToDo, nil)
+	}
+	return nil
+}
+
+// resumeJournal opens the journal of opts.Resume and checks that it belongs
+// to the same remote and directory.
+func resumeJournal(opts batchOptions) (*journal.Journal, error) {
+	jr, err := opts.Journal.Open(opts.Resume)
+	if err != nil {
+		return nil, fmt.Errorf("--resume: %w", err)
+	}
+	if run := jr.Run(); run.Target != opts.Target || run.Dir != opts.Dir {
+		return nil, fmt.Errorf("--resume: run %s was on %s (%s), not %s (%s)", run.ID, run.Target, run.Dir, opts.Target, opts.Dir)
+	}
+	return jr, nil
+}
+
+// restatUnfinished returns the current entries of a resumed run's files: the
+// journal holds them as they were when the run started. Files that no longer
+// exist are reported through skip and, unless dryRun, marked skipped in jr.
+func restatUnfinished(ctx context.Context, fs remotefs.RemoteFS, jr *journal.Journal, files []remotefs.RemoteEntry, skip func(remotefs.RemoteEntry, string), dryRun bool) ([]remotefs.RemoteEntry, error) {
+	out := files[:0]
+	for _, f := range files {
+		e, err := fs.Stat(ctx, f.Path)
+		switch {
+		case errors.Is(err, iofs.ErrNotExist) || err == nil && e.IsDir:
+			skip(f, "not-found")
+			if !dryRun {
+				if err := jr.Update(f.Path, "", journal.StatusSkipped, "not-found", nil); err != nil {
+					return nil, fmt.Errorf("journal: %w", err)
+				}
+			}
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("stat %s: %w", f.Path, err)
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// reportOutcome prints a finished file. Metrics and the audit log are
+// updated by the pipeline.
+func reportOutcome(out io.Writer, oc *pipeline.Outcome) {
+	switch oc.Status {
+	case pipeline.StatusFailed:
+		fmt.Fprintf(out, "FAIL %s: %v%s\n", oc.Entry.Path, oc.Err, retryNote(oc.Retries))
+	case pipeline.StatusOptimized:
+		fmt.Fprintf(out, "OK   %s: %d -> %d bytes (%.1f%%)%s\n", oc.Entry.Path, oc.OriginalSize, oc.OptimizedSize, savingsPercent(oc.Result), retryNote(oc.Retries))
+	default:
+		fmt.Fprintf(out, "SKIP %s: %s\n", oc.Entry.Path, oc.Reason)
+	}
+}
+
+// retryNote returns " (after N retries)", or "" without retries.
+func retryNote(n int) string {
+	switch n {
+	case 0:
+		return ""
+	case 1:
+		return " (after 1 retry)"
+	}
+	return fmt.Sprintf(" (after %d retries)", n)
+}
+
+// formatProgress renders overall progress as "42%, 1.2 MiB/s, ETA 12s".
+func formatProgress(s progress.Snapshot) string {
+	str := fmt.Sprintf("%3.0f%%", s.Percent*100)
+	if s.Rate > 0 {
+		str += fmt.Sprintf(", %s/s", humanBytes(int64(s.Rate)))
+	}
+	if s.ETA > 0 {
+		str += fmt.Sprintf(", ETA %s", s.ETA.Round(time.Second))
+	}
+	return str
+}
+
+// listDir lists opts.Dir, through the directory cache when enabled.
+func listDir(ctx context.Context, fs remotefs.RemoteFS, opts batchOptions) ([]remotefs.RemoteEntry, error) {
+	var entries []remotefs.RemoteEntry
+	if opts.Cache != nil && opts.CacheKey != "" {
+		if fresh, err := opts.Cache.Get(opts.CacheKey, &entries); err == nil && fresh {
+			return entries, nil
+		}
+	}
+	entries, err := fs.List(ctx, opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Cache != nil && opts.CacheKey != "" {
+		if err := opts.Cache.Put(opts.CacheKey, entries); err != nil {
+			logger.Warn("cache put failed", "err", err)
+		}
+	}
+	return entries, nil
+}
+
+// selectFiles returns the files in opts.Dir that opts.Filter selects, and
+// passes every file left out to skip. Recursive runs stream from
+// pipeline.Walk instead (see feedWalk).
+func selectFiles(ctx context.Context, fs remotefs.RemoteFS, opts batchOptions, skip func(remotefs.RemoteEntry, string)) ([]remotefs.RemoteEntry, error) {
+	entries, err := listDir(ctx, fs, opts)
+	if err != nil {
+		return nil, err
+	}
+	var files []remotefs.RemoteEntry
+	for _, e := range entries {
+		if e.IsDir || remotefs.IsTempName(e.Name) {
+			continue
+		}
+		if reason := opts.Filter.Match(e); reason != "" {
+			skip(e, reason)
+			continue
+		}
+		files = append(files, e)
+	}
+	return files, nil
+}
+
+// walkSkip is a file a recursive walk left out.
+type walkSkip struct {
+	entry  remotefs.RemoteEntry
+	reason string
+}
+
+// feedWalk passes the walked files on to the run, journaling each in jr (if
+// set) and announcing it on the first channel before the run gets it on the
+// second, so that the announced order is the order of FileIDs.
+func feedWalk(ctx context.Context, walked <-chan pipeline.FileTask, jr *journal.Journal) (<-chan pipeline.FileTask, <-chan pipeline.FileTask) {
+	arrived, feed := make(chan pipeline.FileTask), make(chan pipeline.FileTask)
+	go func() {
+		defer close(feed)
+		for t := range walked {
+			if jr != nil {
+				if err := jr.Add(t.Entry); err != nil {
+					logger.Warn("run journal: add failed", "file", t.Entry.Path, "err", err)
+				}
+			}
+			for _, ch := range []chan pipeline.FileTask{arrived, feed} {
+				select {
+				case ch <- t:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return arrived, feed
+}
+
+// openListingCache opens the directory cache, or returns nil (with a
+// warning) if it cannot be opened; a missing cache only costs a listing.
+func openListingCache(ttl time.Duration) *cache.DirectoryCache {
+	c, err := cache.Open(config.ResolvePaths().CacheDB, ttl)
+	if err != nil {
+		logger.Warn("directory cache unavailable", "err", err)
+		return nil
+	}
+	c.Logger = logger
+	return c
+}
+
+func savingsPercent(res optimizer.Result) float64 {
+	if res.OriginalSize == 0 {
+		return 0
+	}
+	return float64(res.OriginalSize-res.OptimizedSize) / float64(res.OriginalSize) * 100
+}
+
+// concurrencyFrom returns the --concurrency flag when set, else
+// PHOTOPTIM_CONCURRENCY, else the flag default.
+func concurrencyFrom(flagValue int, flagChanged bool) (int, error) {
+	if !flagChanged {
+		if env := os.Getenv("PHOTOPTIM_CONCURRENCY"); env != "" {
+			n, err := strconv.Atoi(env)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid PHOTOPTIM_CONCURRENCY %q", env)
+			}
+			return n, nil
+		}
+	}
+	if flagValue < 1 {
+		return 0, fmt.Errorf("invalid --concurrency %d", flagValue)
+	}
+	return flagValue, nil
+}
@@ -0,0 +1,422 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	iofs "io/fs"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/juparave/photoptim/internal/backup"
+	"github.com/juparave/photoptim/internal/cache"
+	"github.com/juparave/photoptim/internal/journal"
+	"github.com/juparave/photoptim/internal/ledger"
+	"github.com/juparave/photoptim/internal/pipeline"
+	"github.com/juparave/photoptim/internal/remotefs"
+)
+
+func TestRunBatchExitCodes(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 31)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	newFS := func() *remotefs.MockFS {
+		fs := remotefs.NewMockFS(".")
+		fs.PutTestFile("a.jpg", buf.Bytes())
+		fs.PutTestFile("broken.jpg", []byte("not a jpeg, but big enough to pass the threshold"))
+		fs.PutTestFile("notes.txt", buf.Bytes())
+		fs.PutTestFile(".hidden.jpg", buf.Bytes())
+		return fs
+	}
+	code := func(err error) int {
+		var exit *ExitError
+		if err == nil {
+			return ExitOK
+		}
+		if !errors.As(err, &exit) {
+			t.Fatalf("not an exit error: %v", err)
+		}
+		return exit.Code
+	}
+	opts := batchOptions{Dir: ".", Quality: 60, Concurrency: 2}
+
+	fs := newFS()
+	var out bytes.Buffer
+	if err := runBatch(context.Background(), fs, opts, &out); code(err) != ExitOK {
+		t.Fatalf("runBatch: %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "OK   a.jpg") || !strings.Contains(out.String(), "SKIP broken.jpg: decode-error") {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+	// a.jpg is optimized now: a second run has nothing to do.
+	out.Reset()
+	if err := runBatch(context.Background(), fs, opts, &out); code(err) != ExitNothingToDo {
+		t.Fatalf("all skipped: want exit %d, got %v\n%s", ExitNothingToDo, err, out.String())
+	}
+
+	cases := []struct {
+		name  string
+		setup func(fs *remotefs.MockFS, opts *batchOptions, cancel context.CancelFunc)
+		want  int
+	}{
+		{"none selected", func(_ *remotefs.MockFS, opts *batchOptions, _ context.CancelFunc) {
+			opts.Filter.MinSize = 1 << 30
+		}, ExitNothingToDo},
+		{"upload failed", func(fs *remotefs.MockFS, _ *batchOptions, _ context.CancelFunc) {
+			fs.FailTestOp("create", "*a.jpg*", 100, iofs.ErrPermission)
+		}, ExitPartialFailure},
+		{"required hook failed", func(_ *remotefs.MockFS, opts *batchOptions, _ context.CancelFunc) {
+			opts.Hooks.PreRun = []pipeline.Hook{{Command: "exit 1", Required: true}}
+		}, ExitInternal},
+		{"interrupted", func(_ *remotefs.MockFS, opts *batchOptions, cancel context.CancelFunc) {
+			opts.Hooks.PreRun = []pipeline.Hook{{Func: func(context.Context, pipeline.HookEvent) error { cancel(); return nil }}}
+		}, ExitInterrupted},
+	}
+	for _, c := range cases {
+		fs, opts := newFS(), opts
+		ctx, cancel := context.WithCancel(context.Background())
+		c.setup(fs, &opts, cancel)
+		out.Reset()
+		if err := runBatch(ctx, fs, opts, &out); code(err) != c.want {
+			t.Errorf("%s: want exit %d, got %v\n%s", c.name, c.want, err, out.String())
+		}
+		cancel()
+	}
+}
+
+func TestSFTPBatchConnectionFailure(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close() // nothing listens there any more
+	rootCmd.SetArgs([]string{"sftp", "--batch", "--host", "127.0.0.1", "--port", strconv.Itoa(port), "--user", "nobody"})
+	rootCmd.SetOut(io.Discard)
+	defer rootCmd.SetArgs(nil)
+	var exit *ExitError
+	if err := rootCmd.Execute(); !errors.As(err, &exit) || exit.Code != ExitConnection {
+		t.Fatalf("want exit %d, got %v", ExitConnection, err)
+	}
+}
+
+func TestBatchBackupRestore(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 31)
+	}
+	var orig bytes.Buffer
+	if err := jpeg.Encode(&orig, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	fs := remotefs.NewMockFS(".")
+	fs.PutTestFile("a.jpg", orig.Bytes())
+
+	var out bytes.Buffer
+	if err := runBatch(ctx, fs, batchOptions{Dir: ".", Quality: 60, Concurrency: 1, Backup: "remote"}, &out); err != nil {
+		t.Fatalf("runBatch: %v\n%s", err, out.String())
+	}
+	_, rest, ok := strings.Cut(out.String(), "--run ")
+	if !ok {
+		t.Fatalf("no run id in output:\n%s", out.String())
+	}
+	store := backup.NewRemote(fs, strings.Fields(rest)[0])
+	if e, _ := fs.Stat(ctx, "a.jpg"); e.Size >= int64(orig.Len()) {
+		t.Fatalf("a.jpg not optimized: %d bytes", e.Size)
+	}
+
+	out.Reset()
+	if err := runRestore(ctx, fs, store, true, &out); err != nil || !strings.Contains(out.String(), "WOULD RESTORE a.jpg") {
+		t.Fatalf("dry run: %v\n%s", err, out.String())
+	}
+	if e, _ := fs.Stat(ctx, "a.jpg"); e.Size == int64(orig.Len()) {
+		t.Fatal("dry run restored a.jpg")
+	}
+	if err := runRestore(ctx, fs, store, false, &out); err != nil {
+		t.Fatalf("restore: %v\n%s", err, out.String())
+	}
+	rc, _, _ := fs.Open(ctx, "a.jpg")
+	got, _ := io.ReadAll(rc)
+	if !bytes.Equal(got, orig.Bytes()) {
+		t.Fatalf("a.jpg not restored: %d bytes, want %d", len(got), orig.Len())
+	}
+
+	var exit *ExitError
+	if err := runRestore(ctx, fs, backup.NewRemote(fs, "19700101-000000"), false, &out); !errors.As(err, &exit) || exit.Code != ExitNothingToDo {
+		t.Fatalf("unknown run: want exit %d, got %v", ExitNothingToDo, err)
+	}
+}
+
+func TestBatchResume(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 31)
+	}
+	var src bytes.Buffer
+	if err := jpeg.Encode(&src, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	dc, err := cache.Open(filepath.Join(t.TempDir(), "cache.db"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close()
+	ctx := context.Background()
+	fs := remotefs.NewMockFS(".")
+	fs.PutTestFile("a.jpg", src.Bytes())
+	fs.PutTestFile("b.jpg", src.Bytes())
+	fs.PutTestFile("c.jpg", src.Bytes())
+	fs.FailTestOp("create", ".[bc].jpg.*.tmp", 2, iofs.ErrPermission)
+	opts := batchOptions{Dir: ".", Quality: 60, Concurrency: 1, Backup: "none", Target: "me@host:22/photos", Journal: journal.NewStore(dc.DB())}
+
+	var out bytes.Buffer
+	var exit *ExitError
+	if err := runBatch(ctx, fs, opts, &out); !errors.As(err, &exit) || exit.Code != ExitPartialFailure {
+		t.Fatalf("first run: want exit %d, got %v\n%s", ExitPartialFailure, err, out.String())
+	}
+	_, rest, ok := strings.Cut(out.String(), "--resume ")
+	if !ok {
+		t.Fatalf("no run id in output:\n%s", out.String())
+	}
+	opts.Resume = strings.TrimSuffix(strings.Fields(rest)[0], ")")
+
+	// Files removed since are dropped from the resumed run.
+	if err := fs.Remove(ctx, "c.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := runBatch(ctx, fs, opts, &out); err != nil {
+		t.Fatalf("resume: %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "SKIP c.jpg: not-found") || !strings.Contains(out.String(), "1 of 3 files left") || strings.Contains(out.String(), "a.jpg") {
+		t.Fatalf("resume should only process b.jpg:\n%s", out.String())
+	}
+	if err := runBatch(ctx, fs, opts, &out); !errors.As(err, &exit) || exit.Code != ExitNothingToDo {
+		t.Fatalf("second resume: want exit %d, got %v", ExitNothingToDo, err)
+	}
+	opts.Target = "me@elsewhere:22/photos"
+	if err := runBatch(ctx, fs, opts, &out); !errors.As(err, &exit) || exit.Code != ExitInternal {
+		t.Fatalf("resume on another remote: want exit %d, got %v", ExitInternal, err)
+	}
+
+	// The next run prunes the finished one.
+	resumed := opts.Resume
+	opts.Resume, opts.Target = "", "me@host:22/photos"
+	if err := runBatch(ctx, fs, opts, &out); err != nil && !errors.As(err, &exit) {
+		t.Fatalf("new run: %v", err)
+	}
+	if runs, err := opts.Journal.Runs(); err != nil || len(runs) != 1 || runs[0].ID == resumed {
+		t.Fatalf("finished run %s not pruned: %+v, %v", resumed, runs, err)
+	}
+}
+
+func TestBatchLedger(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 31)
+	}
+	var src bytes.Buffer
+	if err := jpeg.Encode(&src, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	dc, err := cache.Open(filepath.Join(t.TempDir(), "cache.db"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close()
+	ctx := context.Background()
+	fs := remotefs.NewMockFS(".")
+	fs.PutTestFile("a.jpg", src.Bytes())
+	fs.PutTestFile("b.jpg", src.Bytes())
+	opts := batchOptions{Dir: ".", Quality: 60, Concurrency: 1, Backup: "none", LedgerHost: "me@host:22", Ledger: ledger.New(dc.DB())}
+
+	var out bytes.Buffer
+	if err := runBatch(ctx, fs, opts, &out); err != nil || !strings.Contains(out.String(), "OK   a.jpg") {
+		t.Fatalf("first run: %v\n%s", err, out.String())
+	}
+	fs.PutTestFile("b.jpg", src.Bytes()[:src.Len()-1]) // changed since
+	out.Reset()
+	var exit *ExitError
+	if err := runBatch(ctx, fs, opts, &out); !errors.As(err, &exit) || exit.Code != ExitNothingToDo {
+		t.Fatalf("second run: want exit %d, got %v\n%s", ExitNothingToDo, err, out.String())
+	}
+	if !strings.Contains(out.String(), "SKIP a.jpg: previously-optimized") || strings.Contains(out.String(), "b.jpg: previously-optimized") {
+		t.Fatalf("second run should skip only a.jpg:\n%s", out.String())
+	}
+	if _, ok, _ := opts.Ledger.Get(opts.LedgerHost, "a.jpg"); !ok {
+		t.Fatal("a.jpg not in the ledger")
+	}
+
+	opts.Rescan = true
+	out.Reset()
+	if err := runBatch(ctx, fs, opts, &out); !errors.As(err, &exit) || exit.Code != ExitNothingToDo || strings.Contains(out.String(), "previously-optimized") {
+		t.Fatalf("rescan: %v\n%s", err, out.String())
+	}
+}
+
+func TestBatchDryRun(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 31)
+	}
+	var src bytes.Buffer
+	if err := jpeg.Encode(&src, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	fs := remotefs.NewMockFS(".")
+	fs.PutTestFile("a.jpg", src.Bytes())
+	fs.PutTestFile("notes.txt", src.Bytes())
+	opts := batchOptions{Dir: ".", Quality: 60, Concurrency: 1, Backup: "remote", DryRun: true, Format: "json"}
+
+	var out bytes.Buffer
+	if err := runBatch(ctx, fs, opts, &out); err != nil {
+		t.Fatalf("runBatch: %v\n%s", err, out.String())
+	}
+	var rep dryRunReport
+	if err := json.Unmarshal(out.Bytes(), &rep); err != nil {
+		t.Fatalf("report is not JSON: %v\n%s", err, out.String())
+	}
+	if rep.Optimized != 1 || rep.Skipped != 1 || rep.SavedBytes <= 0 || len(rep.Files) != 2 {
+		t.Fatalf("unexpected report %+v", rep)
+	}
+	for _, f := range rep.Files {
+		if f.Path == "notes.txt" && (f.Status != dryRunSkip || f.Reason != "unsupported-format") {
+			t.Fatalf("notes.txt: %+v", f)
+		}
+	}
+	if e, _ := fs.Stat(ctx, "a.jpg"); e.Size != int64(src.Len()) {
+		t.Fatalf("dry run changed a.jpg: %d bytes", e.Size)
+	}
+	if entries, _ := fs.List(ctx, "."); len(entries) != 2 {
+		t.Fatalf("dry run wrote files: %v", entries)
+	}
+}
+
+func TestBatchRecursive(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 31)
+	}
+	var src bytes.Buffer
+	if err := jpeg.Encode(&src, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	fs := remotefs.NewMockFS(".")
+	fs.PutTestFile("top.jpg", src.Bytes())
+	fs.PutTestFile("2024/05/a.jpg", src.Bytes())
+	fs.PutTestFile("2024/05/notes.txt", src.Bytes())
+	fs.PutTestFile(".cache/b.jpg", src.Bytes())
+	opts := batchOptions{Dir: ".", Quality: 60, Concurrency: 2, Recursive: true}
+
+	var out bytes.Buffer
+	if err := runBatch(context.Background(), fs, opts, &out); err != nil {
+		t.Fatalf("runBatch: %v\n%s", err, out.String())
+	}
+	for _, want := range []string{"OK   top.jpg", "OK   2024/05/a.jpg", "SKIP 2024/05/notes.txt: unsupported-format"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("missing %q in:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "b.jpg") {
+		t.Fatalf("hidden directory walked:\n%s", out.String())
+	}
+
+	opts.MaxDepth = 1
+	out.Reset()
+	var exit *ExitError
+	if err := runBatch(context.Background(), fs, opts, &out); !errors.As(err, &exit) || exit.Code != ExitNothingToDo || strings.Contains(out.String(), "a.jpg") {
+		t.Fatalf("--max-depth 1 (top.jpg already optimized): %v\n%s", err, out.String())
+	}
+
+	opts.MaxDepth, opts.Dir = 0, "missing"
+	if err := runBatch(context.Background(), fs, opts, &out); !errors.As(err, &exit) || exit.Code != ExitConnection {
+		t.Fatalf("missing root: want exit %d, got %v", ExitConnection, err)
+	}
+}
+
+// gatedFS holds the listing of gated until a file has been replaced.
+type gatedFS struct {
+	*remotefs.MockFS
+	gated    string
+	replaced chan struct{}
+	once     sync.Once
+}
+
+func (g *gatedFS) List(ctx context.Context, dir string) ([]remotefs.RemoteEntry, error) {
+	if dir == g.gated {
+		select {
+		case <-g.replaced:
+		case <-time.After(5 * time.Second):
+			return nil, errors.New("nothing replaced while the walk was going on")
+		}
+	}
+	return g.MockFS.List(ctx, dir)
+}
+
+func (g *gatedFS) Rename(ctx context.Context, oldpath, newpath string) error {
+	err := g.MockFS.Rename(ctx, oldpath, newpath)
+	g.once.Do(func() { close(g.replaced) })
+	return err
+}
+
+// TestBatchRecursiveStreams checks that a recursive run starts before the
+// walk ends and journals the files as they are found.
+func TestBatchRecursiveStreams(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 31)
+	}
+	var src bytes.Buffer
+	if err := jpeg.Encode(&src, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	dc, err := cache.Open(filepath.Join(t.TempDir(), "cache.db"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close()
+	fs := &gatedFS{MockFS: remotefs.NewMockFS("."), gated: "sub", replaced: make(chan struct{})}
+	fs.PutTestFile("a.jpg", src.Bytes())
+	fs.PutTestFile("sub/b.jpg", src.Bytes())
+	fs.PutTestFile("sub/c.jpg", src.Bytes())
+	opts := batchOptions{Dir: ".", Quality: 60, Concurrency: 1, Recursive: true, Backup: "none", Target: "me@host:22/photos", Journal: journal.NewStore(dc.DB())}
+
+	var out bytes.Buffer
+	if err := runBatch(context.Background(), fs, opts, &out); err != nil {
+		t.Fatalf("runBatch: %v\n%s", err, out.String())
+	}
+	for _, want := range []string{"OK   a.jpg", "OK   sub/b.jpg", "OK   sub/c.jpg"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("missing %q in:\n%s", want, out.String())
+		}
+	}
+	runs, err := opts.Journal.Runs()
+	if err != nil || len(runs) != 1 || runs[0].Files != 3 {
+		t.Fatalf("journaled runs %+v, %v", runs, err)
+	}
+	jr, err := opts.Journal.Open(runs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left, err := jr.Unfinished(); err != nil || len(left) != 0 {
+		t.Fatalf("unfinished %v, %v", left, err)
+	}
+}
@@ -1,9 +1,90 @@
 package cli
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/juparave/photoptim/internal/pipeline"
+	"github.com/juparave/photoptim/internal/throttle"
+)
 
 func TestSFTPCmdRegistered(t *testing.T) {
 	if _, _, err := rootCmd.Find([]string{"sftp"}); err != nil {
 		t.Fatalf("sftp command not registered: %v", err)
 	}
 }
+
+func TestSizeThresholdDefaults(t *testing.T) {
+	for cmd, want := range map[*cobra.Command]string{sftpCmd: "15KB", batchCmd: "0"} {
+		if got := cmd.Flags().Lookup("size-threshold").DefValue; got != want {
+			t.Errorf("%s --size-threshold defaults to %q, want %q", cmd.Name(), got, want)
+		}
+	}
+}
+
+func TestThrottleFromFlags(t *testing.T) {
+	cfgHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", cfgHome)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	if err := os.MkdirAll(filepath.Join(cfgHome, "photoptim"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	conf := "bandwidth:\n  limit: 2MB # office uplink\n  schedule: \"22:00-06:00=off\"\n"
+	if err := os.WriteFile(filepath.Join(cfgHome, "photoptim", "config.yaml"), []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	limiter := func(args ...string) (*throttle.Limiter, error) {
+		cmd := &cobra.Command{}
+		addThrottleFlags(cmd)
+		if err := cmd.ParseFlags(args); err != nil {
+			return nil, err
+		}
+		return throttleFromFlags(cmd)
+	}
+	if l, err := limiter(); err != nil || l == nil {
+		t.Fatalf("config only: limiter %v, err %v; want the config's limits", l, err)
+	}
+	if l, err := limiter("--bwlimit=off", "--bwlimit-schedule="); err != nil || l != nil {
+		t.Fatalf("flags overriding config: limiter %v, err %v; want none", l, err)
+	}
+	if _, err := limiter("--bwlimit-up=fast"); err == nil {
+		t.Fatal("invalid --bwlimit-up accepted")
+	}
+}
+
+func TestHooksFromFlags(t *testing.T) {
+	cfgHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", cfgHome)
+	if err := os.MkdirAll(filepath.Join(cfgHome, "photoptim"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	conf := "hooks:\n  post_run: ./purge-cdn.sh\n  timeout: 5s\n"
+	if err := os.WriteFile(filepath.Join(cfgHome, "photoptim", "config.yaml"), []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	hooks := func(args ...string) (pipeline.Hooks, error) {
+		cmd := &cobra.Command{}
+		addHookFlags(cmd)
+		if err := cmd.ParseFlags(args); err != nil {
+			return pipeline.Hooks{}, err
+		}
+		return hooksFromFlags(cmd)
+	}
+	h, err := hooks("--post-file=echo $PHOTOPTIM_PATH", "--hooks-required")
+	if err != nil || len(h.PreRun) != 0 || len(h.PostFile) != 1 || len(h.PostRun) != 1 {
+		t.Fatalf("hooks %+v, err %v", h, err)
+	}
+	if run := h.PostRun[0]; run.Command != "./purge-cdn.sh" || run.Timeout != 5*time.Second || !run.Required {
+		t.Fatalf("post-run hook from config: %+v", run)
+	}
+	if h, err := hooks("--post-run="); err != nil || len(h.PostRun) != 0 {
+		t.Fatalf("flag overriding config: hooks %+v, err %v", h, err)
+	}
+	if _, err := hooks("--hook-timeout=soon"); err == nil {
+		t.Fatal("invalid --hook-timeout accepted")
+	}
+}
@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/juparave/photoptim/internal/remotefs"
+	sftpfs "github.com/juparave/photoptim/internal/sftp"
+
+	"github.com/spf13/cobra"
+)
+
+// source is an image argument: a local path or sftp://user@host[:port]/path.
+type source struct {
+	Arg    string
+	Path   string // local path, or absolute remote path
+	Remote bool
+	cfg    remotefs.ConnectionConfig
+}
+
+// Name returns the file name of the source.
+func (s source) Name() string { return filepath.Base(s.Path) }
+
+// Ext returns the lowercase extension without the dot.
+func (s source) Ext() string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(s.Path)), ".")
+}
+
+func parseSource(arg string) (source, error) {
+	if !strings.HasPrefix(arg, "sftp://") {
+		return source{Arg: arg, Path: arg}, nil
+	}
+	u, err := url.Parse(arg)
+	if err != nil {
+		return source{}, fmt.Errorf("invalid remote path %q: %w", arg, err)
+	}
+	if u.User == nil || u.User.Username() == "" || u.Hostname() == "" || u.Path == "" || u.Path == "/" {
+		return source{}, fmt.Errorf("invalid remote path %q (want sftp://user@host[:port]/path)", arg)
+	}
+	port := 22
+	if p := u.Port(); p != "" {
+		if port, err = strconv.Atoi(p); err != nil {
+			return source{}, fmt.Errorf("invalid port in %q", arg)
+		}
+	}
+	pass, _ := u.User.Password()
+	return source{
+		Arg:    arg,
+		Path:   u.Path,
+		Remote: true,
+		cfg:    remotefs.ConnectionConfig{Host: u.Hostname(), Port: port, User: u.User.Username(), Password: pass, RemotePath: "/"},
+	}, nil
+}
+
+// sources opens local and remote sources, sharing one connection per
+// user@host:port. Close releases the connections.
+type sources struct {
+	keyPath  string
+	password string
+	conns    map[string]remotefs.RemoteFS
+}
+
+// addSourceFlags registers the credentials used for sftp:// arguments.
+func addSourceFlags(cmd *cobra.Command) {
+	cmd.Flags().String("key", "", "Private key path for sftp:// sources")
+	cmd.Flags().String("password", "", "Password for sftp:// sources (fallback)")
+}
+
+func newSources(cmd *cobra.Command) *sources {
+	keyPath, _ := cmd.Flags().GetString("key")
+	password, _ := cmd.Flags().GetString("password")
+	return &sources{keyPath: keyPath, password: password, conns: map[string]remotefs.RemoteFS{}}
+}
+
+// Open opens src for reading and returns its size.
+func (s *sources) Open(ctx context.Context, src source) (io.ReadCloser, int64, error) {
+	if !src.Remote {
+		f, err := os.Open(src.Path)
+		if err != nil {
+			return nil, 0, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return nil, 0, err
+		}
+		return f, fi.Size(), nil
+	}
+	fs, err := s.conn(ctx, src.cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+	rc, entry, err := fs.Open(ctx, src.Path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", src.Arg, err)
+	}
+	return rc, entry.Size, nil
+}
+
+// ReadAll returns the whole content of src.
+func (s *sources) ReadAll(ctx context.Context, src source) ([]byte, error) {
+	rc, _, err := s.Open(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func (s *sources) conn(ctx context.Context, cfg remotefs.ConnectionConfig) (remotefs.RemoteFS, error) {
+	key := fmt.Sprintf("%s@%s:%d", cfg.User, cfg.Host, cfg.Port)
+	if fs, ok := s.conns[key]; ok {
+		return fs, nil
+	}
+	if cfg.Password == "" {
+		cfg.Password = s.password
+	}
+	cfg.KeyPath = s.keyPath
+	client := &sftpfs.Client{Logger: logger}
+	if err := client.Connect(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("sftp connect %s: %w", key, err)
+	}
+	s.conns[key] = client
+	return client, nil
+}
+
+// Close closes all remote connections.
+func (s *sources) Close() error {
+	for _, fs := range s.conns {
+		_ = fs.Close()
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/juparave/photoptim/internal/config"
+	"github.com/juparave/photoptim/internal/throttle"
+)
+
+// addThrottleFlags registers the bandwidth limit flags. Unset flags fall
+// back to the bandwidth section of the config file.
+func addThrottleFlags(cmd *cobra.Command) {
+	cmd.Flags().String("bwlimit", "", "Overall transfer limit per second, e.g. 4MB; off or empty = none (config: bandwidth.limit)")
+	cmd.Flags().String("bwlimit-up", "", "Upload limit per second (config: bandwidth.upload)")
+	cmd.Flags().String("bwlimit-down", "", "Download limit per second (config: bandwidth.download)")
+	cmd.Flags().String("bwlimit-schedule", "", "Daily windows replacing --bwlimit, e.g. 22:00-06:00=off,09:00-18:00=1MB (config: bandwidth.schedule)")
+}
+
+// throttleFromFlags builds the transfer limiter from the addThrottleFlags
+// flags and the config file; nil when nothing is limited.
+func throttleFromFlags(cmd *cobra.Command) (*throttle.Limiter, error) {
+	file, err := config.Load(config.ResolvePaths().ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	setting := func(flag, key string) string {
+		if cmd.Flags().Changed(flag) {
+			v, _ := cmd.Flags().GetString(flag)
+			return v
+		}
+		return file[key]
+	}
+	var cfg throttle.Config
+	for _, r := range []struct {
+		flag, key string
+		dst       *int64
+	}{
+		{"bwlimit", "bandwidth.limit", &cfg.Limit},
+		{"bwlimit-up", "bandwidth.upload", &cfg.Up},
+		{"bwlimit-down", "bandwidth.download", &cfg.Down},
+	} {
+		if *r.dst, err = throttle.ParseRate(setting(r.flag, r.key)); err != nil {
+			return nil, fmt.Errorf("--%s: %w", r.flag, err)
+		}
+	}
+	if cfg.Schedule, err = throttle.ParseSchedule(setting("bwlimit-schedule", "bandwidth.schedule")); err != nil {
+		return nil, fmt.Errorf("--bwlimit-schedule: %w", err)
+	}
+	return throttle.New(cfg), nil
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// File holds the settings read from the config file, keyed
+// "section.key". The file is written by hand; only a flat subset of YAML is
+// read: top-level keys or sections of scalar keys, single- or double-quoted
+// or plain values (no escapes), and # comments.
+//
+//	bandwidth:
+//	  limit: 4MB # office uplink
+//	  schedule: "22:00-06:00=off"
+type File map[string]string
+
+// Load reads the config file at path; a missing file is empty.
+func Load(path string) (File, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return File{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	file := File{}
+	section := ""
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		text := strings.TrimSpace(line)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want key: value", path, n)
+		}
+		key, value = strings.TrimSpace(key), unquote(stripComment(value))
+		switch {
+		case line[0] != ' ' && line[0] != '\t':
+			section = ""
+			if value == "" {
+				section = key
+				continue
+			}
+		case section != "":
+			key = section + "." + key
+		}
+		file[key] = value
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// stripComment trims value and removes a trailing comment: a # after a
+// space, outside a quoted value.
+func stripComment(value string) string {
+	value = strings.TrimSpace(value)
+	if value != "" && (value[0] == '"' || value[0] == '\'') {
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return value[:end+2]
+		}
+		return value
+	}
+	if strings.HasPrefix(value, "#") {
+		return ""
+	}
+	for _, sep := range []string{" #", "\t#"} {
+		if i := strings.Index(value, sep); i >= 0 {
+			value = value[:i]
+		}
+	}
+	return strings.TrimSpace(value)
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
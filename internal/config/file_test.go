@@ -0,0 +1,45 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	conf := `# photoptim settings
+bandwidth: # limits
+  limit: 2MB # office uplink
+  schedule: "22:00-06:00=off # nightly" # quoted
+hooks:
+  post_run: 'curl -X POST https://cdn.example/purge#all'
+  post_file: echo "$PHOTOPTIM_PATH" # log
+quality: 80
+`
+	if err := os.WriteFile(path, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := File{
+		"bandwidth.limit":    "2MB",
+		"bandwidth.schedule": "22:00-06:00=off # nightly",
+		"hooks.post_run":     "curl -X POST https://cdn.example/purge#all",
+		"hooks.post_file":    `echo "$PHOTOPTIM_PATH"`,
+		"quality":            "80",
+	}
+	if len(file) != len(want) {
+		t.Fatalf("got %q, want %q", file, want)
+	}
+	for k, v := range want {
+		if file[k] != v {
+			t.Errorf("%s = %q, want %q", k, file[k], v)
+		}
+	}
+	if file, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err != nil || len(file) != 0 {
+		t.Fatalf("missing file: %v, %v", file, err)
+	}
+}
@@ -0,0 +1,265 @@
+// Package filter decides which files photoptim works on. The same rules
+// are used by the pipeline, the batch commands and the TUIs; every file
+// they leave out gets a skip reason.
+package filter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register decoders for Dimensions
+	_ "image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juparave/photoptim/internal/remotefs"
+)
+
+// Skip reasons.
+const (
+	ReasonHidden       = "hidden"
+	ReasonExcluded     = "excluded"
+	ReasonNotIncluded  = "not-included"
+	ReasonFormat       = "unsupported-format"
+	ReasonTooSmall     = "too-small"
+	ReasonTooLarge     = "too-large"
+	ReasonTooOld       = "too-old"
+	ReasonTooNew       = "too-new"
+	ReasonTooFewPixels = "below-min-dimensions"
+)
+
+// DefaultMinSize is the default of the pipeline's tiny threshold: smaller
+// files rarely shrink by enough to be worth a transfer.
+const DefaultMinSize = 15 << 10
+
+var defaultExtensions = []string{"jpg", "jpeg", "png"}
+
+// Rules select files. The zero value selects every non-hidden JPEG and
+// PNG.
+type Rules struct {
+	MinSize int64 // inclusive; 0 = no minimum
+	MaxSize int64 // inclusive; 0 = no maximum
+	// Include and Exclude are path.Match globs matched against the path and
+	// the base name; with Include set, only matching files are selected.
+	// Exclude wins.
+	Include []string
+	Exclude []string
+	// ModifiedAfter and ModifiedBefore bound the mtime window; zero = open.
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	// Extensions are lowercase without dot; empty = jpg, jpeg and png.
+	Extensions []string
+	// MinWidth and MinHeight are checked by sniffing the image header (see
+	// Dimensions); 0 = any.
+	MinWidth  int
+	MinHeight int
+	Hidden    bool // select dotfiles too
+}
+
+// Match returns why e is skipped, or "" when it is selected. Directories
+// are never selected; they have no reason.
+func (r Rules) Match(e remotefs.RemoteEntry) string {
+	if e.IsDir {
+		return ""
+	}
+	p := e.Path
+	if p == "" {
+		p = e.Name
+	}
+	switch {
+	case !r.Hidden && hidden(p):
+		return ReasonHidden
+	case !r.extension(e.Name):
+		return ReasonFormat
+	case matchAny(r.Exclude, p):
+		return ReasonExcluded
+	case len(r.Include) > 0 && !matchAny(r.Include, p):
+		return ReasonNotIncluded
+	case e.Size < r.MinSize:
+		return ReasonTooSmall
+	case r.MaxSize > 0 && e.Size > r.MaxSize:
+		return ReasonTooLarge
+	case !r.ModifiedAfter.IsZero() && e.ModTime.Before(r.ModifiedAfter):
+		return ReasonTooOld
+	case !r.ModifiedBefore.IsZero() && e.ModTime.After(r.ModifiedBefore):
+		return ReasonTooNew
+	}
+	return ""
+}
+
+// NeedsDimensions reports whether MatchDimensions has anything to check.
+func (r Rules) NeedsDimensions() bool { return r.MinWidth > 0 || r.MinHeight > 0 }
+
+// MatchDimensions returns ReasonTooFewPixels for images smaller than the
+// minimum dimensions, else "".
+func (r Rules) MatchDimensions(width, height int) string {
+	if width < r.MinWidth || height < r.MinHeight {
+		return ReasonTooFewPixels
+	}
+	return ""
+}
+
+// Dimensions reads the width and height from an image header without
+// decoding the pixels.
+func Dimensions(rd io.Reader) (int, int, error) {
+	cfg, _, err := image.DecodeConfig(rd)
+	return cfg.Width, cfg.Height, err
+}
+
+// MatchRemote applies r to e on fsys. Dimension rules open the file and
+// read only its header; unreadable files pass.
+func (r Rules) MatchRemote(ctx context.Context, fsys remotefs.RemoteFS, e remotefs.RemoteEntry) string {
+	if reason := r.Match(e); reason != "" || e.IsDir || !r.NeedsDimensions() {
+		return reason
+	}
+	rc, _, err := fsys.Open(ctx, e.Path)
+	if err != nil {
+		return ""
+	}
+	defer rc.Close()
+	w, h, err := Dimensions(rc)
+	if err != nil {
+		return ""
+	}
+	return r.MatchDimensions(w, h)
+}
+
+// MatchLocal applies r to the local file at name, with info from os.Stat;
+// rel is the path that globs match, relative to the directory worked on.
+// Dimension rules read the header; unreadable files pass.
+func (r Rules) MatchLocal(name, rel string, info fs.FileInfo) string {
+	e := remotefs.RemoteEntry{Path: filepath.ToSlash(rel), Name: info.Name(), Size: info.Size(), ModTime: info.ModTime(), IsDir: info.IsDir()}
+	if reason := r.Match(e); reason != "" || e.IsDir || !r.NeedsDimensions() {
+		return reason
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	w, h, err := Dimensions(f)
+	if err != nil {
+		return ""
+	}
+	return r.MatchDimensions(w, h)
+}
+
+func (r Rules) extension(name string) bool {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	if ext == "" {
+		return false
+	}
+	exts := r.Extensions
+	if len(exts) == 0 {
+		exts = defaultExtensions
+	}
+	for _, e := range exts {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func hidden(p string) bool {
+	for _, part := range strings.Split(p, "/") {
+		if strings.HasPrefix(part, ".") && part != "." && part != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(globs []string, p string) bool {
+	base := path.Base(p)
+	for _, g := range globs {
+		if ok, _ := path.Match(g, p); ok {
+			return true
+		}
+		if ok, _ := path.Match(g, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSize parses a size such as "500KB", "2MB", "1.5mb" or "2048" (bytes).
+// Units are binary (1KB = 1024 bytes); "" is 0.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	if s == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.mult
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, errors.New("invalid size (want e.g. 500KB, 2MB)")
+	}
+	return int64(v * float64(mult)), nil
+}
+
+// ParseTime parses a point in time given as a date ("2024-05-01"), an
+// RFC 3339 timestamp, or an age relative to now ("36h", "30d").
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (want 2006-01-02, RFC 3339, or an age such as 36h or 30d)", s)
+}
+
+// ParseExtensions parses a comma-separated extension list such as
+// "jpg,.PNG".
+func ParseExtensions(s string) []string {
+	var exts []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(e), ".")); e != "" {
+			exts = append(exts, e)
+		}
+	}
+	return exts
+}
+
+// ParseDimensions parses "WxH" (either side may be 0).
+func ParseDimensions(s string) (int, int, error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	ws, hs, ok := strings.Cut(strings.ToLower(s), "x")
+	w, werr := strconv.Atoi(ws)
+	h, herr := strconv.Atoi(hs)
+	if !ok || werr != nil || herr != nil || w < 0 || h < 0 {
+		return 0, 0, fmt.Errorf("invalid dimensions %q (want WxH, e.g. 800x600)", s)
+	}
+	return w, h, nil
+}
@@ -0,0 +1,78 @@
+package filter
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/juparave/photoptim/internal/remotefs"
+)
+
+func TestMatch(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	r := Rules{
+		MinSize:        1 << 10,
+		MaxSize:        1 << 20,
+		Include:        []string{"2024/*"},
+		Exclude:        []string{"*_thumb.*"},
+		ModifiedAfter:  now.AddDate(0, -1, 0),
+		ModifiedBefore: now,
+	}
+	for _, tc := range []struct {
+		path    string
+		size    int64
+		modTime time.Time
+		want    string
+	}{
+		{"2024/a.jpg", 2 << 10, now.AddDate(0, 0, -1), ""},
+		{"2024/.a.jpg", 2 << 10, now.AddDate(0, 0, -1), ReasonHidden},
+		{"2024/a.gif", 2 << 10, now.AddDate(0, 0, -1), ReasonFormat},
+		{"2024/a_thumb.jpg", 2 << 10, now.AddDate(0, 0, -1), ReasonExcluded},
+		{"2023/a.jpg", 2 << 10, now.AddDate(0, 0, -1), ReasonNotIncluded},
+		{"2024/a.PNG", 512, now.AddDate(0, 0, -1), ReasonTooSmall},
+		{"2024/a.jpg", 2 << 20, now.AddDate(0, 0, -1), ReasonTooLarge},
+		{"2024/a.jpg", 2 << 10, now.AddDate(0, -2, 0), ReasonTooOld},
+		{"2024/a.jpg", 2 << 10, now.AddDate(0, 0, 1), ReasonTooNew},
+	} {
+		e := remotefs.RemoteEntry{Path: tc.path, Name: tc.path[5:], Size: tc.size, ModTime: tc.modTime}
+		if got := r.Match(e); got != tc.want {
+			t.Errorf("Match(%s, %d bytes, %s) = %q, want %q", tc.path, tc.size, tc.modTime.Format(time.DateOnly), got, tc.want)
+		}
+	}
+}
+
+func TestDimensions(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	w, h, err := Dimensions(&buf)
+	if err != nil || w != 40 || h != 30 {
+		t.Fatalf("Dimensions = %dx%d, %v; want 40x30", w, h, err)
+	}
+	if got := (Rules{MinWidth: 50}).MatchDimensions(w, h); got != ReasonTooFewPixels {
+		t.Fatalf("MatchDimensions = %q", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for in, want := range map[string]int64{"": 0, "2048": 2048, "500KB": 500 << 10, "2mb": 2 << 20, "1.5M": 3 << 19} {
+		if got, err := ParseSize(in); err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	if _, err := ParseSize("lots"); err == nil {
+		t.Error("ParseSize accepted garbage")
+	}
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for in, want := range map[string]time.Time{"36h": now.Add(-36 * time.Hour), "30d": now.AddDate(0, 0, -30), "2024-05-01T00:00:00Z": time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)} {
+		if got, err := ParseTime(in, now); err != nil || !got.Equal(want) {
+			t.Errorf("ParseTime(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if w, h, err := ParseDimensions("800x0"); err != nil || w != 800 || h != 0 {
+		t.Errorf("ParseDimensions(800x0) = %d, %d, %v", w, h, err)
+	}
+}
@@ -0,0 +1,51 @@
+package imageinfo
+
+import "encoding/binary"
+
+// EXIF holds the few EXIF fields that matter for optimization.
+type EXIF struct {
+	Orientation int  // 1-8; 0 if absent
+	GPS         bool // a GPS IFD is present
+}
+
+// EXIF tags read from IFD0.
+const (
+	tagOrientation = 0x0112
+	tagGPSIFD      = 0x8825
+)
+
+// parseTIFF reads IFD0 of a TIFF-structured EXIF block. Malformed blocks
+// yield an empty EXIF rather than an error: metadata is informational.
+func parseTIFF(b []byte) *EXIF {
+	e := &EXIF{}
+	if len(b) < 8 {
+		return e
+	}
+	var bo binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return e
+	}
+	ifd := int(bo.Uint32(b[4:8]))
+	if ifd < 8 || ifd+2 > len(b) {
+		return e
+	}
+	n := int(bo.Uint16(b[ifd:]))
+	for i := 0; i < n; i++ {
+		off := ifd + 2 + 12*i
+		if off+12 > len(b) {
+			break
+		}
+		switch bo.Uint16(b[off:]) {
+		case tagOrientation:
+			e.Orientation = int(bo.Uint16(b[off+8:]))
+		case tagGPSIFD:
+			e.GPS = true
+		}
+	}
+	return e
+}
@@ -0,0 +1,158 @@
+package imageinfo
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// ErrUnknownFormat is returned by Inspect for anything but JPEG and PNG.
+var ErrUnknownFormat = errors.New("unknown image format")
+
+// Info summarizes an image file for display.
+type Info struct {
+	Format       string     `json:"format"` // "jpeg" or "png"
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	ColorModel   string     `json:"colorModel"`
+	BitDepth     int        `json:"bitDepth"`               // bits per sample
+	Progressive  bool       `json:"progressive"`            // progressive JPEG or interlaced PNG
+	Quality      int        `json:"quality,omitempty"`      // estimated JPEG quality
+	QualityExact bool       `json:"qualityExact,omitempty"` // Quality matches IJG tables exactly
+	Subsampling  string     `json:"subsampling,omitempty"`
+	Orientation  int        `json:"orientation,omitempty"` // EXIF orientation 1-8
+	GPS          bool       `json:"gps"`
+	Metadata     []Metadata `json:"metadata"`
+}
+
+// Metadata is one kind of metadata present in the file, e.g. all ICC
+// segments together.
+type Metadata struct {
+	Kind  string `json:"kind"` // EXIF, ICC, XMP, IPTC, JFIF, Adobe, Comment, Text, Time or the raw segment/chunk name
+	Size  int64  `json:"size"` // bytes, including segment/chunk headers
+	Count int    `json:"count"`
+}
+
+// Inspect reads the headers of a JPEG or PNG from r. For JPEGs it stops at
+// the first scan; PNG image data is skipped.
+func Inspect(r io.Reader) (*Info, error) {
+	br := bufio.NewReader(r)
+	sig, _ := br.Peek(len(pngSignature))
+	switch {
+	case bytes.HasPrefix(sig, []byte{0xFF, 0xD8}):
+		j, err := ParseJPEG(br)
+		if err != nil {
+			return nil, err
+		}
+		return jpegInfo(j), nil
+	case bytes.Equal(sig, pngSignature):
+		p, err := ParsePNG(br)
+		if err != nil {
+			return nil, err
+		}
+		return pngInfo(p), nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+func jpegInfo(j *JPEG) *Info {
+	info := &Info{
+		Format:      "jpeg",
+		Width:       j.Width,
+		Height:      j.Height,
+		ColorModel:  j.ColorModel(),
+		BitDepth:    j.Precision,
+		Progressive: j.Progressive,
+		Subsampling: j.Subsampling(),
+	}
+	info.Quality, info.QualityExact, _ = j.EstimateQuality()
+	info.setEXIF(j.EXIF)
+	md := metadataSet{list: []Metadata{}}
+	for _, s := range j.Segments {
+		if s.Metadata() {
+			md.add(segmentKind(s), s.Size)
+		}
+	}
+	info.Metadata = md.list
+	return info
+}
+
+func pngInfo(p *PNG) *Info {
+	info := &Info{
+		Format:      "png",
+		Width:       p.Width,
+		Height:      p.Height,
+		ColorModel:  p.ColorModel(),
+		BitDepth:    p.BitDepth,
+		Progressive: p.Interlaced,
+	}
+	info.setEXIF(p.EXIF)
+	md := metadataSet{list: []Metadata{}}
+	for _, c := range p.Chunks {
+		if c.Metadata() {
+			md.add(chunkKind(c), c.Size)
+		}
+	}
+	info.Metadata = md.list
+	return info
+}
+
+func (info *Info) setEXIF(e *EXIF) {
+	if e != nil {
+		info.Orientation, info.GPS = e.Orientation, e.GPS
+	}
+}
+
+func segmentKind(s Segment) string {
+	switch {
+	case s.Marker == markerCOM:
+		return "Comment"
+	case s.Marker == markerAPP0 && s.ID == "JFIF":
+		return "JFIF"
+	case s.Marker == markerAPP0+1 && s.ID == "Exif":
+		return "EXIF"
+	case s.Marker == markerAPP0+1 && s.ID == "http://ns.adobe.com/xap/1.0/", s.Marker == markerAPP0+1 && s.ID == "http://ns.adobe.com/xmp/extension/":
+		return "XMP"
+	case s.Marker == markerAPP0+2 && s.ID == "ICC_PROFILE":
+		return "ICC"
+	case s.Marker == markerAPP0+13 && s.ID == "Photoshop 3.0":
+		return "IPTC"
+	case s.Marker == markerAPP0+14 && s.ID == "Adobe":
+		return "Adobe"
+	}
+	return s.Name()
+}
+
+func chunkKind(c Chunk) string {
+	switch c.Type {
+	case "eXIf":
+		return "EXIF"
+	case "iCCP":
+		return "ICC"
+	case "iTXt":
+		if c.Keyword == "XML:com.adobe.xmp" {
+			return "XMP"
+		}
+		return "Text"
+	case "tEXt", "zTXt":
+		return "Text"
+	case "tIME":
+		return "Time"
+	}
+	return c.Type
+}
+
+// metadataSet aggregates metadata by kind, keeping first-seen order.
+type metadataSet struct{ list []Metadata }
+
+func (m *metadataSet) add(kind string, size int64) {
+	for i := range m.list {
+		if m.list[i].Kind == kind {
+			m.list[i].Size += size
+			m.list[i].Count++
+			return
+		}
+	}
+	m.list = append(m.list, Metadata{Kind: kind, Size: size, Count: 1})
+}
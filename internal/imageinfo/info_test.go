@@ -0,0 +1,47 @@
+package imageinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// segment builds a JPEG marker segment with the given payload.
+func segment(marker byte, payload []byte) []byte {
+	b := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(payload)+2))
+	return append(b, payload...)
+}
+
+func TestInspectJPEGMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), &jpeg.Options{Quality: 70}); err != nil {
+		t.Fatal(err)
+	}
+	// Little-endian TIFF with two IFD0 entries: orientation 6 and a GPS IFD pointer.
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x02\x00" +
+		"\x12\x01\x03\x00\x01\x00\x00\x00\x06\x00\x00\x00" +
+		"\x25\x88\x04\x00\x01\x00\x00\x00\x26\x00\x00\x00")
+	xmp := append([]byte("http://ns.adobe.com/xap/1.0/\x00"), "<x:xmpmeta/>"...)
+	data := append([]byte{0xFF, 0xD8}, segment(0xE1, append([]byte("Exif\x00\x00"), tiff...))...)
+	data = append(data, segment(0xE1, xmp)...)
+	data = append(data, segment(0xFE, []byte("note"))...)
+	data = append(data, buf.Bytes()[2:]...)
+
+	info, err := Inspect(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ColorModel != "Gray" || info.Quality != 70 || !info.QualityExact || info.Orientation != 6 || !info.GPS {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	kinds := map[string]int64{}
+	for _, m := range info.Metadata {
+		kinds[m.Kind] = m.Size
+	}
+	if kinds["EXIF"] != int64(4+6+len(tiff)) || kinds["XMP"] != int64(4+len(xmp)) || kinds["Comment"] != 8 {
+		t.Fatalf("unexpected metadata %+v", info.Metadata)
+	}
+}
@@ -0,0 +1,294 @@
+// Package imageinfo reads image headers without decoding pixel data.
+package imageinfo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// JPEG markers used by the parser.
+const (
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerDQT  = 0xDB
+	markerDRI  = 0xDD
+	markerCOM  = 0xFE
+	markerAPP0 = 0xE0
+	markerAPPF = 0xEF
+)
+
+// ErrNotJPEG is returned when the input does not start with a JPEG SOI marker.
+var ErrNotJPEG = errors.New("not a JPEG")
+
+// Segment is one marker segment of a JPEG header.
+type Segment struct {
+	Marker byte   // second marker byte, e.g. 0xE1 for APP1
+	Offset int64  // offset of the 0xFF marker byte
+	Size   int64  // marker plus payload
+	ID     string // APPn identifier such as "Exif", "ICC_PROFILE" or "http://ns.adobe.com/xap/1.0/"
+}
+
+// Name returns a short marker name such as "APP1", "DQT" or "SOF0".
+func (s Segment) Name() string {
+	switch {
+	case s.Marker >= markerAPP0 && s.Marker <= markerAPPF:
+		return fmt.Sprintf("APP%d", s.Marker-markerAPP0)
+	case s.Marker >= 0xC0 && s.Marker <= 0xCF && s.Marker != 0xC4 && s.Marker != 0xC8 && s.Marker != 0xCC:
+		return fmt.Sprintf("SOF%d", s.Marker-0xC0)
+	}
+	switch s.Marker {
+	case 0xC4:
+		return "DHT"
+	case markerDQT:
+		return "DQT"
+	case markerDRI:
+		return "DRI"
+	case markerSOS:
+		return "SOS"
+	case markerCOM:
+		return "COM"
+	}
+	return fmt.Sprintf("0x%02X", s.Marker)
+}
+
+// Metadata reports whether s only carries metadata (APPn or COM).
+func (s Segment) Metadata() bool {
+	return s.Marker == markerCOM || (s.Marker >= markerAPP0 && s.Marker <= markerAPPF)
+}
+
+// Essential reports whether a metadata segment affects how the image is
+// displayed: the JFIF header, EXIF (orientation), ICC profiles and the Adobe
+// color transform marker.
+func (s Segment) Essential() bool {
+	switch {
+	case s.Marker == markerAPP0 && s.ID == "JFIF":
+		return true
+	case s.Marker == markerAPP0+1 && s.ID == "Exif":
+		return true
+	case s.Marker == markerAPP0+2 && s.ID == "ICC_PROFILE":
+		return true
+	case s.Marker == markerAPP0+14 && s.ID == "Adobe":
+		return true
+	}
+	return false
+}
+
+// JPEG describes a JPEG header, up to and including the first SOS segment.
+type JPEG struct {
+	Width, Height int
+	Components    int
+	Precision     int // bits per sample
+	Progressive   bool
+	Sampling      [][2]int       // horizontal/vertical sampling factor per component
+	Quant         [4]*[64]uint16 // quantization tables by id, zig-zag order; nil if absent
+	EXIF          *EXIF          // parsed APP1 Exif segment, if any
+	Adobe         int            // APP14 Adobe color transform (0 RGB/CMYK, 1 YCbCr, 2 YCCK); -1 if absent
+	Segments      []Segment
+	HeaderSize    int64 // bytes up to the end of the SOS segment
+}
+
+// ParseJPEG reads a JPEG header from r, stopping right after the first SOS
+// segment, so r is left positioned at the entropy-coded data. It reads
+// exactly HeaderSize bytes; wrap unbuffered readers in a bufio.Reader.
+func ParseJPEG(r io.Reader) (*JPEG, error) {
+	p := &parser{r: r}
+	var soi [2]byte
+	if err := p.full(soi[:]); err != nil {
+		return nil, err
+	}
+	if soi[0] != 0xFF || soi[1] != markerSOI {
+		return nil, ErrNotJPEG
+	}
+	j := &JPEG{Adobe: -1}
+	for {
+		start, marker, err := p.marker()
+		if err != nil {
+			return nil, err
+		}
+		if marker == markerEOI {
+			return nil, errors.New("jpeg: no image data")
+		}
+		var lb [2]byte
+		if err := p.full(lb[:]); err != nil {
+			return nil, err
+		}
+		n := int(lb[0])<<8 | int(lb[1])
+		if n < 2 {
+			return nil, fmt.Errorf("jpeg: invalid %02X segment length", marker)
+		}
+		payload := make([]byte, n-2)
+		if err := p.full(payload); err != nil {
+			return nil, err
+		}
+		seg := Segment{Marker: marker, Offset: start, Size: p.n - start}
+		switch {
+		case marker >= markerAPP0 && marker <= markerAPPF:
+			seg.ID = appID(payload)
+			switch {
+			case marker == markerAPP0+1 && seg.ID == "Exif" && len(payload) > 6:
+				j.EXIF = parseTIFF(payload[6:])
+			case marker == markerAPP0+14 && seg.ID == "Adobe" && len(payload) >= 12:
+				j.Adobe = int(payload[11])
+			}
+		case marker == markerDQT:
+			if err := j.parseDQT(payload); err != nil {
+				return nil, err
+			}
+		case marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			if len(payload) < 6 {
+				return nil, errors.New("jpeg: short SOF segment")
+			}
+			j.Precision = int(payload[0])
+			j.Height = int(payload[1])<<8 | int(payload[2])
+			j.Width = int(payload[3])<<8 | int(payload[4])
+			j.Components = int(payload[5])
+			j.Sampling = j.Sampling[:0]
+			for i := 0; i < j.Components && 6+3*i+2 < len(payload); i++ {
+				hv := payload[6+3*i+1]
+				j.Sampling = append(j.Sampling, [2]int{int(hv >> 4), int(hv & 0x0F)})
+			}
+			j.Progressive = marker == 0xC2 || marker == 0xC6 || marker == 0xCA || marker == 0xCE
+		}
+		j.Segments = append(j.Segments, seg)
+		if marker == markerSOS {
+			j.HeaderSize = p.n
+			return j, nil
+		}
+	}
+}
+
+func (j *JPEG) parseDQT(b []byte) error {
+	for len(b) > 0 {
+		pq, tq := b[0]>>4, b[0]&0x0F
+		if tq > 3 {
+			return errors.New("jpeg: invalid DQT table id")
+		}
+		size := 64
+		if pq == 1 {
+			size = 128
+		}
+		if len(b) < 1+size {
+			return errors.New("jpeg: short DQT segment")
+		}
+		t := new([64]uint16)
+		for i := range t {
+			if pq == 1 {
+				t[i] = uint16(b[1+2*i])<<8 | uint16(b[2+2*i])
+			} else {
+				t[i] = uint16(b[1+i])
+			}
+		}
+		j.Quant[tq] = t
+		b = b[1+size:]
+	}
+	return nil
+}
+
+// Subsampling returns the chroma subsampling in J:a:b notation ("4:2:0",
+// "4:4:4", ...), or "" for single-component images.
+func (j *JPEG) Subsampling() string {
+	if len(j.Sampling) < 3 {
+		return ""
+	}
+	y, c := j.Sampling[0], j.Sampling[1]
+	if c != [2]int{1, 1} || j.Sampling[2] != c {
+		return fmt.Sprintf("%dx%d,%dx%d,%dx%d", y[0], y[1], c[0], c[1], j.Sampling[2][0], j.Sampling[2][1])
+	}
+	switch y {
+	case [2]int{1, 1}:
+		return "4:4:4"
+	case [2]int{2, 1}:
+		return "4:2:2"
+	case [2]int{2, 2}:
+		return "4:2:0"
+	case [2]int{1, 2}:
+		return "4:4:0"
+	case [2]int{4, 1}:
+		return "4:1:1"
+	}
+	return fmt.Sprintf("%dx%d,1x1,1x1", y[0], y[1])
+}
+
+// ColorModel returns the color model implied by the component count and
+// the Adobe transform flag.
+func (j *JPEG) ColorModel() string {
+	switch j.Components {
+	case 1:
+		return "Gray"
+	case 3:
+		if j.Adobe == 0 {
+			return "RGB"
+		}
+		return "YCbCr"
+	case 4:
+		if j.Adobe == 2 {
+			return "YCCK"
+		}
+		return "CMYK"
+	}
+	return fmt.Sprintf("%d components", j.Components)
+}
+
+// appID returns the NUL-terminated identifier at the start of an APPn payload.
+func appID(payload []byte) string {
+	if i := bytes.IndexByte(payload, 0); i >= 0 && i <= 64 {
+		return string(payload[:i])
+	}
+	return ""
+}
+
+// StripMetadata returns head (the first HeaderSize bytes of the file) without
+// the metadata segments that are not Essential, and the number of bytes
+// removed. The entropy-coded data that follows head is unaffected.
+func (j *JPEG) StripMetadata(head []byte) ([]byte, int64) {
+	out := make([]byte, 0, len(head))
+	pos := int64(0)
+	for _, s := range j.Segments {
+		if !s.Metadata() || s.Essential() {
+			continue
+		}
+		out = append(out, head[pos:s.Offset]...)
+		pos = s.Offset + s.Size
+	}
+	out = append(out, head[pos:]...)
+	return out, int64(len(head) - len(out))
+}
+
+// parser reads markers and segments while tracking the offset.
+type parser struct {
+	r io.Reader
+	n int64
+}
+
+func (p *parser) full(b []byte) error {
+	n, err := io.ReadFull(p.r, b)
+	p.n += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// marker reads the next marker, skipping 0xFF fill bytes, and returns the
+// offset of its last 0xFF byte.
+func (p *parser) marker() (int64, byte, error) {
+	var b [1]byte
+	if err := p.full(b[:]); err != nil {
+		return 0, 0, err
+	}
+	if b[0] != 0xFF {
+		return 0, 0, fmt.Errorf("jpeg: expected marker at offset %d", p.n-1)
+	}
+	for {
+		start := p.n - 1
+		if err := p.full(b[:]); err != nil {
+			return 0, 0, err
+		}
+		if b[0] != 0xFF {
+			return start, b[0], nil
+		}
+	}
+}
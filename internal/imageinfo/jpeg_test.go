@@ -0,0 +1,33 @@
+package imageinfo
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func TestEstimateQualityGoEncoder(t *testing.T) {
+	img := image.NewYCbCr(image.Rect(0, 0, 16, 16), image.YCbCrSubsampleRatio420)
+	for _, q := range []int{10, 35, 50, 75, 90} {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: q}); err != nil {
+			t.Fatal(err)
+		}
+		r := bytes.NewReader(buf.Bytes())
+		info, err := ParseJPEG(r)
+		if err != nil {
+			t.Fatalf("q%d: %v", q, err)
+		}
+		if info.Width != 16 || info.Height != 16 || info.Components != 3 {
+			t.Fatalf("q%d: header %+v", q, info)
+		}
+		if got := int64(buf.Len() - r.Len()); got != info.HeaderSize {
+			t.Fatalf("q%d: consumed %d bytes, HeaderSize %d", q, got, info.HeaderSize)
+		}
+		est, exact, ok := info.EstimateQuality()
+		if !ok || !exact || est != q {
+			t.Fatalf("q%d: estimated %d (exact=%v ok=%v)", q, est, exact, ok)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package imageinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotPNG is returned when the input does not start with the PNG signature.
+var ErrNotPNG = errors.New("not a PNG")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Chunk is one PNG chunk.
+type Chunk struct {
+	Type    string
+	Offset  int64
+	Size    int64  // length, type, data and CRC
+	Keyword string // tEXt/zTXt/iTXt keyword or iCCP profile name
+}
+
+// Metadata reports whether c only carries metadata.
+func (c Chunk) Metadata() bool {
+	switch c.Type {
+	case "tEXt", "zTXt", "iTXt", "eXIf", "iCCP", "tIME":
+		return true
+	}
+	return false
+}
+
+// PNG describes a PNG file's header and chunk layout.
+type PNG struct {
+	Width, Height int
+	BitDepth      int
+	ColorType     int
+	Interlaced    bool
+	Chunks        []Chunk
+	EXIF          *EXIF // parsed eXIf chunk, if any
+}
+
+// ParsePNG reads all chunks of a PNG from r. Image data is skipped, not
+// decompressed.
+func ParsePNG(r io.Reader) (*PNG, error) {
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sig, pngSignature) {
+		return nil, ErrNotPNG
+	}
+	p := &PNG{}
+	off := int64(len(pngSignature))
+	var hdr [8]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF {
+				return nil, errors.New("png: missing IEND")
+			}
+			return nil, err
+		}
+		n := int64(binary.BigEndian.Uint32(hdr[:4]))
+		c := Chunk{Type: string(hdr[4:8]), Offset: off, Size: 12 + n}
+		var data []byte
+		switch c.Type {
+		case "IHDR", "tEXt", "zTXt", "iTXt", "iCCP", "eXIf":
+			// Small enough to read; the keyword sits at the start.
+			data = make([]byte, n)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+		default:
+			if _, err := io.CopyN(io.Discard, r, n); err != nil {
+				return nil, fmt.Errorf("png: %s chunk: %w", c.Type, err)
+			}
+		}
+		if _, err := io.CopyN(io.Discard, r, 4); err != nil { // CRC
+			return nil, err
+		}
+		switch c.Type {
+		case "IHDR":
+			if len(data) < 13 {
+				return nil, errors.New("png: short IHDR")
+			}
+			p.Width = int(binary.BigEndian.Uint32(data[0:4]))
+			p.Height = int(binary.BigEndian.Uint32(data[4:8]))
+			p.BitDepth = int(data[8])
+			p.ColorType = int(data[9])
+			p.Interlaced = data[12] == 1
+		case "tEXt", "zTXt", "iTXt", "iCCP":
+			if i := bytes.IndexByte(data, 0); i >= 0 {
+				c.Keyword = string(data[:i])
+			}
+		case "eXIf":
+			p.EXIF = parseTIFF(data)
+		}
+		p.Chunks = append(p.Chunks, c)
+		off += c.Size
+		if c.Type == "IEND" {
+			return p, nil
+		}
+	}
+}
+
+// ColorModel returns the color model named by the IHDR color type.
+func (p *PNG) ColorModel() string {
+	switch p.ColorType {
+	case 0:
+		return "Gray"
+	case 2:
+		return "RGB"
+	case 3:
+		return "Paletted"
+	case 4:
+		return "GrayAlpha"
+	case 6:
+		return "RGBA"
+	}
+	return fmt.Sprintf("color type %d", p.ColorType)
+}
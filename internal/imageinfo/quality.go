@@ -0,0 +1,69 @@
+package imageinfo
+
+// ijgQuant are the IJG (libjpeg, and Go's image/jpeg) base tables in zig-zag
+// order, from section K.1 of the JPEG spec. Encoders scale them by quality.
+var ijgQuant = [2][64]uint16{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// ijgScale returns the IJG table value for base entry v at quality q.
+func ijgScale(v uint16, q int) int {
+	scale := 200 - 2*q
+	if q < 50 {
+		scale = 5000 / q
+	}
+	x := (int(v)*scale + 50) / 100
+	return min(255, max(1, x))
+}
+
+// EstimateQuality returns the IJG quality (1-100) whose tables best match
+// the file's luma and chroma tables. exact is true when the tables are
+// IJG tables at that quality, as written by libjpeg, Go and most web
+// tools; camera and Photoshop tables only get the closest fit. Several
+// qualities can produce identical tables; the highest is returned. ok is
+// false when the file has no luma table.
+func (j *JPEG) EstimateQuality() (q int, exact, ok bool) {
+	if j.Quant[0] == nil {
+		return 0, false, false
+	}
+	best := -1
+	for cand := 1; cand <= 100; cand++ {
+		dist := 0
+		for t := 0; t < 2; t++ {
+			table := j.Quant[t]
+			if table == nil {
+				continue
+			}
+			for i, v := range table {
+				d := int(v) - ijgScale(ijgQuant[t][i], cand)
+				if d < 0 {
+					d = -d
+				}
+				dist += d
+			}
+		}
+		if best < 0 || dist <= best {
+			q, best = cand, dist
+		}
+	}
+	return q, best == 0, true
+}
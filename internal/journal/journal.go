@@ -0,0 +1,333 @@
+// Package journal records the files of a batch run and how far each got, so
+// an interrupted run can be resumed. Journals live in the bbolt database of
+// the directory cache.
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/juparave/photoptim/internal/remotefs"
+)
+
+// Status is the state of one file in a run.
+type Status string
+
+const (
+	StatusPending Status = "pending" // not finished yet (possibly interrupted mid-way)
+	StatusDone    Status = "done"    // replaced, or original kept
+	StatusSkipped Status = "skipped" // left alone on purpose, e.g. decode-error
+	StatusFailed  Status = "failed"
+)
+
+// ErrNoRun is returned by Store.Open for unknown run IDs.
+var ErrNoRun = errors.New("no such run")
+
+// Run describes a journaled run.
+type Run struct {
+	ID      string    `json:"id"`
+	Target  string    `json:"target"` // remote the run works on, e.g. user@host:22/var/www
+	Dir     string    `json:"dir"`    // directory, relative to the remote root
+	Created time.Time `json:"created"`
+	Files   int       `json:"files"`
+	Backup  string    `json:"backup,omitempty"` // where originals are backed up: "remote", "local" or ""
+}
+
+// Entry is one file of a run.
+type Entry struct {
+	File    remotefs.RemoteEntry `json:"file"`
+	Phase   string               `json:"phase,omitempty"` // last phase finished
+	Status  Status               `json:"status"`
+	Reason  string               `json:"reason,omitempty"`
+	Error   string               `json:"error,omitempty"`
+	Updated time.Time            `json:"updated"`
+}
+
+var runsBucket = []byte("runs")
+
+const (
+	metaKey     = "meta"
+	filesBucket = "files"
+)
+
+// DefaultMaxAge is how long NewStore keeps unfinished runs resumable.
+const DefaultMaxAge = 30 * 24 * time.Hour
+
+// Store keeps journals in a bbolt database.
+type Store struct {
+	db *bolt.DB
+	// MaxAge is how long unfinished runs are kept; 0 keeps them until they
+	// finish. Create prunes older runs and every finished one.
+	MaxAge time.Duration
+}
+
+// NewStore uses db, e.g. cache.DirectoryCache.DB.
+func NewStore(db *bolt.DB) *Store { return &Store{db: db, MaxAge: DefaultMaxAge} }
+
+// Create starts the journal of run with every file pending; more can be
+// added as they are found (see Journal.Add). Finished runs, and runs older
+// than MaxAge, are dropped first.
+func (s *Store) Create(run Run, files []remotefs.RemoteEntry) (*Journal, error) {
+	run.Files = len(files)
+	if run.Created.IsZero() {
+		run.Created = time.Now()
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		runs, err := tx.CreateBucketIfNotExists(runsBucket)
+		if err != nil {
+			return err
+		}
+		var cutoff time.Time
+		if s.MaxAge > 0 {
+			cutoff = run.Created.Add(-s.MaxAge)
+		}
+		if err := prune(runs, cutoff); err != nil {
+			return err
+		}
+		if runs.Bucket([]byte(run.ID)) != nil {
+			return fmt.Errorf("run %s already exists", run.ID)
+		}
+		rb, err := runs.CreateBucket([]byte(run.ID))
+		if err != nil {
+			return err
+		}
+		if err := putJSON(rb, metaKey, run); err != nil {
+			return err
+		}
+		fb, err := rb.CreateBucket([]byte(filesBucket))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := putJSON(fb, f.Path, Entry{File: f, Status: StatusPending, Updated: run.Created}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{db: s.db, id: run.ID, run: run}, nil
+}
+
+// Open returns the journal of run id.
+func (s *Store) Open(id string) (*Journal, error) {
+	var run Run
+	err := s.db.View(func(tx *bolt.Tx) error {
+		rb := runBucket(tx, id)
+		if rb == nil {
+			return fmt.Errorf("%w: %s", ErrNoRun, id)
+		}
+		return json.Unmarshal(rb.Get([]byte(metaKey)), &run)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{db: s.db, id: run.ID, run: run}, nil
+}
+
+// Runs returns all journaled runs, oldest first.
+func (s *Store) Runs() ([]Run, error) {
+	var out []Run
+	err := s.db.View(func(tx *bolt.Tx) error {
+		runs := tx.Bucket(runsBucket)
+		if runs == nil {
+			return nil
+		}
+		return runs.ForEachBucket(func(k []byte) error {
+			var run Run
+			if err := json.Unmarshal(runs.Bucket(k).Get([]byte(metaKey)), &run); err != nil {
+				return err
+			}
+			out = append(out, run)
+			return nil
+		})
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out, err
+}
+
+// Journal is the journal of one run. It is safe for concurrent use.
+type Journal struct {
+	db  *bolt.DB
+	id  string
+	mu  sync.Mutex // guards run
+	run Run
+}
+
+// Run returns the run's description.
+func (j *Journal) Run() Run {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.run
+}
+
+// Add records files as pending, e.g. as a recursive walk finds them; files
+// already in the journal are left as they are.
+func (j *Journal) Add(files ...remotefs.RemoteEntry) error {
+	var run Run
+	err := j.db.Update(func(tx *bolt.Tx) error {
+		rb := runBucket(tx, j.id)
+		if rb == nil {
+			return fmt.Errorf("%w: %s", ErrNoRun, j.id)
+		}
+		if err := json.Unmarshal(rb.Get([]byte(metaKey)), &run); err != nil {
+			return err
+		}
+		fb := rb.Bucket([]byte(filesBucket))
+		now := time.Now()
+		for _, f := range files {
+			if fb.Get([]byte(f.Path)) != nil {
+				continue
+			}
+			if err := putJSON(fb, f.Path, Entry{File: f, Status: StatusPending, Updated: now}); err != nil {
+				return err
+			}
+			run.Files++
+		}
+		return putJSON(rb, metaKey, run)
+	})
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.run = run
+	j.mu.Unlock()
+	return nil
+}
+
+// Update records that path finished phase with status. Updates from several
+// goroutines are batched into shared transactions.
+func (j *Journal) Update(path, phase string, status Status, reason string, failure error) error {
+	return j.db.Batch(func(tx *bolt.Tx) error {
+		fb := filesOf(tx, j.id)
+		if fb == nil {
+			return fmt.Errorf("%w: %s", ErrNoRun, j.id)
+		}
+		var e Entry
+		if b := fb.Get([]byte(path)); b != nil {
+			if err := json.Unmarshal(b, &e); err != nil {
+				return err
+			}
+		} else {
+			e.File = remotefs.RemoteEntry{Path: path}
+		}
+		e.Phase, e.Status, e.Reason, e.Error, e.Updated = phase, status, reason, "", time.Now()
+		if failure != nil {
+			e.Error = failure.Error()
+		}
+		return putJSON(fb, path, e)
+	})
+}
+
+// Entries returns the run's files, by path.
+func (j *Journal) Entries() ([]Entry, error) {
+	var out []Entry
+	err := j.db.View(func(tx *bolt.Tx) error {
+		fb := filesOf(tx, j.id)
+		if fb == nil {
+			return fmt.Errorf("%w: %s", ErrNoRun, j.id)
+		}
+		return fb.ForEach(func(_, v []byte) error {
+			var e Entry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			out = append(out, e)
+			return nil
+		})
+	})
+	return out, err
+}
+
+// Unfinished returns the files still pending or failed: what a resumed run
+// has to process.
+func (j *Journal) Unfinished() ([]remotefs.RemoteEntry, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+	var out []remotefs.RemoteEntry
+	for _, e := range entries {
+		if e.Status == StatusPending || e.Status == StatusFailed {
+			out = append(out, e.File)
+		}
+	}
+	return out, nil
+}
+
+// prune deletes the finished runs, and those created before cutoff.
+func prune(runs *bolt.Bucket, cutoff time.Time) error {
+	var stale [][]byte
+	err := runs.ForEachBucket(func(k []byte) error {
+		rb := runs.Bucket(k)
+		var run Run
+		if err := json.Unmarshal(rb.Get([]byte(metaKey)), &run); err != nil {
+			return err
+		}
+		if run.Created.Before(cutoff) {
+			stale = append(stale, k)
+			return nil
+		}
+		finished := true
+		if fb := rb.Bucket([]byte(filesBucket)); fb != nil {
+			err := fb.ForEach(func(_, v []byte) error {
+				var e Entry
+				if err := json.Unmarshal(v, &e); err != nil {
+					return err
+				}
+				if e.Status == StatusPending || e.Status == StatusFailed {
+					finished = false
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if finished {
+			stale = append(stale, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range stale {
+		if err := runs.DeleteBucket(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runBucket(tx *bolt.Tx, id string) *bolt.Bucket {
+	runs := tx.Bucket(runsBucket)
+	if runs == nil {
+		return nil
+	}
+	return runs.Bucket([]byte(id))
+}
+
+func filesOf(tx *bolt.Tx, id string) *bolt.Bucket {
+	rb := runBucket(tx, id)
+	if rb == nil {
+		return nil
+	}
+	return rb.Bucket([]byte(filesBucket))
+}
+
+func putJSON(b *bolt.Bucket, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), data)
+}
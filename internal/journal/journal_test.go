@@ -0,0 +1,51 @@
+package journal
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/juparave/photoptim/internal/remotefs"
+)
+
+func TestCreatePrunes(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "journal.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := NewStore(db)
+	now := time.Now()
+	files := []remotefs.RemoteEntry{{Path: "a.jpg"}}
+
+	if _, err := s.Create(Run{ID: "old", Created: now.Add(-2 * DefaultMaxAge)}, files); err != nil {
+		t.Fatal(err)
+	}
+	done, err := s.Create(Run{ID: "done", Created: now.Add(-time.Hour)}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := done.Update("a.jpg", "upload", StatusDone, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(Run{ID: "pending", Created: now.Add(-time.Hour)}, files); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(Run{ID: "new", Created: now}, files); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := s.Runs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range runs {
+		ids = append(ids, r.ID)
+	}
+	if len(ids) != 2 || ids[0] != "pending" || ids[1] != "new" {
+		t.Fatalf("want the unfinished recent runs [pending new], got %v", ids)
+	}
+}
@@ -0,0 +1,183 @@
+// Package ledger remembers the files photoptim optimized, keyed by host and
+// path, so that re-running a job skips files that have not changed since.
+// The ledger lives in the bbolt database of the directory cache.
+package ledger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// LocalHost is the host of files on the local disk.
+const LocalHost = "local"
+
+// Host identifies a remote in the ledger.
+func Host(user, host string, port int) string {
+	return fmt.Sprintf("%s@%s:%d", user, host, port)
+}
+
+// Path returns the ledger key of remote path p on a connection rooted at
+// root, so runs started from different directories agree.
+func Path(root, p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	return path.Join(root, p)
+}
+
+// Record is what the ledger knows about one optimized file.
+type Record struct {
+	Size    int64     `json:"size"`    // of the file photoptim left behind
+	ModTime time.Time `json:"modTime"` // of the file photoptim left behind
+	Hash    string    `json:"sha256"`  // hex SHA-256 of its content
+	Reason  string    `json:"reason,omitempty"`
+	// Source is the input a local output was written from, with its size
+	// and mtime at the time; empty for files optimized in place.
+	Source        string    `json:"source,omitempty"`
+	SourceSize    int64     `json:"sourceSize,omitempty"`
+	SourceModTime time.Time `json:"sourceModTime,omitempty"`
+	Optimized     time.Time `json:"optimized"`
+}
+
+// Matches reports whether a file of size and modTime is still the one
+// recorded. Mtimes are compared to the second, the precision of SFTP.
+func (r Record) Matches(size int64, modTime time.Time) bool {
+	return r.Size == size && r.ModTime.Unix() == modTime.Unix()
+}
+
+var ledgerBucket = []byte("ledger")
+
+// Ledger stores records in a bbolt database. A nil Ledger remembers
+// nothing.
+type Ledger struct {
+	db *bolt.DB
+}
+
+// New uses db, e.g. cache.DirectoryCache.DB.
+func New(db *bolt.DB) *Ledger { return &Ledger{db: db} }
+
+// Get returns the record of file on host, if any.
+func (l *Ledger) Get(host, file string) (Record, bool, error) {
+	var rec Record
+	if l == nil {
+		return rec, false, nil
+	}
+	var rb []byte
+	err := l.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(ledgerBucket); b != nil {
+			if hb := b.Bucket([]byte(host)); hb != nil {
+				rb = hb.Get([]byte(file))
+			}
+		}
+		return nil
+	})
+	if err != nil || rb == nil {
+		return rec, false, err
+	}
+	return rec, true, json.Unmarshal(rb, &rec)
+}
+
+// Put records file on host, replacing any earlier record.
+func (l *Ledger) Put(host, file string, rec Record) error {
+	if l == nil {
+		return nil
+	}
+	if rec.Optimized.IsZero() {
+		rec.Optimized = time.Now()
+	}
+	rb, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return l.db.Batch(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(ledgerBucket)
+		if err != nil {
+			return err
+		}
+		hb, err := b.CreateBucketIfNotExists([]byte(host))
+		if err != nil {
+			return err
+		}
+		return hb.Put([]byte(file), rb)
+	})
+}
+
+// Unchanged reports whether file on host was optimized before and still has
+// the recorded size and mtime. Lookup errors count as changed.
+func (l *Ledger) Unchanged(host, file string, size int64, modTime time.Time) bool {
+	rec, ok, err := l.Get(host, file)
+	return err == nil && ok && rec.Matches(size, modTime)
+}
+
+// LocalUnchanged reports whether output was written from input before and
+// neither changed since.
+func (l *Ledger) LocalUnchanged(input, output string) bool {
+	if l == nil {
+		return false
+	}
+	in, out, err := absPaths(input, output)
+	if err != nil {
+		return false
+	}
+	rec, ok, err := l.Get(LocalHost, out)
+	if err != nil || !ok {
+		return false
+	}
+	if st, err := os.Stat(out); err != nil || !rec.Matches(st.Size(), st.ModTime()) {
+		return false
+	}
+	if in == out {
+		return rec.Source == ""
+	}
+	st, err := os.Stat(in)
+	return err == nil && rec.Source == in && rec.SourceSize == st.Size() && rec.SourceModTime.Unix() == st.ModTime().Unix()
+}
+
+// RecordLocal records output as optimized from input; src is input as it
+// was before optimizing (it may have been overwritten since).
+func (l *Ledger) RecordLocal(input string, src fs.FileInfo, output, reason string) error {
+	if l == nil {
+		return nil
+	}
+	in, out, err := absPaths(input, output)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	rec := Record{Size: st.Size(), ModTime: st.ModTime(), Hash: hex.EncodeToString(h.Sum(nil)), Reason: reason}
+	if in != out {
+		rec.Source, rec.SourceSize, rec.SourceModTime = in, src.Size(), src.ModTime()
+	}
+	return l.Put(LocalHost, out, rec)
+}
+
+func absPaths(input, output string) (string, string, error) {
+	in, err := filepath.Abs(input)
+	if err != nil {
+		return "", "", err
+	}
+	out, err := filepath.Abs(output)
+	return in, out, err
+}
@@ -0,0 +1,122 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Options configures the process logger.
+type Options struct {
+	Level   string    // debug|info|warn|error; empty = warn (debug when Verbose)
+	Verbose bool      // shorthand for Level "debug"
+	File    string    // optional JSON log file (appended)
+	Console io.Writer // human-readable text output; nil disables it (e.g. while a TUI owns the terminal)
+}
+
+// New builds a logger from opts. The returned close function releases the log
+// file, if any.
+func New(opts Options) (*slog.Logger, func() error, error) {
+	level := slog.LevelWarn
+	if opts.Verbose {
+		level = slog.LevelDebug
+	}
+	if opts.Level != "" {
+		l, err := ParseLevel(opts.Level)
+		if err != nil {
+			return nil, nil, err
+		}
+		level = l
+	}
+	hopts := &slog.HandlerOptions{Level: level}
+
+	handlers := []slog.Handler{}
+	closeFn := func() error { return nil }
+	if opts.Console != nil {
+		handlers = append(handlers, slog.NewTextHandler(opts.Console, hopts))
+	}
+	if opts.File != "" {
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open log file: %w", err)
+		}
+		handlers = append(handlers, slog.NewJSONHandler(f, hopts))
+		closeFn = f.Close
+	}
+	switch len(handlers) {
+	case 0:
+		return Discard(), closeFn, nil
+	case 1:
+		return slog.New(handlers[0]), closeFn, nil
+	}
+	return slog.New(fanout(handlers)), closeFn, nil
+}
+
+// ParseLevel parses a level name (debug, info, warn/warning, error).
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+}
+
+// Discard returns a logger that drops everything.
+func Discard() *slog.Logger { return slog.New(slog.DiscardHandler) }
+
+// OrDiscard returns l, or a discarding logger when l is nil. Library types use
+// it so a zero-value Logger field is safe.
+func OrDiscard(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return Discard()
+	}
+	return l
+}
+
+// fanout forwards records to several handlers.
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanout, len(f))
+	for i, h := range f {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	out := make(fanout, len(f))
+	for i, h := range f {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
@@ -0,0 +1,160 @@
+package optimizer
+
+import (
+	"image"
+	"image/color"
+)
+
+// reduceColorModel re-expresses img in the narrowest color model that still
+// represents its content: 16-bit images drop to 8 bits per channel when that
+// is lossless (or when params.Allow16To8 permits it) and opaque images whose
+// channels agree within params.GrayTolerance become single-channel gray.
+func reduceColorModel(img image.Image, params Params) image.Image {
+	img = reduceDepth(img, params.Allow16To8)
+	if params.GrayTolerance < 0 {
+		return img
+	}
+	if g, ok := toGray(img, params.GrayTolerance); ok {
+		return g
+	}
+	return img
+}
+
+// reduceDepth converts 16-bit images to their 8-bit counterparts. Unless force
+// is set the conversion only happens when every sample has identical high and
+// low bytes, i.e. when the image is really 8-bit content stored as 16-bit.
+func reduceDepth(img image.Image, force bool) image.Image {
+	switch src := img.(type) {
+	case *image.Gray16:
+		if !force && !lossless16(src.Pix, src.Stride, src.Rect.Dx(), src.Rect.Dy()) {
+			return img
+		}
+		dst := image.NewGray(src.Rect)
+		narrow16(dst.Pix, dst.Stride, src.Pix, src.Stride, src.Rect.Dx(), src.Rect.Dy())
+		return dst
+	case *image.RGBA64:
+		if !force && !lossless16(src.Pix, src.Stride, src.Rect.Dx()*4, src.Rect.Dy()) {
+			return img
+		}
+		dst := image.NewRGBA(src.Rect)
+		narrow16(dst.Pix, dst.Stride, src.Pix, src.Stride, src.Rect.Dx()*4, src.Rect.Dy())
+		return dst
+	case *image.NRGBA64:
+		if !force && !lossless16(src.Pix, src.Stride, src.Rect.Dx()*4, src.Rect.Dy()) {
+			return img
+		}
+		dst := image.NewNRGBA(src.Rect)
+		narrow16(dst.Pix, dst.Stride, src.Pix, src.Stride, src.Rect.Dx()*4, src.Rect.Dy())
+		return dst
+	}
+	return img
+}
+
+// lossless16 reports whether every big-endian 16-bit sample in pix has equal
+// high and low bytes, so that keeping only the high byte loses nothing.
+// samples is the number of samples per row.
+func lossless16(pix []byte, stride int, samples, rows int) bool {
+	for y := 0; y < rows; y++ {
+		row := pix[y*stride : y*stride+samples*2]
+		for i := 0; i < len(row); i += 2 {
+			if row[i] != row[i+1] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// narrow16 copies the high byte of each 16-bit sample of src into dst.
+// samples is the number of samples per row.
+func narrow16(dst []byte, dstStride int, src []byte, srcStride int, samples, rows int) {
+	for y := 0; y < rows; y++ {
+		d := dst[y*dstStride : y*dstStride+samples]
+		s := src[y*srcStride : y*srcStride+samples*2]
+		for i := range d {
+			d[i] = s[i*2]
+		}
+	}
+}
+
+// toGray converts img to *image.Gray when it is opaque and every pixel's
+// channels differ by at most tol (0-255). Images that are already gray or are
+// palette based are left alone.
+func toGray(img image.Image, tol int) (*image.Gray, bool) {
+	b := img.Bounds()
+	switch src := img.(type) {
+	case *image.Gray, *image.Gray16, *image.Paletted:
+		return nil, false
+	case *image.YCbCr:
+		// Gray content has neutral chroma; luma is then the gray value.
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				ci := src.COffset(x, y)
+				if absDiff(src.Cb[ci], 128) > tol || absDiff(src.Cr[ci], 128) > tol {
+					return nil, false
+				}
+			}
+		}
+		dst := image.NewGray(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			yi := src.YOffset(b.Min.X, y)
+			copy(dst.Pix[(y-b.Min.Y)*dst.Stride:], src.Y[yi:yi+b.Dx()])
+		}
+		return dst, true
+	case *image.RGBA:
+		return grayFromPix(src.Pix, src.Stride, b, tol)
+	case *image.NRGBA:
+		return grayFromPix(src.Pix, src.Stride, b, tol)
+	}
+
+	dst := image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			if a != 0xffff {
+				return nil, false
+			}
+			r8, g8, b8 := uint8(r>>8), uint8(g>>8), uint8(bl>>8)
+			if spread(r8, g8, b8) > tol {
+				return nil, false
+			}
+			dst.SetGray(x, y, color.GrayModel.Convert(color.RGBA64{uint16(r), uint16(g), uint16(bl), 0xffff}).(color.Gray))
+		}
+	}
+	return dst, true
+}
+
+// grayFromPix handles the 4-byte RGBA/NRGBA layouts. With full opacity both
+// layouts hold the same values, so one code path serves them.
+func grayFromPix(pix []byte, stride int, b image.Rectangle, tol int) (*image.Gray, bool) {
+	for y := 0; y < b.Dy(); y++ {
+		row := pix[y*stride : y*stride+b.Dx()*4]
+		for i := 0; i < len(row); i += 4 {
+			if row[i+3] != 0xff || spread(row[i], row[i+1], row[i+2]) > tol {
+				return nil, false
+			}
+		}
+	}
+	dst := image.NewGray(b)
+	for y := 0; y < b.Dy(); y++ {
+		row := pix[y*stride : y*stride+b.Dx()*4]
+		out := dst.Pix[y*dst.Stride : y*dst.Stride+b.Dx()]
+		for x := range out {
+			r, g, bl := uint32(row[x*4]), uint32(row[x*4+1]), uint32(row[x*4+2])
+			// Same weights as color.GrayModel.
+			out[x] = uint8((19595*r + 38470*g + 7471*bl + 1<<15) >> 16)
+		}
+	}
+	return dst, true
+}
+
+func spread(r, g, b uint8) int {
+	return max(absDiff(r, g), absDiff(g, b), absDiff(r, b))
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
@@ -1,44 +1,92 @@
 package optimizer
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"golang.org/x/image/draw"
+
+	"github.com/juparave/photoptim/internal/imageinfo"
+	"github.com/juparave/photoptim/internal/logging"
+	"github.com/juparave/photoptim/internal/similarity"
 )
 
 // Optimizer interface (remote pipeline usage) - operates on in-memory bytes.
+// Implementations stop work once ctx is done and report a per-image timeout
+// (Params.Timeout) with reason "deadline-exceeded".
 type Optimizer interface {
-	OptimizeBytes(data []byte, format string, params Params) (out []byte, res Result, err error)
+	OptimizeBytes(ctx context.Context, data []byte, format string, params Params) (out []byte, res Result, err error)
+}
+
+// StreamOptimizer reads the source from r and writes the encoded output to w,
+// so callers never hold the whole source in memory.
+type StreamOptimizer interface {
+	OptimizeStream(ctx context.Context, r io.Reader, w io.Writer, format string, params Params) (res Result, err error)
 }
 
 // Params holds format-specific optimization parameters.
 type Params struct {
-	JPEGQuality int
-	MaxWidth    int // 0 = no width limit
-	MaxHeight   int // 0 = no height limit
+	JPEGQuality   int
+	MaxWidth      int           // 0 = no width limit
+	MaxHeight     int           // 0 = no height limit
+	GrayTolerance int           // max channel spread (0-255) still encoded as gray; <0 disables gray detection
+	Allow16To8    bool          // reduce 16-bit images to 8-bit even when the reduction is lossy
+	Score         bool          // compute PSNR/SSIM against the source; decodes the output once more
+	SkipGainCheck bool          // always emit the encoding, even when it is not smaller than the source
+	ForceReencode bool          // re-encode JPEGs even when their estimated quality is already at or below JPEGQuality
+	Timeout       time.Duration // per-image deadline; 0 = none
 }
 
 // Result describes optimization outcome.
 type Result struct {
 	OriginalSize  int64
-	OptimizedSize int64
+	OptimizedSize int64 // equal to OriginalSize when the source is kept
 	Duration      time.Duration
 	Skipped       bool
 	Reason        string
+
+	SourceWidth  int
+	SourceHeight int
+	Width        int // output width
+	Height       int // output height
+	InputFormat  string
+	OutputFormat string
+	Quality      int  // effective JPEG quality; 0 for lossless formats
+	Resized      bool // resize limits changed the dimensions
+	// MetadataKept reports whether the file left in place has the source's
+	// EXIF and ICC profile: the source was kept, or only stripped of
+	// non-essential segments. Re-encoded output never has them.
+	MetadataKept bool
+	PSNR         float64 // with Params.Score
+	SSIM         float64 // with Params.Score
+}
+
+// KeptOriginal reports whether the source should be kept as is: re-encoding
+// would not shrink it, or it is a JPEG already at or below the target quality.
+func (r Result) KeptOriginal() bool {
+	return r.Skipped && (r.Reason == "no-compression-gain" || r.Reason == "already-optimized")
 }
 
 // ImageOptimizer represents an image optimization tool (implements both legacy file API and new interface).
 type ImageOptimizer struct {
-	Quality int
+	Quality       int
+	GrayTolerance int
+	Allow16To8    bool
+	ForceReencode bool
+	Timeout       time.Duration
+	Logger        *slog.Logger // per-phase timings at debug level; nil = silent
 }
 
 // New creates a new ImageOptimizer with default settings
@@ -83,91 +131,322 @@ func resizeImage(img image.Image, maxWidth, maxHeight int) image.Image {
 }
 
 // OptimizeBytes implements Optimizer interface.
-func (o *ImageOptimizer) OptimizeBytes(data []byte, format string, params Params) ([]byte, Result, error) {
+func (o *ImageOptimizer) OptimizeBytes(ctx context.Context, data []byte, format string, params Params) ([]byte, Result, error) {
+	buf := &bytes.Buffer{}
+	r, err := o.OptimizeStream(ctx, bytes.NewReader(data), buf, format, params)
+	if r.KeptOriginal() {
+		return data, r, nil
+	}
+	if err != nil {
+		return nil, r, err
+	}
+	return buf.Bytes(), r, nil
+}
+
+// OptimizeStream implements StreamOptimizer. The source is decoded straight
+// from r; only the encoded output is buffered, and only while the
+// no-compression-gain check needs it (capped at the source size). When
+// re-encoding does not beat the source nothing is written to w and the result
+// is skipped with reason "no-compression-gain".
+//
+// JPEG sources whose quantization tables put them at or below
+// params.JPEGQuality are not re-encoded, which would only add generation
+// loss: non-essential metadata is stripped losslessly (reason
+// "metadata-stripped") or, if there is none, the source is skipped with
+// reason "already-optimized". params.ForceReencode disables this, as does a
+// resize that applies to the source.
+//
+// Cancellation is checked while reading the source, between decode, resize
+// and color reduction, and while encoding. A cancelled run fails with reason
+// "canceled", a missed deadline with reason "deadline-exceeded".
+func (o *ImageOptimizer) OptimizeStream(ctx context.Context, r io.Reader, w io.Writer, format string, params Params) (res Result, err error) {
 	start := time.Now()
-	r := Result{OriginalSize: int64(len(data))}
 	if params.JPEGQuality <= 0 {
 		params.JPEGQuality = o.Quality
 	}
+	if params.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, params.Timeout)
+		defer cancel()
+	}
+	defer func() {
+		// Decoders and encoders don't always wrap the reader/writer error, so
+		// attribute any failure after ctx ended to the context.
+		if err != nil && ctx.Err() != nil {
+			res.Skipped = false
+			res.Reason = ctxReason(ctx.Err())
+			res.Duration = time.Since(start)
+			err = fmt.Errorf("%s: %w", res.Reason, ctx.Err())
+		}
+	}()
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	log := o.log()
+	phase := time.Now()
+	lap := func(name string) {
+		log.Debug("optimize phase", "phase", name, "duration", time.Since(phase))
+		phase = time.Now()
+	}
+
+	// JPEG headers are parsed ahead of the decoder; the bytes consumed are
+	// replayed from head.
+	src := &countingReader{r: ctxReader{ctx: ctx, r: r}}
+	br := bufio.NewReaderSize(src, 64<<10)
+	var body io.Reader = br
+	if sig, _ := br.Peek(2); bytes.Equal(sig, []byte{0xFF, 0xD8}) {
+		head := &bytes.Buffer{}
+		info, perr := imageinfo.ParseJPEG(io.TeeReader(br, head))
+		if perr != nil {
+			log.Debug("jpeg header not parsed", "err", perr)
+		} else if q, _, ok := info.EstimateQuality(); ok && q <= params.JPEGQuality && !params.ForceReencode && isJPEG(format) && !exceedsLimits(info.Width, info.Height, params) {
+			return o.keepJPEG(ctx, info, q, head.Bytes(), br, src, w, res, start)
+		}
+		body = io.MultiReader(head, br)
+	}
 
 	// Decode
-	img, decodeFormat, err := image.Decode(bytes.NewReader(data))
+	img, decodeFormat, err := image.Decode(body)
 	if err != nil {
-		r.Skipped = true
-		r.Reason = "decode-error"
-		return nil, r, fmt.Errorf("decode: %w", err)
+		res.Skipped = true
+		res.Reason = "decode-error"
+		return res, fmt.Errorf("decode: %w", err)
 	}
+	// Drain trailing bytes so OriginalSize covers the whole file.
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return res, fmt.Errorf("read: %w", err)
+	}
+	res.OriginalSize = src.n
 	if format == "" {
 		format = decodeFormat
 	}
+	res.InputFormat = decodeFormat
+	res.SourceWidth, res.SourceHeight = img.Bounds().Dx(), img.Bounds().Dy()
+	lap("decode")
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
 
 	// Resize if dimensions are specified
 	if params.MaxWidth > 0 || params.MaxHeight > 0 {
 		img = resizeImage(img, params.MaxWidth, params.MaxHeight)
+		lap("resize")
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
 	}
-	buf := &bytes.Buffer{}
+	res.Width, res.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	res.Resized = res.Width != res.SourceWidth || res.Height != res.SourceHeight
+	ref := img
+	img = reduceColorModel(img, params)
+	lap("color-reduce")
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+
+	var encode func(io.Writer) error
 	switch strings.ToLower(format) {
 	case "jpeg", "jpg":
-		if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: params.JPEGQuality}); err != nil {
-			return nil, r, err
+		res.OutputFormat = "jpeg"
+		res.Quality = params.JPEGQuality
+		encode = func(w io.Writer) error {
+			return jpeg.Encode(ctxWriter{ctx: ctx, w: w}, img, &jpeg.Options{Quality: params.JPEGQuality})
 		}
 	case "png":
-		if err := png.Encode(buf, img); err != nil {
-			return nil, r, err
-		}
+		res.OutputFormat = "png"
+		encode = func(w io.Writer) error { return png.Encode(ctxWriter{ctx: ctx, w: w}, img) }
 	default:
-		r.Skipped = true
-		r.Reason = "unsupported-format"
-		return nil, r, fmt.Errorf("unsupported format: %s", format)
-	}
-	out := buf.Bytes()
-	r.OptimizedSize = int64(len(out))
-	r.Duration = time.Since(start)
-
-	// Check if optimized version is actually smaller
-	if r.OptimizedSize >= r.OriginalSize && params.MaxWidth == 0 && params.MaxHeight == 0 {
-		r.Skipped = true
-		r.Reason = "no-compression-gain"
-		return data, r, nil
+		res.Skipped = true
+		res.Reason = "unsupported-format"
+		return res, fmt.Errorf("unsupported format: %s", format)
 	}
 
-	return out, r, nil
-}
+	// Resized output is committed regardless of size, so it can go straight to
+	// w; otherwise buffer it until we know it beats the source.
+	if params.MaxWidth > 0 || params.MaxHeight > 0 || params.SkipGainCheck {
+		cw := &countingWriter{w: w}
+		var dst io.Writer = cw
+		var kept *bytes.Buffer
+		if params.Score {
+			kept = &bytes.Buffer{}
+			dst = io.MultiWriter(cw, kept)
+		}
+		if err := encode(dst); err != nil {
+			return res, err
+		}
+		lap("encode")
+		res.OptimizedSize = cw.n
+		if kept != nil && ctx.Err() == nil {
+			scoreOutput(&res, ref, kept.Bytes())
+			lap("score")
+		}
+		res.Duration = time.Since(start)
+		return res, nil
+	}
 
-// Optimize (legacy) takes an input image path and optimizes it to outputPath.
-func (o *ImageOptimizer) Optimize(inputPath, outputPath string) error {
-	file, err := os.Open(inputPath)
+	lb := &limitedBuffer{limit: res.OriginalSize}
+	err = encode(lb)
+	lap("encode")
 	if err != nil {
-		return fmt.Errorf("open input: %w", err)
+		if !errors.Is(err, errNoGain) {
+			return res, err
+		}
+		// The encoding was abandoned once it reached the source size; the
+		// source stays, so that is the size to report.
+		res.OptimizedSize = res.OriginalSize
+		res.Skipped = true
+		res.Reason = "no-compression-gain"
+		res.MetadataKept = true
+		if params.Score {
+			res.PSNR, res.SSIM = similarity.MaxPSNR, 1
+		}
+		res.Duration = time.Since(start)
+		return res, nil
 	}
-	defer file.Close()
-	data, err := io.ReadAll(file)
+	res.OptimizedSize = lb.n
+	if params.Score {
+		scoreOutput(&res, ref, lb.Bytes())
+		lap("score")
+	}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	if _, err := w.Write(lb.Bytes()); err != nil {
+		return res, err
+	}
+	res.Duration = time.Since(start)
+	return res, nil
+}
+
+// keepJPEG finishes a JPEG source that is already at or below the target
+// quality without re-encoding it. rest is positioned right after head.
+func (o *ImageOptimizer) keepJPEG(ctx context.Context, info *imageinfo.JPEG, quality int, head []byte, rest io.Reader, src *countingReader, w io.Writer, res Result, start time.Time) (Result, error) {
+	res.InputFormat, res.OutputFormat = "jpeg", "jpeg"
+	res.SourceWidth, res.SourceHeight = info.Width, info.Height
+	res.Width, res.Height = info.Width, info.Height
+	res.Quality = quality
+	res.PSNR, res.SSIM = similarity.MaxPSNR, 1
+	stripped, removed := info.StripMetadata(head)
+	if removed == 0 {
+		if _, err := io.Copy(io.Discard, rest); err != nil {
+			return res, fmt.Errorf("read: %w", err)
+		}
+		res.OriginalSize = src.n
+		res.OptimizedSize = src.n
+		res.Skipped = true
+		res.Reason = "already-optimized"
+		res.MetadataKept = true
+		res.Duration = time.Since(start)
+		o.log().Debug("jpeg already optimized", "quality", quality)
+		return res, nil
+	}
+	cw := &countingWriter{w: ctxWriter{ctx: ctx, w: w}}
+	if _, err := cw.Write(stripped); err != nil {
+		return res, err
+	}
+	if _, err := io.Copy(cw, rest); err != nil {
+		return res, err
+	}
+	res.OriginalSize = src.n
+	res.OptimizedSize = cw.n
+	res.Reason = "metadata-stripped"
+	res.MetadataKept = true
+	res.Duration = time.Since(start)
+	o.log().Debug("jpeg already optimized, stripped metadata", "quality", quality, "removed", removed)
+	return res, nil
+}
+
+// exceedsLimits reports whether a w×h source would be resized by params.
+func exceedsLimits(w, h int, params Params) bool {
+	return (params.MaxWidth > 0 && w > params.MaxWidth) || (params.MaxHeight > 0 && h > params.MaxHeight)
+}
+
+// isJPEG reports whether format (a file extension or "" for auto-detection)
+// would produce JPEG output for a JPEG source.
+func isJPEG(format string) bool {
+	switch strings.ToLower(format) {
+	case "", "jpg", "jpeg":
+		return true
+	}
+	return false
+}
+
+// Verify fully decodes an encoded image, as a last check before it replaces
+// its source.
+func Verify(data []byte) error {
+	if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	return nil
+}
+
+// scoreOutput fills the PSNR/SSIM fields by comparing the encoded output with
+// the image it was encoded from. Scoring failures leave the fields zero.
+func scoreOutput(r *Result, ref image.Image, out []byte) {
+	dec, _, err := image.Decode(bytes.NewReader(out))
 	if err != nil {
-		return fmt.Errorf("read input: %w", err)
+		return
 	}
-	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(inputPath)), ".")
-	out, res, err := o.OptimizeBytes(data, ext, Params{JPEGQuality: o.Quality})
+	if s, err := similarity.Compare(ref, dec); err == nil {
+		r.PSNR, r.SSIM = s.PSNR, s.SSIM
+	}
+}
+
+// Optimize (legacy) takes an input image path and optimizes it to outputPath.
+// Reporting is left to the caller via the returned Result.
+func (o *ImageOptimizer) Optimize(inputPath, outputPath string) (Result, error) {
+	data, out, res, err := o.optimizeFile(inputPath)
 	if err != nil && !res.Skipped {
-		return err
+		return res, err
 	}
 
-	if res.Reason == "no-compression-gain" {
-		fmt.Printf("Skipped %s: no compression gain (original is smaller or equal)\n", filepath.Base(inputPath))
+	if res.KeptOriginal() {
+		o.log().Info("keeping original", "path", inputPath, "reason", res.Reason, "size", res.OriginalSize)
 		// If input and output are different, copy original to output?
 		// For now, let's assume we skip if it's the same file.
 		if inputPath == outputPath {
-			return nil
+			return res, nil
 		}
-		return os.WriteFile(outputPath, data, 0o644)
+		return res, os.WriteFile(outputPath, data, 0o644)
 	}
 
 	if res.Skipped {
-		return fmt.Errorf("skipped: %s", res.Reason)
+		return res, fmt.Errorf("skipped: %s", res.Reason)
 	}
 
 	if err := os.WriteFile(outputPath, out, 0o644); err != nil {
-		return fmt.Errorf("write output: %w", err)
+		return res, fmt.Errorf("write output: %w", err)
 	}
-	fmt.Printf("Optimized %s (%d bytes) -> %s (%d bytes)\n", filepath.Base(inputPath), res.OriginalSize, filepath.Base(outputPath), res.OptimizedSize)
-	return nil
+	o.log().Info("optimized", "input", inputPath, "output", outputPath, "originalSize", res.OriginalSize, "optimizedSize", res.OptimizedSize)
+	return res, nil
 }
+
+// Estimate optimizes inputPath in memory only and returns the result
+// Optimize would report, without writing anything. Skipped results are not
+// errors.
+func (o *ImageOptimizer) Estimate(inputPath string) (Result, error) {
+	_, _, res, err := o.optimizeFile(inputPath)
+	if res.Skipped {
+		err = nil
+	}
+	return res, err
+}
+
+// optimizeFile reads inputPath and optimizes it with o's settings,
+// returning the source and the encoding.
+func (o *ImageOptimizer) optimizeFile(inputPath string) (data, out []byte, res Result, err error) {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return nil, nil, Result{}, fmt.Errorf("open input: %w", err)
+	}
+	defer file.Close()
+	data, err = io.ReadAll(file)
+	if err != nil {
+		return nil, nil, Result{}, fmt.Errorf("read input: %w", err)
+	}
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(inputPath)), ".")
+	out, res, err = o.OptimizeBytes(context.Background(), data, ext, Params{JPEGQuality: o.Quality, GrayTolerance: o.GrayTolerance, Allow16To8: o.Allow16To8, ForceReencode: o.ForceReencode, Timeout: o.Timeout})
+	return data, out, res, err
+}
+
+func (o *ImageOptimizer) log() *slog.Logger { return logging.OrDiscard(o.Logger) }
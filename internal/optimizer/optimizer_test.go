@@ -0,0 +1,204 @@
+package optimizer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decodePNG(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return img
+}
+
+func TestOptimizeBytesGrayRGB(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(x * 4)
+			img.Set(x, y, color.RGBA{v, v + 1, v, 255})
+		}
+	}
+	data := encodePNG(t, img)
+
+	out, res, err := New().OptimizeBytes(context.Background(), data, "png", Params{GrayTolerance: 1})
+	if err != nil || res.Skipped {
+		t.Fatalf("optimize: err=%v res=%+v", err, res)
+	}
+	if _, ok := decodePNG(t, out).(*image.Gray); !ok {
+		t.Fatalf("expected gray output, got %T", decodePNG(t, out))
+	}
+
+	// Exact matching must reject the 1-level spread.
+	out, _, _ = New().OptimizeBytes(context.Background(), data, "png", Params{})
+	if _, ok := decodePNG(t, out).(*image.Gray); ok {
+		t.Fatalf("tolerance 0 should keep color output")
+	}
+}
+
+func TestOptimizeBytes16Bit(t *testing.T) {
+	lossless := image.NewNRGBA64(image.Rect(0, 0, 32, 32))
+	lossy := image.NewNRGBA64(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			v := uint16(x*8) * 0x101
+			lossless.Set(x, y, color.NRGBA64{v, 0, v, 0xffff})
+			lossy.Set(x, y, color.NRGBA64{v + 1, 0, v, 0xffff})
+		}
+	}
+
+	out, _, err := New().OptimizeBytes(context.Background(), encodePNG(t, lossless), "png", Params{})
+	if err != nil {
+		t.Fatalf("optimize: %v", err)
+	}
+	if _, ok := decodePNG(t, out).(*image.RGBA); !ok {
+		t.Fatalf("lossless 16-bit should become 8-bit, got %T", decodePNG(t, out))
+	}
+
+	out, _, err = New().OptimizeBytes(context.Background(), encodePNG(t, lossy), "png", Params{})
+	if err != nil {
+		t.Fatalf("optimize: %v", err)
+	}
+	if _, ok := decodePNG(t, out).(*image.RGBA); ok {
+		t.Fatalf("lossy 16-bit must keep its depth without Allow16To8")
+	}
+	out, _, err = New().OptimizeBytes(context.Background(), encodePNG(t, lossy), "png", Params{Allow16To8: true})
+	if err != nil {
+		t.Fatalf("optimize: %v", err)
+	}
+	if _, ok := decodePNG(t, out).(*image.RGBA); !ok {
+		t.Fatalf("Allow16To8 should force 8-bit, got %T", decodePNG(t, out))
+	}
+}
+
+func TestOptimizeBytesResultDetails(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y * 2), uint8(x + y), 255})
+		}
+	}
+	_, res, err := New().OptimizeBytes(context.Background(), encodePNG(t, img), "jpeg", Params{JPEGQuality: 70, MaxWidth: 100, Score: true})
+	if err != nil {
+		t.Fatalf("optimize: %v", err)
+	}
+	if res.SourceWidth != 200 || res.SourceHeight != 100 || res.Width != 100 || res.Height != 50 || !res.Resized {
+		t.Fatalf("unexpected dimensions: %+v", res)
+	}
+	if res.InputFormat != "png" || res.OutputFormat != "jpeg" || res.Quality != 70 {
+		t.Fatalf("unexpected formats/quality: %+v", res)
+	}
+	if res.SSIM <= 0.5 || res.SSIM > 1 || res.PSNR < 20 {
+		t.Fatalf("implausible scores: ssim=%f psnr=%f", res.SSIM, res.PSNR)
+	}
+	if res.MetadataKept {
+		t.Fatalf("re-encoded output cannot keep metadata")
+	}
+
+	// Scoring is opt-in.
+	_, res, err = New().OptimizeBytes(context.Background(), encodePNG(t, img), "jpeg", Params{JPEGQuality: 70, MaxWidth: 100})
+	if err != nil || res.SSIM != 0 || res.PSNR != 0 {
+		t.Fatalf("scores without Params.Score: err=%v ssim=%f psnr=%f", err, res.SSIM, res.PSNR)
+	}
+}
+
+type failWriter struct{ t *testing.T }
+
+func (f failWriter) Write(p []byte) (int, error) {
+	f.t.Fatalf("unexpected write of %d bytes", len(p))
+	return 0, nil
+}
+
+func TestOptimizeStreamNoGainWritesNothing(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 16, 16))
+	data := encodePNG(t, img)
+
+	res, err := New().OptimizeStream(context.Background(), bytes.NewReader(data), failWriter{t}, "png", Params{})
+	if err != nil {
+		t.Fatalf("optimize: %v", err)
+	}
+	if !res.Skipped || res.Reason != "no-compression-gain" || res.OriginalSize != int64(len(data)) || !res.MetadataKept {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if res.OptimizedSize != res.OriginalSize {
+		t.Fatalf("no-gain file reports %d bytes, want its original %d", res.OptimizedSize, res.OriginalSize)
+	}
+
+	// The same file through the buffered API reports the size it is left at.
+	out, res, err := New().OptimizeBytes(context.Background(), data, "png", Params{})
+	if err != nil || !res.KeptOriginal() || res.OptimizedSize != int64(len(data)) || !bytes.Equal(out, data) {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestOptimizeBytesDeadlineExceeded(t *testing.T) {
+	data := encodePNG(t, image.NewGray(image.Rect(0, 0, 16, 16)))
+	_, res, err := New().OptimizeBytes(context.Background(), data, "png", Params{Timeout: time.Nanosecond})
+	if !errors.Is(err, context.DeadlineExceeded) || res.Reason != "deadline-exceeded" || res.Skipped {
+		t.Fatalf("expected deadline-exceeded failure, got err=%v res=%+v", err, res)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, res, err = New().OptimizeBytes(ctx, data, "png", Params{})
+	if !errors.Is(err, context.Canceled) || res.Reason != "canceled" {
+		t.Fatalf("expected canceled failure, got err=%v res=%+v", err, res)
+	}
+}
+
+func TestOptimizeBytesAlreadyOptimizedJPEG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 13)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 60}); err != nil {
+		t.Fatal(err)
+	}
+	src := buf.Bytes()
+
+	out, res, err := New().OptimizeBytes(context.Background(), src, "jpg", Params{JPEGQuality: 75})
+	if err != nil || res.Reason != "already-optimized" || !res.KeptOriginal() || res.Quality != 60 {
+		t.Fatalf("want already-optimized at q60: err=%v res=%+v", err, res)
+	}
+	if !bytes.Equal(out, src) {
+		t.Fatalf("already-optimized source was modified")
+	}
+
+	// A comment segment is stripped losslessly; the scan data is untouched.
+	com := append([]byte{0xFF, 0xD8, 0xFF, 0xFE, 0x00, 0x07}, "hello"...)
+	withCOM := append(com, src[2:]...)
+	out, res, err = New().OptimizeBytes(context.Background(), withCOM, "jpg", Params{JPEGQuality: 75})
+	if err != nil || res.Reason != "metadata-stripped" || res.Skipped || !res.MetadataKept {
+		t.Fatalf("want metadata-stripped: err=%v res=%+v", err, res)
+	}
+	if !bytes.Equal(out, src) {
+		t.Fatalf("stripped output differs from the source without the comment")
+	}
+
+	// Lower targets and ForceReencode still re-encode.
+	for _, p := range []Params{{JPEGQuality: 40}, {JPEGQuality: 75, ForceReencode: true}} {
+		if _, res, err := New().OptimizeBytes(context.Background(), src, "jpg", p); err != nil || res.Reason == "already-optimized" {
+			t.Fatalf("%+v: expected re-encode, got err=%v res=%+v", p, err, res)
+		}
+	}
+}
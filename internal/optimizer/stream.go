@@ -0,0 +1,89 @@
+package optimizer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+)
+
+// errNoGain aborts an encode as soon as its output can no longer beat the source.
+var errNoGain = errors.New("output not smaller than source")
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// limitedBuffer buffers writes until their total would reach limit, at which
+// point it fails with errNoGain. n counts every byte offered, accepted or not.
+// The buffer is a field rather than embedded so encoders can't bypass Write
+// through bytes.Buffer's WriteString/WriteByte.
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit int64
+	n     int64
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	l.n += int64(len(p))
+	if l.n >= l.limit {
+		return 0, errNoGain
+	}
+	return l.buf.Write(p)
+}
+
+func (l *limitedBuffer) Bytes() []byte { return l.buf.Bytes() }
+
+// ctxReader fails reads once ctx is done, which aborts a decode in progress.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// ctxWriter fails writes once ctx is done, which aborts an encode in progress.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c ctxWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
+
+// ctxReason maps a context error to a Result reason.
+func ctxReason(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "deadline-exceeded"
+	}
+	return "canceled"
+}
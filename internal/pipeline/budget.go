@@ -0,0 +1,160 @@
+package pipeline
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/juparave/photoptim/internal/filter"
+)
+
+// memoryBudget admits files while the memory they are estimated to need
+// fits in limit. Waiters are admitted in arrival order, so a large file is
+// not starved by smaller ones behind it; a file needing more than the whole
+// budget is admitted once nothing else is in flight.
+type memoryBudget struct {
+	mu      sync.Mutex
+	limit   int64
+	used    int64
+	waiters list.List // of *budgetWaiter
+	queued  func()    // tests: called, unlocked, once a waiter is queued
+}
+
+type budgetWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	return &memoryBudget{limit: limit}
+}
+
+// clamp caps n at the whole budget.
+func (b *memoryBudget) clamp(n int64) int64 { return min(n, b.limit) }
+
+// tryAcquire takes n without waiting, if it fits and nobody is waiting.
+func (b *memoryBudget) tryAcquire(n int64) bool {
+	n = b.clamp(n)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.waiters.Len() == 0 && b.used+n <= b.limit {
+		b.used += n
+		return true
+	}
+	return false
+}
+
+// acquire waits until n fits, or ctx is done.
+func (b *memoryBudget) acquire(ctx context.Context, n int64) error {
+	n = b.clamp(n)
+	b.mu.Lock()
+	if b.waiters.Len() == 0 && b.used+n <= b.limit {
+		b.used += n
+		b.mu.Unlock()
+		return nil
+	}
+	w := &budgetWaiter{n: n, ready: make(chan struct{})}
+	elem := b.waiters.PushBack(w)
+	b.mu.Unlock()
+	if b.queued != nil {
+		b.queued()
+	}
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		select {
+		case <-w.ready:
+			// Admitted while giving up: hand it back.
+			b.used -= n
+		default:
+			b.waiters.Remove(elem)
+		}
+		b.admit()
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// release returns n, as passed to acquire.
+func (b *memoryBudget) release(n int64) {
+	b.mu.Lock()
+	b.used -= b.clamp(n)
+	b.admit()
+	b.mu.Unlock()
+}
+
+// admit wakes the waiters at the front that now fit. b.mu must be held.
+func (b *memoryBudget) admit() {
+	for e := b.waiters.Front(); e != nil; e = b.waiters.Front() {
+		w := e.Value.(*budgetWaiter)
+		if b.used+w.n > b.limit {
+			return
+		}
+		b.used += w.n
+		b.waiters.Remove(e)
+		close(w.ready)
+	}
+}
+
+// inUse returns the memory currently admitted.
+func (b *memoryBudget) inUse() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// unknownExpansion is how much larger than the file its decoded image is
+// assumed to be when the header cannot be read; photos compress about 10:1
+// against 3-byte RGB, and are decoded to 4-byte RGBA.
+const unknownExpansion = 16
+
+// memoryEstimate is the memory a file of size bytes and width×height
+// pixels is expected to hold at its peak: the decoded image at 4 bytes per
+// pixel, the encoded output, and the source, which is spooled to disk but
+// read back into memory to be backed up. Unknown dimensions
+// are estimated from size. Scoring the output would need a second decode and
+// about 64 bytes per pixel more, which is why the pipeline never scores.
+func memoryEstimate(size int64, width, height int) int64 {
+	decoded := int64(width) * int64(height) * 4
+	if decoded == 0 {
+		decoded = size * unknownExpansion
+	}
+	return decoded + 2*size
+}
+
+// admit waits until f fits in the memory budget and returns the release
+// func; false if ctx ended first. The file is opened to read its image
+// header and estimate the decoded size; it is kept open, with the header
+// bytes, for the download to go on from (see fileRun.open). Without a
+// budget it returns at once.
+func (o *Orchestrator) admit(ctx context.Context, f *fileRun, budget *memoryBudget) (func(), bool) {
+	if budget == nil {
+		return func() {}, true
+	}
+	e := f.task.Entry
+	var w, h int
+	if rc, opened, err := o.FS.Open(ctx, e.Path); err == nil {
+		var head bytes.Buffer
+		w, h, _ = filter.Dimensions(io.TeeReader(rc, &head))
+		f.opened = &openedSource{rc: rc, entry: opened, head: head.Bytes()}
+	}
+	need := memoryEstimate(e.Size, w, h)
+	release := func() { budget.release(need) }
+	if budget.tryAcquire(need) {
+		return release, true
+	}
+	start := time.Now()
+	f.log.Debug("pipeline memory wait", "file", e.Path, "need", need, "in_use", budget.inUse(), "limit", budget.limit)
+	if err := budget.acquire(ctx, need); err != nil {
+		f.closeOpened()
+		return nil, false
+	}
+	f.log.Debug("pipeline memory admitted", "file", e.Path, "need", need, "waited", time.Since(start))
+	f.phaseStart = time.Now()
+	return release, true
+}
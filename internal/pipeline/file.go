@@ -0,0 +1,159 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/juparave/photoptim/internal/journal"
+	"github.com/juparave/photoptim/internal/optimizer"
+	"github.com/juparave/photoptim/internal/remotefs"
+)
+
+// fileRun carries the per-file state of one task through its phases.
+type fileRun struct {
+	id         int
+	task       FileTask
+	prog       chan<- ProgressEvent
+	log        *slog.Logger
+	journal    *journal.Journal // nil = not journaled
+	phaseStart time.Time
+
+	opened  *openedSource        // opened by admit, not yet downloaded
+	entry   remotefs.RemoteEntry // the source as opened
+	spool   *os.File             // the downloaded source; kept past optimizing only for backups
+	srcHash string               // hex SHA-256 of the source
+	out     []byte               // the encoding to upload
+	release func()               // returns the file's share of the memory budget
+
+	started time.Time
+	result  optimizer.Result // from the optimize phase
+	retries int
+	phases  map[Phase]time.Duration
+	finish  func(*Outcome) // called with the outcome before the last event is sent
+}
+
+// openedSource is a source file already opened, whose first bytes, head,
+// were read.
+type openedSource struct {
+	rc    io.ReadCloser
+	entry remotefs.RemoteEntry
+	head  []byte
+}
+
+// open opens f's source for downloading: the first time, the one admit
+// opened, read again from its start; then anew from fsys.
+func (f *fileRun) open(ctx context.Context, fsys remotefs.RemoteFS) (io.ReadCloser, remotefs.RemoteEntry, error) {
+	if o := f.opened; o != nil {
+		f.opened = nil
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(o.head), o.rc), o.rc}, o.entry, nil
+	}
+	return fsys.Open(ctx, f.task.Entry.Path)
+}
+
+// closeOpened closes the source admit opened, if it was not downloaded.
+func (f *fileRun) closeOpened() {
+	if f.opened != nil {
+		f.opened.rc.Close()
+		f.opened = nil
+	}
+}
+
+// source reads the spooled source back, e.g. to back it up.
+func (f *fileRun) source() ([]byte, error) {
+	if _, err := f.spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(f.spool)
+}
+
+// dropSource removes the spooled source, if any.
+func (f *fileRun) dropSource() {
+	if f.spool != nil {
+		f.spool.Close()
+		os.Remove(f.spool.Name())
+		f.spool = nil
+	}
+}
+
+// emit stamps ev with the file's identity, logs finished phases (with their
+// duration; failures as warnings), attaches the outcome to the file's last
+// event and forwards the event.
+func (f *fileRun) emit(ev ProgressEvent) {
+	ev.FileID, ev.Name, ev.Timestamp = f.id, f.task.Entry.Name, time.Now()
+	if ev.Done {
+		attrs := []any{"file", f.task.Entry.Path, "phase", ev.Phase, "duration", ev.Timestamp.Sub(f.phaseStart), "bytes", ev.Bytes}
+		if ev.Reason != "" {
+			attrs = append(attrs, "reason", ev.Reason)
+		}
+		if ev.Err != nil {
+			f.log.Warn("pipeline phase failed", append(attrs, "err", ev.Err)...)
+		} else {
+			f.log.Debug("pipeline phase done", attrs...)
+		}
+		f.phases[ev.Phase] += ev.Timestamp.Sub(f.phaseStart)
+		f.retries += ev.Retries
+		if ev.Phase == PhaseOptimize {
+			f.result = ev.Result
+		}
+		f.phaseStart = ev.Timestamp
+		f.record(ev)
+		if oc := f.outcome(ev); oc != nil {
+			ev.Outcome = oc
+			f.finish(oc)
+		}
+	}
+	f.prog <- ev
+}
+
+// outcome returns the file's outcome if the Done event ev is its last, else
+// nil.
+func (f *fileRun) outcome(ev ProgressEvent) *Outcome {
+	oc := &Outcome{FileID: f.id, Entry: f.task.Entry, Result: f.result, Reason: f.result.Reason, Retries: f.retries, Phases: f.phases, Duration: ev.Timestamp.Sub(f.started)}
+	switch {
+	case ev.Err != nil && !(ev.Phase == PhaseOptimize && ev.Result.Skipped):
+		oc.Status, oc.Err, oc.Reason = StatusFailed, ev.Err, ev.Reason
+	case ev.Phase == PhaseOptimize && ev.Result.Skipped && !ev.Result.KeptOriginal():
+		oc.Status = StatusSkipped
+	case ev.Phase == PhaseUpload && f.result.KeptOriginal():
+		oc.Status = StatusKept
+	case ev.Phase == PhaseUpload:
+		oc.Status = StatusOptimized
+	default:
+		return nil
+	}
+	oc.OriginalSize = f.result.OriginalSize
+	if oc.OriginalSize == 0 {
+		oc.OriginalSize = f.task.Entry.Size
+	}
+	oc.OptimizedSize = oc.OriginalSize
+	if oc.Status == StatusOptimized {
+		oc.OptimizedSize = f.result.OptimizedSize
+	}
+	return oc
+}
+
+// record writes the state a Done event leaves the file in to the journal.
+func (f *fileRun) record(ev ProgressEvent) {
+	if f.journal == nil {
+		return
+	}
+	status := journal.StatusPending
+	switch {
+	case ev.Phase == PhaseOptimize && ev.Result.Skipped && !ev.Result.KeptOriginal():
+		status = journal.StatusSkipped
+	case ev.Err != nil:
+		status = journal.StatusFailed
+	case ev.Phase == PhaseUpload:
+		status = journal.StatusDone
+	}
+	if err := f.journal.Update(f.task.Entry.Path, string(ev.Phase), status, ev.Reason, ev.Err); err != nil {
+		f.log.Warn("journal update failed", "file", f.task.Entry.Path, "err", err)
+	}
+}
@@ -0,0 +1,235 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hook points.
+const (
+	HookPreRun   = "pre-run"
+	HookPostFile = "post-file"
+	HookPostRun  = "post-run"
+)
+
+// DefaultHookTimeout bounds hooks without a Timeout.
+const DefaultHookTimeout = 30 * time.Second
+
+// Hook is a local command or a Go callback run at a hook point.
+type Hook struct {
+	Name string // for reports; "" = Command
+	// Command is run with sh -c. It gets the event as PHOTOPTIM_*
+	// environment variables (see HookEvent.Env) and as JSON on stdin.
+	Command string
+	// Func is called instead of running Command. It must return once ctx
+	// is done, which is how Timeout applies to it: the run waits for it.
+	Func    func(ctx context.Context, ev HookEvent) error
+	Timeout time.Duration // 0 = DefaultHookTimeout
+	// Required makes a failure abort the run: a failed pre-run hook stops it
+	// before any file, a failed post-file hook cancels the files not yet
+	// done; the failure is then the Summary's Err.
+	Required bool
+}
+
+// Hooks are run by the Orchestrator, in order, at each point. Post-file
+// hooks run for one file at a time, in the order files finish, off the
+// stage workers (see postFileQueue); all are done before the post-run
+// hooks. Post-file and post-run hooks still run when the run is canceled.
+type Hooks struct {
+	PreRun   []Hook
+	PostFile []Hook
+	PostRun  []Hook
+}
+
+// HookFile describes a finished file to post-file hooks.
+type HookFile struct {
+	Path          string `json:"path"`
+	Status        Status `json:"status"`
+	Reason        string `json:"reason,omitempty"`
+	Error         string `json:"error,omitempty"`
+	OriginalSize  int64  `json:"originalSize"`
+	OptimizedSize int64  `json:"optimizedSize"`
+}
+
+// HookSummary describes a finished run to post-run hooks.
+type HookSummary struct {
+	Files        int           `json:"files"`
+	Optimized    int           `json:"optimized"`
+	Kept         int           `json:"kept"`
+	Skipped      int           `json:"skipped"`
+	Failed       int           `json:"failed"`
+	NotProcessed int           `json:"notProcessed"`
+	BytesSaved   int64         `json:"bytesSaved"`
+	Duration     time.Duration `json:"durationNs"`
+}
+
+// HookEvent is what a hook is run for.
+type HookEvent struct {
+	Hook    string       `json:"hook"`            // HookPreRun, HookPostFile or HookPostRun
+	DryRun  bool         `json:"dryRun"`          // nothing is written back
+	Files   int          `json:"files,omitempty"` // pre-run: files to process, if known
+	File    *HookFile    `json:"file,omitempty"`
+	Summary *HookSummary `json:"summary,omitempty"`
+}
+
+// Env returns ev as PHOTOPTIM_* environment variables.
+func (ev HookEvent) Env() []string {
+	env := []string{"PHOTOPTIM_HOOK=" + ev.Hook, "PHOTOPTIM_DRY_RUN=" + strconv.FormatBool(ev.DryRun)}
+	if ev.Hook == HookPreRun {
+		env = append(env, "PHOTOPTIM_FILES="+strconv.Itoa(ev.Files))
+	}
+	if f := ev.File; f != nil {
+		env = append(env,
+			"PHOTOPTIM_PATH="+f.Path,
+			"PHOTOPTIM_STATUS="+string(f.Status),
+			"PHOTOPTIM_REASON="+f.Reason,
+			"PHOTOPTIM_ERROR="+f.Error,
+			"PHOTOPTIM_ORIGINAL_SIZE="+strconv.FormatInt(f.OriginalSize, 10),
+			"PHOTOPTIM_OPTIMIZED_SIZE="+strconv.FormatInt(f.OptimizedSize, 10))
+	}
+	if s := ev.Summary; s != nil {
+		env = append(env,
+			"PHOTOPTIM_FILES="+strconv.Itoa(s.Files),
+			"PHOTOPTIM_OPTIMIZED="+strconv.Itoa(s.Optimized),
+			"PHOTOPTIM_KEPT="+strconv.Itoa(s.Kept),
+			"PHOTOPTIM_SKIPPED="+strconv.Itoa(s.Skipped),
+			"PHOTOPTIM_FAILED="+strconv.Itoa(s.Failed),
+			"PHOTOPTIM_NOT_PROCESSED="+strconv.Itoa(s.NotProcessed),
+			"PHOTOPTIM_BYTES_SAVED="+strconv.FormatInt(s.BytesSaved, 10),
+			"PHOTOPTIM_DURATION_MS="+strconv.FormatInt(s.Duration.Milliseconds(), 10))
+	}
+	return env
+}
+
+// HookError is a failed or timed out hook.
+type HookError struct {
+	Hook  string // hook point
+	Name  string
+	File  string // post-file hooks: the file's path
+	Err   error
+	Fatal bool // the hook was Required
+}
+
+func (e *HookError) Error() string {
+	s := e.Hook + " hook " + strconv.Quote(e.Name)
+	if e.File != "" {
+		s += " for " + e.File
+	}
+	return s + ": " + e.Err.Error()
+}
+
+func (e *HookError) Unwrap() error { return e.Err }
+
+// postFileQueue is how many finished files may wait for their post-file
+// hooks before the stage workers finishing more wait too.
+const postFileQueue = 256
+
+// maxHookOutput is how much of a failed command's output is kept in its
+// error.
+const maxHookOutput = 512
+
+// run runs h for ev within its timeout.
+func (h Hook) run(ctx context.Context, ev HookEvent) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var err error
+	if h.Func != nil {
+		err = h.Func(ctx, ev)
+	} else {
+		err = h.exec(ctx, ev)
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
+
+func (h Hook) exec(ctx context.Context, ev HookEvent) error {
+	in, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+	cmd.Env = append(os.Environ(), ev.Env()...)
+	cmd.Stdin = bytes.NewReader(in)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	// Do not wait for children that keep the output open.
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(out.String())
+		if len(msg) > maxHookOutput {
+			msg = "..." + msg[len(msg)-maxHookOutput:]
+		}
+		if msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+func (h Hook) name() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	return h.Command
+}
+
+// runHooks runs hooks for ev in order and returns their failures; it stops
+// at the first failure of a Required hook.
+func (o *Orchestrator) runHooks(ctx context.Context, hooks []Hook, ev HookEvent, file string) []*HookError {
+	var errs []*HookError
+	for _, h := range hooks {
+		start := time.Now()
+		err := h.run(ctx, ev)
+		attrs := []any{"hook", ev.Hook, "name", h.name(), "duration", time.Since(start)}
+		if file != "" {
+			attrs = append(attrs, "file", file)
+		}
+		if err == nil {
+			o.log().Debug("pipeline hook done", attrs...)
+			continue
+		}
+		o.log().Warn("pipeline hook failed", append(attrs, "err", err, "required", h.Required)...)
+		errs = append(errs, &HookError{Hook: ev.Hook, Name: h.name(), File: file, Err: err, Fatal: h.Required})
+		if h.Required {
+			break
+		}
+	}
+	return errs
+}
+
+// fatal returns the failure of a Required hook among errs.
+func fatal(errs []*HookError) error {
+	for _, e := range errs {
+		if e.Fatal {
+			return e
+		}
+	}
+	return nil
+}
+
+func hookFile(oc *Outcome) *HookFile {
+	f := &HookFile{Path: oc.Entry.Path, Status: oc.Status, Reason: oc.Reason, OriginalSize: oc.OriginalSize, OptimizedSize: oc.OptimizedSize}
+	if oc.Err != nil {
+		f.Error = oc.Err.Error()
+	}
+	return f
+}
+
+func hookSummary(s Summary) *HookSummary {
+	return &HookSummary{Files: s.Files, Optimized: s.Optimized, Kept: s.Kept, Skipped: s.Skipped, Failed: s.Failed, NotProcessed: s.NotProcessed, BytesSaved: s.BytesSaved, Duration: s.Duration}
+}
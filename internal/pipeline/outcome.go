@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/juparave/photoptim/internal/audit"
+	"github.com/juparave/photoptim/internal/metrics"
+	"github.com/juparave/photoptim/internal/optimizer"
+	"github.com/juparave/photoptim/internal/remotefs"
+)
+
+// Status is how a file ended up.
+type Status string
+
+const (
+	StatusOptimized Status = "optimized" // replaced by the optimized encoding (dry runs: would be)
+	StatusKept      Status = "kept"      // the original is as good as it gets
+	StatusSkipped   Status = "skipped"   // left alone, e.g. filtered or undecodable
+	StatusFailed    Status = "failed"
+)
+
+// Outcome is the final result of one file. It is sent on the file's last
+// event and collected in the run's Summary.
+type Outcome struct {
+	FileID        int
+	Entry         remotefs.RemoteEntry
+	Status        Status
+	Reason        string // optimizer reason, or the failure classification
+	Err           error
+	OriginalSize  int64
+	OptimizedSize int64 // equal to OriginalSize unless optimized
+	Result        optimizer.Result
+	Retries       int
+	Phases        map[Phase]time.Duration // time spent per finished phase
+	Duration      time.Duration
+}
+
+// Count adds o to m.
+func (o Outcome) Count(m *metrics.Metrics) {
+	switch o.Status {
+	case StatusOptimized:
+		m.Processed.Add(1)
+		m.BytesIn.Add(o.OriginalSize)
+		m.BytesOut.Add(o.OptimizedSize)
+		m.BytesSave.Add(o.OriginalSize - o.OptimizedSize)
+	case StatusFailed:
+		m.Failed.Add(1)
+	default:
+		m.Skipped.Add(1)
+	}
+}
+
+// AuditRecord describes o for the audit log.
+func (o Outcome) AuditRecord() audit.Record {
+	status := "skipped"
+	switch o.Status {
+	case StatusOptimized:
+		status = "success"
+	case StatusFailed:
+		status = "failed"
+	}
+	rec := audit.NewRecord(o.Entry.Path, status, o.Result)
+	rec.Retries = o.Retries
+	if o.Err != nil {
+		rec.Error = o.Err.Error()
+		rec.Reason = o.Reason
+		if rec.Reason == "" {
+			rec.Reason = rec.Error
+		}
+	}
+	return rec
+}
+
+// Summary totals a run.
+type Summary struct {
+	Files        int
+	Optimized    int
+	Kept         int
+	Skipped      int
+	Failed       int
+	NotProcessed int   // not started or not finished when the run was canceled
+	BytesIn      int64 // originals of optimized files
+	BytesOut     int64 // their optimized sizes
+	BytesSaved   int64
+	Duration     time.Duration
+	Outcomes     []*Outcome   // by FileID; nil for files not processed
+	HookErrors   []*HookError // hooks that failed or timed out, in order
+	Err          error        // the run could not be carried out, or a Required hook failed
+}
+
+func (s *Summary) add(o *Outcome) {
+	s.grow(o.FileID + 1)
+	s.Outcomes[o.FileID] = o
+	switch o.Status {
+	case StatusOptimized:
+		s.Optimized++
+		s.BytesIn += o.OriginalSize
+		s.BytesOut += o.OptimizedSize
+		s.BytesSaved += o.OriginalSize - o.OptimizedSize
+	case StatusKept:
+		s.Kept++
+	case StatusSkipped:
+		s.Skipped++
+	case StatusFailed:
+		s.Failed++
+	}
+}
+
+// grow makes room for n outcomes.
+func (s *Summary) grow(n int) {
+	for len(s.Outcomes) < n {
+		s.Outcomes = append(s.Outcomes, nil)
+	}
+}
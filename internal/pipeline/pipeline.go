@@ -1,13 +1,31 @@
 package pipeline
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"os"
+	"runtime"
 	"sync"
 	"time"
 
+	"github.com/juparave/photoptim/internal/audit"
+	"github.com/juparave/photoptim/internal/backup"
+	"github.com/juparave/photoptim/internal/filter"
+	"github.com/juparave/photoptim/internal/journal"
+	"github.com/juparave/photoptim/internal/ledger"
+	"github.com/juparave/photoptim/internal/logging"
+	"github.com/juparave/photoptim/internal/metrics"
 	"github.com/juparave/photoptim/internal/optimizer"
+	"github.com/juparave/photoptim/internal/progress"
 	"github.com/juparave/photoptim/internal/remotefs"
+	"github.com/juparave/photoptim/internal/retry"
+	"github.com/juparave/photoptim/internal/throttle"
 )
 
 type Phase string
@@ -18,7 +36,10 @@ const (
 	PhaseUpload   Phase = "upload"
 )
 
-// ProgressEvent describes streaming progress.
+// ProgressEvent describes streaming progress. Download and upload send
+// throttled in-flight events (Done false) while bytes move, then one Done
+// event per phase. A transient failure about to be retried is sent as an
+// in-flight event carrying Err; only a Done event with Err is final.
 type ProgressEvent struct {
 	FileID    int
 	Name      string
@@ -27,6 +48,10 @@ type ProgressEvent struct {
 	Total     int64
 	Done      bool
 	Err       error
+	Reason    string           // optimizer result reason, e.g. "no-compression-gain", "deadline-exceeded"; for failed transfers "retries-exhausted", "permanent-error" or "canceled"
+	Retries   int              // retries made in this phase so far
+	Result    optimizer.Result // full optimizer result; set on optimize-phase events
+	Outcome   *Outcome         // set on the file's last event
 	Timestamp time.Time
 }
 
@@ -35,87 +60,550 @@ type FileTask struct {
 	Entry remotefs.RemoteEntry
 }
 
-// Orchestrator manages pipeline execution (scaffold only).
+// Orchestrator runs FileTasks through the download, optimize and upload
+// stages.
 type Orchestrator struct {
-	FS            remotefs.RemoteFS
-	Opt           optimizer.Optimizer
-	Concurrency   int
-	JPEGQuality   int
+	FS  remotefs.RemoteFS
+	Opt optimizer.Optimizer
+	// Concurrency is the number of files downloaded at once; Encoders (0 =
+	// GOMAXPROCS) and Uploaders (0 = Concurrency) size the optimize and
+	// upload stages. Each stage is fed by a queue of QueueSize files (0 = as
+	// many as the stage has workers).
+	Concurrency int
+	Encoders    int
+	Uploaders   int
+	QueueSize   int
+	// MaxMemory bounds the memory, in bytes, of the files in flight, as
+	// estimated from their size and the dimensions in their header; files
+	// wait for admission on top of the Concurrency limit. 0 = unlimited.
+	MaxMemory   int64
+	JPEGQuality int
+	// TinyThreshold skips smaller files as "too-small"; 0 =
+	// filter.DefaultMinSize, negative = none.
 	TinyThreshold int64
+	// Filter selects the files to process; the others are skipped with
+	// their filter reason, without being downloaded (dimension rules only
+	// read the image header).
+	Filter       filter.Rules
+	ImageTimeout time.Duration // per-image optimization deadline; 0 = none
+	// ProgressInterval throttles in-flight (not Done) download/upload events
+	// per file; 0 = progress.DefaultInterval.
+	ProgressInterval time.Duration
+	// DryRun optimizes into memory only: nothing is uploaded, backed up or
+	// recorded in the ledger, and the upload phase finishes with reason
+	// "dry-run" and the size the upload would have had.
+	DryRun bool
+	// VerifyHash reads every upload back and compares its SHA-256 before it
+	// replaces the original; the size is always checked.
+	VerifyHash bool
+	// TouchModTime gives replaced files the time of upload as mtime, e.g. to
+	// bust caches, instead of keeping the original's; mode and owner are
+	// kept either way.
+	TouchModTime bool
+	// Backup, if set, receives every original before it is replaced; a file
+	// whose original cannot be saved is not replaced.
+	Backup backup.Store
+	// Journal, if set, records every finished phase and the file's status
+	// (see journal.Status) so an interrupted run can be resumed.
+	Journal *journal.Journal
+	// Ledger, if set, remembers every file replaced or kept under LedgerHost;
+	// files unchanged since are skipped with reason "previously-optimized"
+	// without being downloaded.
+	Ledger     *ledger.Ledger
+	LedgerHost string // see ledger.Host
+	Rescan     bool   // process files the ledger has as unchanged, still recording them
+	// Throttle, if set, limits the download and upload rates, shared by all
+	// workers.
+	Throttle *throttle.Limiter
+	// Retry governs retries of downloads and uploads that fail transiently
+	// (see remotefs.IsTransient); zero = retry.Default.
+	Retry retry.Policy
+	// Metrics and Audit, if set, are updated with every file's outcome
+	// before its last event is sent.
+	Metrics *metrics.Metrics
+	Audit   *audit.Logger
+	// Hooks are run before the run, after every file and after the run;
+	// failures are logged and listed in the Summary's HookErrors, and only
+	// abort the run for Required hooks.
+	Hooks  Hooks
+	Logger *slog.Logger // nil = silent
 }
 
-func (o *Orchestrator) Run(ctx context.Context, tasks []FileTask) (<-chan ProgressEvent, <-chan error) {
+// Run processes tasks in three stages joined by bounded queues: downloads
+// (Concurrency at a time), optimization (Encoders) and uploads (Uploaders),
+// so the network and the CPU are busy at the same time. Events are sent on
+// the first channel, which is closed when the run is over; then the run's
+// Summary is sent on the second.
+func (o *Orchestrator) Run(ctx context.Context, tasks []FileTask) (<-chan ProgressEvent, <-chan Summary) {
+	ch := make(chan FileTask, len(tasks))
+	for _, t := range tasks {
+		ch <- t
+	}
+	close(ch)
+	return o.run(ctx, ch, len(tasks))
+}
+
+// RunStream is Run for tasks that arrive on a channel, e.g. from Walk: files
+// start as soon as they are received, FileIDs number them in that order, and
+// the run is over once tasks is closed and every file received is done.
+func (o *Orchestrator) RunStream(ctx context.Context, tasks <-chan FileTask) (<-chan ProgressEvent, <-chan Summary) {
+	return o.run(ctx, tasks, 0)
+}
+
+// run processes the tasks received; total, if known, is their number.
+func (o *Orchestrator) run(parent context.Context, tasks <-chan FileTask, total int) (<-chan ProgressEvent, <-chan Summary) {
 	prog := make(chan ProgressEvent)
-	errs := make(chan error, 1)
+	done := make(chan Summary, 1)
 	if o.Concurrency <= 0 {
 		o.Concurrency = 1
 	}
+	if o.Encoders <= 0 {
+		o.Encoders = runtime.GOMAXPROCS(0)
+	}
+	if o.Uploaders <= 0 {
+		o.Uploaders = o.Concurrency
+	}
 	if o.TinyThreshold == 0 {
-		o.TinyThreshold = 15 * 1024
+		o.TinyThreshold = filter.DefaultMinSize
+	}
+	rules := o.Filter
+	if o.TinyThreshold > rules.MinSize {
+		rules.MinSize = o.TinyThreshold
+	}
+	log := o.log()
+	sum := Summary{Files: total, Outcomes: make([]*Outcome, total)}
+	var mu sync.Mutex
+	// A failed Required post-file hook cancels ctx; hooks run on hookCtx so
+	// that they still run once the run is canceled.
+	ctx, cancel := context.WithCancelCause(parent)
+	hookCtx := context.WithoutCancel(parent)
+	hooked := func(errs []*HookError) {
+		if len(errs) == 0 {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		sum.HookErrors = append(sum.HookErrors, errs...)
+		if err := fatal(errs); err != nil && sum.Err == nil {
+			sum.Err = err
+			cancel(err)
+		}
+	}
+	// Post-file hooks run off the stage workers, which only wait for them
+	// when postFileQueue outcomes are queued.
+	var postFile chan *Outcome
+	postFileDone := make(chan struct{})
+	if len(o.Hooks.PostFile) > 0 {
+		postFile = make(chan *Outcome, postFileQueue)
+		go func() {
+			defer close(postFileDone)
+			for oc := range postFile {
+				hooked(o.runHooks(hookCtx, o.Hooks.PostFile, HookEvent{Hook: HookPostFile, DryRun: o.DryRun, File: hookFile(oc)}, oc.Entry.Path))
+			}
+		}()
+	}
+	finish := func(oc *Outcome) {
+		if o.Metrics != nil {
+			oc.Count(o.Metrics)
+		}
+		if o.Audit != nil {
+			if err := o.Audit.Append(oc.AuditRecord()); err != nil {
+				log.Warn("audit append failed", "file", oc.Entry.Path, "err", err)
+			}
+		}
+		mu.Lock()
+		sum.add(oc)
+		mu.Unlock()
+		if postFile != nil {
+			postFile <- oc
+		}
 	}
 	go func() {
-		defer close(prog)
-		defer close(errs)
-		sem := make(chan struct{}, o.Concurrency)
-		var wg sync.WaitGroup
-		for i, task := range tasks {
-			i, task := i, task
-			wg.Add(1)
-			sem <- struct{}{}
-			go func() {
-				defer wg.Done()
-				defer func() { <-sem }()
+		start := time.Now()
+		started := false // the pre-run hooks passed
+		defer func() {
+			defer cancel(nil)
+			if postFile != nil {
+				close(postFile)
+				<-postFileDone
+			}
+			sum.Duration = time.Since(start)
+			for _, oc := range sum.Outcomes {
+				if oc == nil {
+					sum.NotProcessed++
+				}
+			}
+			if started && len(o.Hooks.PostRun) > 0 {
+				hooked(o.runHooks(hookCtx, o.Hooks.PostRun, HookEvent{Hook: HookPostRun, DryRun: o.DryRun, Summary: hookSummary(sum)}, ""))
+			}
+			close(prog)
+			done <- sum
+			close(done)
+		}()
+		log.Info("pipeline run started", "files", total, "concurrency", o.Concurrency, "encoders", o.Encoders, "uploaders", o.Uploaders, "max_memory", o.MaxMemory)
+		defer func() { log.Info("pipeline run finished", "files", sum.Files, "duration", time.Since(start)) }()
+		if hooked(o.runHooks(hookCtx, o.Hooks.PreRun, HookEvent{Hook: HookPreRun, DryRun: o.DryRun, Files: total}, "")); sum.Err != nil {
+			return
+		}
+		started = true
+		var budget *memoryBudget
+		if o.MaxMemory > 0 {
+			budget = newMemoryBudget(o.MaxMemory)
+		}
+
+		type received struct {
+			id   int
+			task FileTask
+		}
+		pending := make(chan received)
+		n := 0 // tasks received
+		go func() {
+			defer close(pending)
+			for {
 				select {
+				case t, ok := <-tasks:
+					if !ok {
+						return
+					}
+					select {
+					case pending <- received{n, t}:
+						n++
+					case <-ctx.Done():
+						return
+					}
 				case <-ctx.Done():
 					return
-				default:
 				}
-				rc, entry, err := o.FS.Open(ctx, task.Entry.Path)
-				if err != nil {
-					prog <- ProgressEvent{FileID: i, Name: task.Entry.Name, Phase: PhaseDownload, Err: err, Done: true, Timestamp: time.Now()}
-					return
+			}
+		}()
+		downloaded, optimized := o.queue(o.Encoders), o.queue(o.Uploaders)
+		stage(o.Concurrency, func() {
+			for r := range pending {
+				now := time.Now()
+				f := &fileRun{id: r.id, task: r.task, prog: prog, log: log, journal: o.Journal, phaseStart: now, started: now, phases: map[Phase]time.Duration{}, finish: finish, release: func() {}}
+				if o.download(ctx, f, rules, budget) {
+					forward(ctx, downloaded, f)
 				}
-				data, err := io.ReadAll(rc)
-				_ = rc.Close()
-				if err != nil {
-					prog <- ProgressEvent{FileID: i, Name: task.Entry.Name, Phase: PhaseDownload, Err: err, Done: true, Timestamp: time.Now()}
-					return
+			}
+		}, func() { close(downloaded) })
+		stage(o.Encoders, func() {
+			for f := range downloaded {
+				if ctx.Err() != nil {
+					f.release()
+					continue
 				}
-				prog <- ProgressEvent{FileID: i, Name: task.Entry.Name, Phase: PhaseDownload, Bytes: int64(len(data)), Total: entry.Size, Done: true, Timestamp: time.Now()}
-				// optimize
-				out, res, optErr := o.Opt.OptimizeBytes(data, detectFormat(task.Entry.Name), optimizer.Params{JPEGQuality: o.JPEGQuality})
-				if optErr != nil && !res.Skipped {
-					prog <- ProgressEvent{FileID: i, Name: task.Entry.Name, Phase: PhaseOptimize, Bytes: res.OriginalSize, Total: res.OriginalSize, Done: true, Err: optErr, Timestamp: time.Now()}
-					return
+				if o.optimize(ctx, f) {
+					forward(ctx, optimized, f)
+				} else {
+					f.release()
 				}
-				if res.Skipped && res.Reason == "no-compression-gain" {
-					prog <- ProgressEvent{FileID: i, Name: task.Entry.Name, Phase: PhaseOptimize, Bytes: res.OriginalSize, Total: res.OriginalSize, Done: true, Timestamp: time.Now()}
-					// Skip upload phase as original is better
-					prog <- ProgressEvent{FileID: i, Name: task.Entry.Name, Phase: PhaseUpload, Bytes: res.OriginalSize, Total: res.OriginalSize, Done: true, Timestamp: time.Now()}
-					return
-				}
-				if res.Skipped {
-					prog <- ProgressEvent{FileID: i, Name: task.Entry.Name, Phase: PhaseOptimize, Bytes: res.OriginalSize, Total: res.OriginalSize, Done: true, Err: optErr, Timestamp: time.Now()}
-					return
-				}
-				prog <- ProgressEvent{FileID: i, Name: task.Entry.Name, Phase: PhaseOptimize, Bytes: res.OriginalSize, Total: res.OriginalSize, Done: true, Timestamp: time.Now()}
-				wc, err := o.FS.Create(ctx, task.Entry.Path, true)
-				if err != nil {
-					prog <- ProgressEvent{FileID: i, Name: task.Entry.Name, Phase: PhaseUpload, Done: true, Err: err, Timestamp: time.Now()}
-					return
-				}
-				if _, err := wc.Write(out); err != nil {
-					_ = wc.Close()
-					prog <- ProgressEvent{FileID: i, Name: task.Entry.Name, Phase: PhaseUpload, Done: true, Err: err, Timestamp: time.Now()}
-					return
-				}
-				_ = wc.Close()
-				prog <- ProgressEvent{FileID: i, Name: task.Entry.Name, Phase: PhaseUpload, Bytes: int64(len(out)), Total: int64(len(out)), Done: true, Timestamp: time.Now()}
-			}()
-		}
-		wg.Wait()
+			}
+		}, func() { close(optimized) })
+		stage(o.Uploaders, func() {
+			for f := range optimized {
+				o.upload(ctx, f)
+				f.release()
+			}
+		}, nil).Wait()
+		// Every downloader returned, so pending was closed: n is final.
+		sum.Files = max(sum.Files, n)
+		sum.grow(sum.Files)
 	}()
-	return prog, errs
+	return prog, done
+}
+
+// queue returns a stage's input queue: QueueSize files, or as many as the
+// stage has workers.
+func (o *Orchestrator) queue(workers int) chan *fileRun {
+	n := o.QueueSize
+	if n <= 0 {
+		n = workers
+	}
+	return make(chan *fileRun, n)
+}
+
+// stage runs n workers and then, once all have returned, after (if set).
+func stage(n int, worker func(), after func()) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for range n {
+		go func() {
+			defer wg.Done()
+			worker()
+		}()
+	}
+	if after != nil {
+		go func() {
+			wg.Wait()
+			after()
+		}()
+	}
+	return &wg
+}
+
+// forward queues f for the next stage; when ctx ends first, f is dropped
+// (and counted as not processed).
+func forward(ctx context.Context, next chan<- *fileRun, f *fileRun) {
+	select {
+	case next <- f:
+	case <-ctx.Done():
+		f.release()
+	}
+}
+
+// download is the first stage: it skips f if the filter or the ledger say
+// so, waits for room in the memory budget and spools the source to a
+// temporary file, so queued files hold no source in memory. It reports
+// whether f goes on to be optimized; f.release also removes the spool.
+func (o *Orchestrator) download(ctx context.Context, f *fileRun, rules filter.Rules, budget *memoryBudget) bool {
+	if ctx.Err() != nil || o.filtered(ctx, f, rules) || o.unchanged(f) {
+		return false
+	}
+	release, ok := o.admit(ctx, f, budget)
+	if !ok {
+		return false
+	}
+	f.release = func() {
+		f.dropSource()
+		release()
+	}
+	defer f.closeOpened()
+	var n int64
+	sum := sha256.New()
+	spool, err := os.CreateTemp("", "photoptim-src-*")
+	retries := 0
+	if err == nil {
+		f.spool = spool
+		retries, err = o.retry(ctx, f, PhaseDownload, func() error {
+			rc, e, err := f.open(ctx, o.FS)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			f.entry = e
+			if _, err := spool.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			if err := spool.Truncate(0); err != nil {
+				return err
+			}
+			sum.Reset()
+			n, err = io.Copy(io.MultiWriter(spool, sum), progress.NewReader(o.Throttle.Reader(ctx, rc), o.ProgressInterval, func(n int64) {
+				f.emit(ProgressEvent{Phase: PhaseDownload, Bytes: n, Total: e.Size})
+			}))
+			return err
+		})
+	}
+	if err != nil {
+		f.emit(ProgressEvent{Phase: PhaseDownload, Err: err, Done: true, Retries: retries, Reason: failReason(err)})
+		f.release()
+		return false
+	}
+	f.srcHash = hex.EncodeToString(sum.Sum(nil))
+	f.emit(ProgressEvent{Phase: PhaseDownload, Bytes: n, Total: f.entry.Size, Done: true, Retries: retries})
+	return true
+}
+
+// optimize is the second stage: it encodes f's source and reports the
+// optimize phase. It reports whether f goes on to be uploaded; a kept
+// original finishes here.
+func (o *Orchestrator) optimize(ctx context.Context, f *fileRun) bool {
+	out, res, optErr := o.encode(ctx, f)
+	if o.Backup == nil {
+		f.dropSource()
+	}
+	if optErr != nil && !res.Skipped {
+		f.emit(ProgressEvent{Phase: PhaseOptimize, Bytes: res.OriginalSize, Total: res.OriginalSize, Done: true, Reason: res.Reason, Err: optErr, Result: res})
+		return false
+	}
+	if res.KeptOriginal() {
+		f.emit(ProgressEvent{Phase: PhaseOptimize, Bytes: res.OriginalSize, Total: res.OriginalSize, Done: true, Reason: res.Reason, Result: res})
+		// Skip upload phase as original is better
+		f.emit(ProgressEvent{Phase: PhaseUpload, Bytes: res.OriginalSize, Total: res.OriginalSize, Done: true})
+		if !o.DryRun {
+			o.remember(f, ledger.Record{Size: f.entry.Size, ModTime: f.entry.ModTime, Hash: f.srcHash, Reason: res.Reason})
+		}
+		return false
+	}
+	if res.Skipped {
+		f.emit(ProgressEvent{Phase: PhaseOptimize, Bytes: res.OriginalSize, Total: res.OriginalSize, Done: true, Reason: res.Reason, Err: optErr, Result: res})
+		return false
+	}
+	f.emit(ProgressEvent{Phase: PhaseOptimize, Bytes: res.OriginalSize, Total: res.OriginalSize, Done: true, Reason: res.Reason, Result: res})
+	f.out = out
+	return true
+}
+
+// encode optimizes f's spooled source, streaming it when o.Opt is an
+// optimizer.StreamOptimizer and reading it into memory otherwise.
+func (o *Orchestrator) encode(ctx context.Context, f *fileRun) ([]byte, optimizer.Result, error) {
+	format := detectFormat(f.task.Entry.Name)
+	if so, ok := o.Opt.(optimizer.StreamOptimizer); ok {
+		if _, err := f.spool.Seek(0, io.SeekStart); err != nil {
+			return nil, optimizer.Result{}, err
+		}
+		var out bytes.Buffer
+		res, err := so.OptimizeStream(ctx, f.spool, &out, format, o.params())
+		return out.Bytes(), res, err
+	}
+	data, err := f.source()
+	if err != nil {
+		return nil, optimizer.Result{}, err
+	}
+	return o.Opt.OptimizeBytes(ctx, data, format, o.params())
+}
+
+// upload replaces the source with out, keeping its mode, owner and (unless
+// TouchModTime) mtime. out is decoded once more first; the original is saved
+// to o.Backup, if set; then out is written to a temporary file that is
+// verified and renamed over the original (remotefs.AtomicFile). Transient
+// failures are retried.
+func (o *Orchestrator) upload(ctx context.Context, f *fileRun) {
+	out, reason := f.out, f.result.Reason
+	total := int64(len(out))
+	if err := optimizer.Verify(out); err != nil {
+		f.emit(ProgressEvent{Phase: PhaseUpload, Total: total, Done: true, Err: err, Reason: "verify-failed"})
+		return
+	}
+	if o.DryRun {
+		f.emit(ProgressEvent{Phase: PhaseUpload, Total: total, Done: true, Reason: "dry-run"})
+		return
+	}
+	commit := remotefs.Preserve(f.entry)
+	commit.VerifyHash = o.VerifyHash
+	if o.TouchModTime {
+		commit.ModTime = time.Time{}
+	}
+	backedUp := o.Backup == nil
+	retries, err := o.retry(ctx, f, PhaseUpload, func() error {
+		if !backedUp {
+			src, err := f.source()
+			if err != nil {
+				return fmt.Errorf("backup: %w", err)
+			}
+			if err := o.Backup.Save(ctx, f.entry, src); err != nil {
+				return fmt.Errorf("backup: %w", err)
+			}
+			backedUp = true
+		}
+		af, err := remotefs.CreateAtomic(ctx, o.FS, f.task.Entry.Path)
+		if err != nil {
+			return err
+		}
+		defer af.Close()
+		dst := progress.NewWriter(o.Throttle.Writer(ctx, af), o.ProgressInterval, func(n int64) {
+			f.emit(ProgressEvent{Phase: PhaseUpload, Bytes: n, Total: total})
+		})
+		if _, err := dst.Write(out); err != nil {
+			return err
+		}
+		if err := af.Commit(ctx, commit); err != nil {
+			return err
+		}
+		if af.OwnerDenied() {
+			f.log.Debug("owner not kept: chown not permitted", "file", f.task.Entry.Path, "uid", commit.UID, "gid", commit.GID)
+		}
+		return nil
+	})
+	if err != nil {
+		f.emit(ProgressEvent{Phase: PhaseUpload, Total: total, Done: true, Err: err, Retries: retries, Reason: failReason(err)})
+		return
+	}
+	f.emit(ProgressEvent{Phase: PhaseUpload, Bytes: total, Total: total, Done: true, Retries: retries})
+	if o.Ledger == nil {
+		return
+	}
+	// The mtime is kept, but the server may store it at a coarser precision.
+	e, err := o.FS.Stat(ctx, f.task.Entry.Path)
+	if err != nil {
+		f.log.Warn("ledger stat failed", "file", f.task.Entry.Path, "err", err)
+		return
+	}
+	h := sha256.Sum256(out)
+	o.remember(f, ledger.Record{Size: e.Size, ModTime: e.ModTime, Hash: hex.EncodeToString(h[:]), Reason: reason})
+}
+
+// unchanged reports, and finishes f as skipped, if the ledger says f was
+// optimized before and has not changed since.
+func (o *Orchestrator) unchanged(f *fileRun) bool {
+	e := f.task.Entry
+	if o.Rescan || !o.Ledger.Unchanged(o.LedgerHost, ledger.Path(o.FS.Root(), e.Path), e.Size, e.ModTime) {
+		return false
+	}
+	skip(f, "previously-optimized")
+	return true
+}
+
+// filtered reports, and finishes f as skipped, if rules leave f out.
+func (o *Orchestrator) filtered(ctx context.Context, f *fileRun, rules filter.Rules) bool {
+	reason := rules.MatchRemote(ctx, o.FS, f.task.Entry)
+	if reason == "" {
+		return false
+	}
+	skip(f, reason)
+	return true
+}
+
+// skip finishes f without downloading it: the download is done with no
+// bytes and the optimize phase is skipped for reason.
+func skip(f *fileRun, reason string) {
+	size := f.task.Entry.Size
+	res := optimizer.Result{OriginalSize: size, OptimizedSize: size, Skipped: true, Reason: reason}
+	f.emit(ProgressEvent{Phase: PhaseDownload, Total: size, Done: true})
+	f.emit(ProgressEvent{Phase: PhaseOptimize, Bytes: size, Total: size, Done: true, Reason: reason, Result: res})
+}
+
+// remember records f in the ledger.
+func (o *Orchestrator) remember(f *fileRun, rec ledger.Record) {
+	if o.Ledger == nil {
+		return
+	}
+	if err := o.Ledger.Put(o.LedgerHost, ledger.Path(o.FS.Root(), f.task.Entry.Path), rec); err != nil {
+		f.log.Warn("ledger update failed", "file", f.task.Entry.Path, "err", err)
+	}
+}
+
+// retry runs fn under the retry policy. Each retry is reported as an
+// in-flight event of phase carrying the error, and is preceded by
+// re-establishing the session when the filesystem supports it.
+func (o *Orchestrator) retry(ctx context.Context, f *fileRun, phase Phase, fn func() error) (int, error) {
+	policy := o.Retry
+	if policy == (retry.Policy{}) {
+		policy = retry.Default
+	}
+	retrying := false
+	return policy.Do(ctx, func() error {
+		if rc, ok := o.FS.(remotefs.Reconnector); ok && retrying {
+			if err := rc.Reconnect(ctx); err != nil {
+				return fmt.Errorf("reconnect: %w", err)
+			}
+		}
+		return fn()
+	}, remotefs.IsTransient, func(n int, err error, wait time.Duration) {
+		retrying = true
+		f.log.Info("pipeline retry", "file", f.task.Entry.Path, "phase", phase, "retry", n, "wait", wait, "err", err)
+		f.emit(ProgressEvent{Phase: phase, Err: err, Retries: n})
+	})
+}
+
+// failReason classifies the final error of a failed download or upload.
+func failReason(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case remotefs.IsTransient(err):
+		return "retries-exhausted"
+	}
+	return "permanent-error"
+}
+
+func (o *Orchestrator) log() *slog.Logger { return logging.OrDiscard(o.Logger) }
+
+// params returns the optimizer parameters for one file. Outputs are not
+// scored (Params.Score): that decodes them again and builds float planes,
+// memory memoryEstimate does not budget for.
+func (o *Orchestrator) params() optimizer.Params {
+	return optimizer.Params{JPEGQuality: o.JPEGQuality, Timeout: o.ImageTimeout}
 }
 
 func detectFormat(name string) string {
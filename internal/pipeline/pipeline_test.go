@@ -2,14 +2,25 @@ package pipeline
 
 import (
 	"context"
+	"errors"
 	"image"
 	"image/color"
 	"image/jpeg"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/juparave/photoptim/internal/filter"
+	"github.com/juparave/photoptim/internal/metrics"
 	"github.com/juparave/photoptim/internal/optimizer"
 	"github.com/juparave/photoptim/internal/remotefs"
+	"github.com/juparave/photoptim/internal/retry"
 )
 
 func genJPEG() []byte {
@@ -36,16 +47,21 @@ func TestOrchestratorRun(t *testing.T) {
 	fs.PutTestFile("/a.jpg", img1)
 	fs.PutTestFile("/b.jpg", img2)
 	opt := optimizer.New()
-	orch := Orchestrator{FS: fs, Opt: opt, Concurrency: 2, JPEGQuality: 75}
+	orch := Orchestrator{FS: fs, Opt: opt, Concurrency: 2, JPEGQuality: 75, TinyThreshold: -1}
 	tasks := []FileTask{{Entry: remotefs.RemoteEntry{Path: "/a.jpg", Name: "a.jpg", Size: int64(len(img1))}}, {Entry: remotefs.RemoteEntry{Path: "/b.jpg", Name: "b.jpg", Size: int64(len(img2))}}}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	prog, errs := orch.Run(ctx, tasks)
-	var dl, optc, up int
+	var m metrics.Metrics
+	orch.Metrics = &m
+	prog, done := orch.Run(ctx, tasks)
+	var dl, optc, up, outcomes int
 	for ev := range prog {
 		if ev.Err != nil {
 			t.Fatalf("unexpected error: %v", ev.Err)
 		}
+		if ev.Outcome != nil {
+			outcomes++
+		}
 		switch ev.Phase {
 		case PhaseDownload:
 			dl++
@@ -55,14 +71,541 @@ func TestOrchestratorRun(t *testing.T) {
 			up++
 		}
 	}
+	sum := <-done
+	if sum.Err != nil {
+		t.Fatalf("summary: %v", sum.Err)
+	}
+	if dl != 2 || optc != 2 || up != 2 || outcomes != 2 {
+		t.Fatalf("phase counts mismatch dl=%d opt=%d up=%d outcomes=%d", dl, optc, up, outcomes)
+	}
+	if sum.Files != 2 || sum.Optimized+sum.Kept != 2 || sum.NotProcessed != 0 {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+	if got := m.Processed.Load() + m.Skipped.Load(); got != 2 || m.Processed.Load() != int64(sum.Optimized) {
+		t.Fatalf("metrics disagree with summary: processed=%d skipped=%d", m.Processed.Load(), m.Skipped.Load())
+	}
+	for _, oc := range sum.Outcomes {
+		if oc.Phases[PhaseOptimize] <= 0 || oc.OriginalSize == 0 {
+			t.Fatalf("incomplete outcome %+v", oc)
+		}
+	}
+}
+
+// genLargeJPEG returns a noisy quality-100 JPEG that re-encodes smaller.
+func genLargeJPEG() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	var data []byte
+	_ = jpeg.Encode(&sliceWriter{&data}, img, &jpeg.Options{Quality: 100})
+	return data
+}
+
+func TestOrchestratorByteProgress(t *testing.T) {
+	data := genLargeJPEG()
+	fs := remotefs.NewMockFS("/")
+	fs.PutTestFile("/big.jpg", data)
+	tasks := []FileTask{{Entry: remotefs.RemoteEntry{Path: "/big.jpg", Name: "big.jpg", Size: int64(len(data))}}}
+
+	orch := Orchestrator{FS: fs, Opt: optimizer.New(), JPEGQuality: 50, ProgressInterval: time.Nanosecond}
+	tracker := NewProgress(tasks)
+	prog, _ := orch.Run(context.Background(), tasks)
+	inflight := map[Phase]int{}
+	for ev := range prog {
+		tracker.Observe(ev)
+		if ev.Err != nil {
+			t.Fatalf("unexpected error: %v", ev.Err)
+		}
+		if !ev.Done {
+			inflight[ev.Phase]++
+		}
+	}
+	if inflight[PhaseDownload] == 0 || inflight[PhaseUpload] == 0 {
+		t.Fatalf("expected in-flight download and upload events, got %v", inflight)
+	}
+	if s := tracker.Snapshot(); s.Percent != 1 || s.Done != s.Total || s.Done <= int64(len(data)) {
+		t.Fatalf("unexpected final snapshot %+v (source %d bytes)", s, len(data))
+	}
+}
+
+func TestOrchestratorRetry(t *testing.T) {
+	spool := t.TempDir()
+	t.Setenv("TMPDIR", spool)
+	data := genLargeJPEG()
+	fs := remotefs.NewMockFS("/")
+	fs.PutTestFile("/flaky.jpg", data)
+	fs.PutTestFile("/denied.jpg", data)
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	_ = fs.Chmod(context.Background(), "/flaky.jpg", 0o600)
+	_ = fs.Chtimes(context.Background(), "/flaky.jpg", mtime, mtime)
+	fs.FailTestOp("read", "/flaky.jpg", 1, io.ErrUnexpectedEOF)
+	fs.FailTestOp("rename", "/.flaky.jpg.*.tmp", 1, remotefs.ErrConnectionLost)
+	fs.FailTestOp("create", "/.denied.jpg.*.tmp", 1, iofs.ErrPermission)
+	tasks := []FileTask{
+		{Entry: remotefs.RemoteEntry{Path: "/flaky.jpg", Name: "flaky.jpg", Size: int64(len(data))}},
+		{Entry: remotefs.RemoteEntry{Path: "/denied.jpg", Name: "denied.jpg", Size: int64(len(data))}},
+		{Entry: remotefs.RemoteEntry{Path: "/gone.jpg", Name: "gone.jpg", Size: int64(len(data))}},
+	}
+	orch := Orchestrator{FS: fs, Opt: optimizer.New(), JPEGQuality: 50, Retry: retry.Policy{Retries: 4, Base: time.Millisecond}}
+	prog, _ := orch.Run(context.Background(), tasks)
+	retried := map[Phase]int{}
+	final := map[string]ProgressEvent{}
+	for ev := range prog {
+		if ev.Err != nil && !ev.Done {
+			retried[ev.Phase]++
+		}
+		if ev.Done && ev.Phase == PhaseUpload {
+			final[ev.Name] = ev
+		}
+	}
+	if retried[PhaseDownload] != 1 || retried[PhaseUpload] != 1 {
+		t.Fatalf("expected one download and one upload retry, got %v", retried)
+	}
+	if ev := final["flaky.jpg"]; ev.Err != nil || ev.Retries != 1 {
+		t.Fatalf("flaky.jpg: err=%v retries=%d, want success after 1 retry", ev.Err, ev.Retries)
+	}
+	if ev := final["denied.jpg"]; !errors.Is(ev.Err, iofs.ErrPermission) || ev.Retries != 0 || ev.Reason != "permanent-error" {
+		t.Fatalf("denied.jpg: err=%v retries=%d reason=%q, want permanent failure", ev.Err, ev.Retries, ev.Reason)
+	}
+	if n := fs.Reconnects(); n != 2 {
+		t.Fatalf("expected 2 reconnects, got %d", n)
+	}
+	if e, _ := fs.Stat(context.Background(), "/flaky.jpg"); e.Size >= int64(len(data)) || e.Mode != 0o600 || !e.ModTime.Equal(mtime) {
+		t.Fatalf("flaky.jpg: %d bytes, mode %v, mtime %v; want replaced with mode and mtime kept", e.Size, e.Mode, e.ModTime)
+	}
+	if e, _ := fs.Stat(context.Background(), "/denied.jpg"); e.Size != int64(len(data)) {
+		t.Fatalf("denied.jpg changed: %d bytes", e.Size)
+	}
+	if entries, _ := fs.List(context.Background(), "/"); len(entries) != 2 {
+		t.Fatalf("temporary files left behind: %v", entries)
+	}
+	if left, _ := os.ReadDir(spool); len(left) != 0 {
+		t.Fatalf("spooled sources left behind: %v", left)
+	}
+}
+
+func TestOrchestratorFilter(t *testing.T) {
+	small, large := genJPEG(), genLargeJPEG()
+	fs := remotefs.NewMockFS("/")
+	fs.PutTestFile("/small.jpg", small)
+	fs.PutTestFile("/large.jpg", large)
+	tasks := []FileTask{
+		{Entry: remotefs.RemoteEntry{Path: "/small.jpg", Name: "small.jpg", Size: int64(len(small))}},
+		{Entry: remotefs.RemoteEntry{Path: "/large.jpg", Name: "large.jpg", Size: int64(len(large))}},
+	}
+	orch := Orchestrator{FS: fs, Opt: optimizer.New(), JPEGQuality: 50, Filter: filter.Rules{MinWidth: 512}}
+	prog, _ := orch.Run(context.Background(), tasks)
+	reasons := map[string]string{}
+	for ev := range prog {
+		if ev.Phase == PhaseDownload && ev.Done && ev.Bytes > 0 {
+			t.Fatalf("%s was downloaded", ev.Name)
+		}
+		if ev.Phase == PhaseOptimize && ev.Done {
+			reasons[ev.Name] = ev.Reason
+		}
+	}
+	if reasons["small.jpg"] != filter.ReasonTooSmall || reasons["large.jpg"] != filter.ReasonTooFewPixels {
+		t.Fatalf("unexpected skip reasons %v", reasons)
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	b := newMemoryBudget(100)
+	queued := make(chan struct{}, 1)
+	b.queued = func() { queued <- struct{}{} }
+	if !b.tryAcquire(60) || b.tryAcquire(60) {
+		t.Fatal("expected the first 60 to fit and the second not to")
+	}
+	// An oversized file waits for the whole budget, then runs alone.
+	admitted := make(chan error, 1)
+	go func() { admitted <- b.acquire(context.Background(), 500) }()
+	<-queued
 	select {
-	case e := <-errs:
-		if e != nil {
-			t.Fatalf("errs: %v", e)
+	case err := <-admitted:
+		t.Fatalf("oversized file admitted while 60 in use: %v", err)
+	default:
+	}
+	if b.tryAcquire(10) {
+		t.Fatal("a small file overtook a waiting one")
+	}
+	b.release(60)
+	if err := <-admitted; err != nil {
+		t.Fatalf("oversized file not admitted: %v", err)
+	}
+	if got := b.inUse(); got != 100 {
+		t.Fatalf("in use %d, want 100", got)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.acquire(ctx, 10); !errors.Is(err, context.Canceled) {
+		t.Fatalf("acquire after cancel: %v", err)
+	}
+	b.release(500)
+	if got := b.inUse(); got != 0 {
+		t.Fatalf("in use %d after release, want 0", got)
+	}
+
+	// A run whose files each exceed the budget still finishes, one at a
+	// time, opening each file once.
+	fs := &countingFS{MockFS: remotefs.NewMockFS("/"), opens: map[string]int{}}
+	var tasks []FileTask
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		img := genJPEG()
+		fs.PutTestFile("/"+name, img)
+		tasks = append(tasks, FileTask{Entry: remotefs.RemoteEntry{Path: "/" + name, Name: name, Size: int64(len(img))}})
+	}
+	orch := Orchestrator{FS: fs, Opt: optimizer.New(), Concurrency: 3, MaxMemory: 1, TinyThreshold: -1}
+	prog, done := orch.Run(context.Background(), tasks)
+	for range prog {
+	}
+	if sum := <-done; sum.Optimized+sum.Kept != 3 {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+	for _, task := range tasks {
+		if n := fs.opened(task.Entry.Path); n != 1 {
+			t.Fatalf("%s opened %d times, want once", task.Entry.Path, n)
+		}
+	}
+}
+
+// TestMemoryBudgetPeak checks the memory the files in flight actually hold,
+// from their events, against the budget: a file is charged its source from
+// its download, and its decoded image and output from its encoding, until
+// its last event.
+func TestMemoryBudgetPeak(t *testing.T) {
+	fs := remotefs.NewMockFS("/")
+	var tasks []FileTask
+	var need int64
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg", "f.jpg"} {
+		img := genLargeJPEG()
+		fs.PutTestFile("/"+name, img)
+		tasks = append(tasks, FileTask{Entry: remotefs.RemoteEntry{Path: "/" + name, Name: name, Size: int64(len(img))}})
+		need = memoryEstimate(int64(len(img)), 256, 256)
+	}
+	limit := need * 5 / 2
+	orch := Orchestrator{FS: fs, Opt: optimizer.New(), JPEGQuality: 50, Concurrency: 6, Encoders: 6, Uploaders: 6, MaxMemory: limit}
+	prog, done := orch.Run(context.Background(), tasks)
+	held := map[int]int64{}
+	var inFlight, peak int64
+	for ev := range prog {
+		if !ev.Done {
+			continue
+		}
+		var n int64
+		switch {
+		case ev.Outcome != nil:
+			inFlight -= held[ev.FileID]
+			delete(held, ev.FileID)
+			continue
+		case ev.Phase == PhaseDownload:
+			n = ev.Bytes
+		case ev.Phase == PhaseOptimize:
+			n = int64(ev.Result.SourceWidth) * int64(ev.Result.SourceHeight) * 4
+			if !ev.Result.Skipped {
+				n += ev.Result.OptimizedSize
+			}
+		}
+		held[ev.FileID] += n
+		inFlight += n
+		peak = max(peak, inFlight)
+	}
+	if sum := <-done; sum.Optimized != len(tasks) {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+	if peak == 0 || peak > limit {
+		t.Fatalf("peak in-flight bytes %d, budget %d", peak, limit)
+	}
+}
+
+// countingFS counts the files opened.
+type countingFS struct {
+	*remotefs.MockFS
+	mu    sync.Mutex
+	opens map[string]int
+}
+
+func (c *countingFS) Open(ctx context.Context, p string) (io.ReadCloser, remotefs.RemoteEntry, error) {
+	c.mu.Lock()
+	c.opens[p]++
+	c.mu.Unlock()
+	return c.MockFS.Open(ctx, p)
+}
+
+func (c *countingFS) opened(p string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.opens[p]
+}
+
+func (c *countingFS) total() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, o := range c.opens {
+		n += o
+	}
+	return n
+}
+
+func TestOrchestratorStages(t *testing.T) {
+	fs := remotefs.NewMockFS("/")
+	var tasks []FileTask
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg"} {
+		img := genLargeJPEG()
+		fs.PutTestFile("/"+name, img)
+		tasks = append(tasks, FileTask{Entry: remotefs.RemoteEntry{Path: "/" + name, Name: name, Size: int64(len(img))}})
+	}
+	orch := Orchestrator{FS: fs, Opt: optimizer.New(), JPEGQuality: 50, Concurrency: 3, Encoders: 1, Uploaders: 2, QueueSize: 1}
+	prog, done := orch.Run(context.Background(), tasks)
+	order := map[int][]Phase{}
+	for ev := range prog {
+		if ev.Err != nil {
+			t.Fatalf("unexpected error: %v", ev.Err)
 		}
+		if ev.Done {
+			order[ev.FileID] = append(order[ev.FileID], ev.Phase)
+		}
+	}
+	for id, phases := range order {
+		if len(phases) != 3 || phases[0] != PhaseDownload || phases[1] != PhaseOptimize || phases[2] != PhaseUpload {
+			t.Fatalf("file %d finished phases %v, want download, optimize, upload", id, phases)
+		}
+	}
+	if sum := <-done; sum.Optimized != len(tasks) {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+}
+
+// TestOrchestratorBackpressure blocks the upload stage and checks that the
+// download and optimize stages go on meanwhile, up to their queue bounds,
+// and then stop.
+func TestOrchestratorBackpressure(t *testing.T) {
+	fs := &blockingFS{countingFS: &countingFS{MockFS: remotefs.NewMockFS("/"), opens: map[string]int{}}, entered: make(chan struct{}, 1), unblock: make(chan struct{})}
+	var tasks []FileTask
+	data := genLargeJPEG()
+	for i := range 10 {
+		name := string(rune('a'+i)) + ".jpg"
+		fs.PutTestFile("/"+name, data)
+		tasks = append(tasks, FileTask{Entry: remotefs.RemoteEntry{Path: "/" + name, Name: name, Size: int64(len(data))}})
+	}
+	orch := Orchestrator{FS: fs, Opt: optimizer.New(), JPEGQuality: 50, Concurrency: 1, Encoders: 1, Uploaders: 1, QueueSize: 1}
+	prog, done := orch.Run(context.Background(), tasks)
+
+	// With the first upload blocked, one file waits in the upload queue, one
+	// in the encoder, one in the optimize queue and one in the downloader:
+	// 5 downloaded, 3 optimized.
+	const wantDownloads, wantOptimized = 5, 3
+	downloads, optimized, blocked := 0, 0, false
+	for downloads < wantDownloads || optimized < wantOptimized || !blocked {
+		select {
+		case ev := <-prog:
+			if ev.Done && ev.Phase == PhaseDownload {
+				downloads++
+			}
+			if ev.Done && ev.Phase == PhaseOptimize {
+				optimized++
+			}
+		case <-fs.entered:
+			blocked = true
+		}
+	}
+	select {
+	case ev := <-prog:
+		t.Fatalf("event past the queue bounds while uploads are blocked: %+v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if downloads != wantDownloads || optimized != wantOptimized || fs.total() != wantDownloads {
+		t.Fatalf("%d downloaded, %d optimized, %d opened; want %d, %d, %d", downloads, optimized, fs.total(), wantDownloads, wantOptimized, wantDownloads)
+	}
+	close(fs.unblock)
+	for range prog {
+	}
+	if sum := <-done; sum.Optimized != len(tasks) {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+}
+
+// blockingFS blocks every Create until unblock is closed, signaling entered
+// the first time.
+type blockingFS struct {
+	*countingFS
+	entered chan struct{}
+	unblock chan struct{}
+}
+
+func (b *blockingFS) Create(ctx context.Context, p string, overwrite bool) (io.WriteCloser, error) {
+	select {
+	case b.entered <- struct{}{}:
 	default:
 	}
-	if dl != 2 || optc != 2 || up != 2 {
-		t.Fatalf("phase counts mismatch dl=%d opt=%d up=%d", dl, optc, up)
+	<-b.unblock
+	return b.countingFS.Create(ctx, p, overwrite)
+}
+
+func TestOrchestratorPreserve(t *testing.T) {
+	ctx := context.Background()
+	data := genLargeJPEG()
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, touch := range []bool{false, true} {
+		fs := remotefs.NewMockFS("/")
+		var tasks []FileTask
+		for _, name := range []string{"a.jpg", "denied.jpg"} {
+			p := "/" + name
+			fs.PutTestFile(p, data)
+			_ = fs.Chmod(ctx, p, 0o640)
+			_ = fs.Chtimes(ctx, p, mtime, mtime)
+			_ = fs.Chown(ctx, p, 33, 33)
+			tasks = append(tasks, FileTask{Entry: remotefs.RemoteEntry{Path: p, Name: name, Size: int64(len(data))}})
+		}
+		fs.FailTestOp("chown", "/.denied.jpg.*.tmp", 1, iofs.ErrPermission)
+		orch := Orchestrator{FS: fs, Opt: optimizer.New(), JPEGQuality: 50, TouchModTime: touch}
+		prog, done := orch.Run(ctx, tasks)
+		for range prog {
+		}
+		if sum := <-done; sum.Optimized != 2 {
+			t.Fatalf("touch=%v: unexpected summary %+v", touch, sum)
+		}
+		a, _ := fs.Stat(ctx, "/a.jpg")
+		denied, _ := fs.Stat(ctx, "/denied.jpg")
+		if a.UID != 33 || a.GID != 33 || a.Mode != 0o640 {
+			t.Fatalf("touch=%v: a.jpg owner %d:%d mode %v, want 33:33 0640", touch, a.UID, a.GID, a.Mode)
+		}
+		if denied.UID != remotefs.MockUID || denied.Mode != 0o640 {
+			t.Fatalf("touch=%v: denied.jpg owner %d mode %v, want the uploader and 0640", touch, denied.UID, denied.Mode)
+		}
+		if kept := a.ModTime.Equal(mtime); kept == touch {
+			t.Fatalf("touch=%v: a.jpg mtime %v", touch, a.ModTime)
+		}
+	}
+}
+
+func TestOrchestratorHooks(t *testing.T) {
+	ctx := context.Background()
+	data := genLargeJPEG()
+	newRun := func() (*remotefs.MockFS, []FileTask) {
+		fs := remotefs.NewMockFS("/")
+		var tasks []FileTask
+		for _, name := range []string{"a.jpg", "b.jpg"} {
+			fs.PutTestFile("/"+name, data)
+			tasks = append(tasks, FileTask{Entry: remotefs.RemoteEntry{Path: "/" + name, Name: name, Size: int64(len(data))}})
+		}
+		return fs, tasks
+	}
+
+	var mu sync.Mutex
+	var seen []HookEvent
+	var slowReturned atomic.Bool
+	record := Hook{Name: "record", Func: func(_ context.Context, ev HookEvent) error {
+		mu.Lock()
+		seen = append(seen, ev)
+		mu.Unlock()
+		return nil
+	}}
+	out := filepath.Join(t.TempDir(), "files")
+	fs, tasks := newRun()
+	orch := Orchestrator{FS: fs, Opt: optimizer.New(), JPEGQuality: 50, Hooks: Hooks{
+		PreRun: []Hook{record},
+		PostFile: []Hook{
+			record,
+			{Command: `printf '%s %s ' "$PHOTOPTIM_PATH" "$PHOTOPTIM_STATUS" >> ` + out + ` && cat >> ` + out + ` && echo >> ` + out},
+			{Name: "fails", Command: "echo purge failed >&2; exit 3"},
+		},
+		PostRun: []Hook{
+			{Name: "slow", Timeout: 10 * time.Millisecond, Func: func(ctx context.Context, _ HookEvent) error {
+				<-ctx.Done()
+				slowReturned.Store(true)
+				return ctx.Err()
+			}},
+			record,
+		},
+	}}
+	prog, done := orch.Run(ctx, tasks)
+	for range prog {
+	}
+	sum := <-done
+	if !slowReturned.Load() {
+		t.Fatal("the run finished before the timed out hook returned")
+	}
+	if sum.Err != nil || sum.Optimized != 2 {
+		t.Fatalf("failing hooks aborted the run: %+v", sum)
+	}
+	if len(sum.HookErrors) != 3 || !strings.Contains(sum.HookErrors[0].Error(), "purge failed") || !strings.Contains(sum.HookErrors[2].Error(), "timed out") {
+		t.Fatalf("unexpected hook errors %v", sum.HookErrors)
+	}
+	if len(seen) != 4 || seen[0].Hook != HookPreRun || seen[0].Files != 2 || seen[3].Summary == nil || seen[3].Summary.Optimized != 2 {
+		t.Fatalf("unexpected hook events %+v", seen)
+	}
+	for _, ev := range seen[1:3] {
+		if ev.File == nil || ev.File.Status != StatusOptimized || ev.File.OptimizedSize >= ev.File.OriginalSize {
+			t.Fatalf("unexpected post-file event %+v", ev.File)
+		}
+	}
+	lines, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(lines), `optimized {"hook":"post-file"`); got != 2 || !strings.Contains(string(lines), "/a.jpg optimized") {
+		t.Fatalf("post-file command got:\n%s", lines)
+	}
+
+	// A Required hook failing aborts the run.
+	seen = nil
+	fs, tasks = newRun()
+	orch = Orchestrator{FS: fs, Opt: optimizer.New(), JPEGQuality: 50, Hooks: Hooks{
+		PreRun:  []Hook{{Command: "exit 1", Required: true}},
+		PostRun: []Hook{record},
+	}}
+	prog, done = orch.Run(ctx, tasks)
+	for range prog {
+	}
+	if sum := <-done; sum.Err == nil || sum.NotProcessed != 2 || len(seen) != 0 {
+		t.Fatalf("required pre-run hook failed: summary %+v, post-run events %d", sum, len(seen))
+	}
+	fs, tasks = newRun()
+	orch = Orchestrator{FS: fs, Opt: optimizer.New(), JPEGQuality: 50, Hooks: Hooks{
+		PostFile: []Hook{{Name: "purge", Required: true, Func: func(context.Context, HookEvent) error { return errors.New("cdn down") }}},
+	}}
+	prog, done = orch.Run(ctx, tasks)
+	for range prog {
+	}
+	var hookErr *HookError
+	if sum := <-done; !errors.As(sum.Err, &hookErr) || !hookErr.Fatal || hookErr.File != "/a.jpg" {
+		t.Fatalf("required post-file hook failed: summary %+v", sum)
+	}
+
+	// A slow post-file hook does not hold up the files; the run waits for
+	// it before the post-run hooks.
+	seen = nil
+	release := make(chan struct{})
+	fs, tasks = newRun()
+	orch = Orchestrator{FS: fs, Opt: optimizer.New(), JPEGQuality: 50, Concurrency: 1, Hooks: Hooks{
+		PostFile: []Hook{{Name: "slow", Func: func(ctx context.Context, ev HookEvent) error {
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+			return record.Func(ctx, ev)
+		}}},
+		PostRun: []Hook{record},
+	}}
+	prog, done = orch.Run(ctx, tasks)
+	outcomes := 0
+	timeout := time.After(10 * time.Second)
+	for outcomes < len(tasks) {
+		select {
+		case ev := <-prog:
+			if ev.Outcome != nil {
+				outcomes++
+			}
+		case <-timeout:
+			t.Fatalf("%d of %d files finished while a post-file hook was running", outcomes, len(tasks))
+		}
+	}
+	close(release)
+	for range prog {
+	}
+	if sum := <-done; sum.Optimized != 2 || len(seen) != 3 || seen[2].Hook != HookPostRun {
+		t.Fatalf("post-run hooks ran before the post-file hooks: summary %+v, events %+v", sum, seen)
 	}
 }
@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"strconv"
+
+	"github.com/juparave/photoptim/internal/progress"
+)
+
+// Progress turns a run's events into overall byte progress. Every file
+// counts its listed size once for the download and once for the upload; the
+// upload estimate is corrected to the optimized size, or dropped when
+// nothing is uploaded.
+type Progress struct {
+	t *progress.Tracker
+}
+
+// NewProgress starts tracking tasks.
+func NewProgress(tasks []FileTask) *Progress {
+	p := &Progress{t: progress.NewTracker()}
+	for i, task := range tasks {
+		p.Add(i, task)
+	}
+	return p
+}
+
+// Add tracks one more task, of FileID id, e.g. as RunStream receives it.
+func (p *Progress) Add(id int, task FileTask) {
+	p.t.Add(unitKey(id, PhaseDownload), task.Entry.Size)
+	p.t.Add(unitKey(id, PhaseUpload), task.Entry.Size)
+}
+
+// Observe applies one event. Call it for every event of the run. A retry
+// restarts its phase's count.
+func (p *Progress) Observe(ev ProgressEvent) {
+	dl, ul := unitKey(ev.FileID, PhaseDownload), unitKey(ev.FileID, PhaseUpload)
+	if ev.Err != nil && ev.Done {
+		p.t.Finish(dl)
+		p.t.Finish(ul)
+		return
+	}
+	switch ev.Phase {
+	case PhaseDownload:
+		if ev.Total > 0 {
+			p.t.SetTotal(dl, ev.Total)
+		}
+		p.t.Set(dl, ev.Bytes)
+		if ev.Done {
+			p.t.Finish(dl)
+		}
+	case PhaseOptimize:
+		if !ev.Done {
+			return
+		}
+		if ev.Result.Skipped {
+			// Nothing is uploaded for skipped files, including kept originals.
+			p.t.Finish(ul)
+			return
+		}
+		p.t.SetTotal(ul, ev.Result.OptimizedSize)
+	case PhaseUpload:
+		if ev.Total > 0 {
+			p.t.SetTotal(ul, ev.Total)
+		}
+		p.t.Set(ul, ev.Bytes)
+		if ev.Done {
+			p.t.Finish(ul)
+		}
+	}
+}
+
+// Snapshot returns overall percentage, throughput and ETA.
+func (p *Progress) Snapshot() progress.Snapshot { return p.t.Snapshot() }
+
+func unitKey(id int, phase Phase) string { return strconv.Itoa(id) + "/" + string(phase) }
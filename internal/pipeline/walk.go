@@ -0,0 +1,233 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/juparave/photoptim/internal/backup"
+	"github.com/juparave/photoptim/internal/filter"
+	"github.com/juparave/photoptim/internal/logging"
+	"github.com/juparave/photoptim/internal/remotefs"
+)
+
+// Skip reasons of symbolic links Walk does not follow.
+const (
+	ReasonSymlink           = "symlink"              // FollowSymlinks is off, or the filesystem cannot resolve links
+	ReasonSymlinkOutside    = "symlink-outside-root" // resolves outside the filesystem root
+	ReasonSymlinkUnresolved = "symlink-unresolved"   // broken, or its target cannot be read
+)
+
+// WalkOptions control Walk. The backup directory (backup.RemoteDir) and
+// temporary upload files are always left out.
+type WalkOptions struct {
+	// MaxDepth limits how deep Walk descends: 1 = the root's files only;
+	// 0 = no limit.
+	MaxDepth int
+	// Filter selects the files sent; hidden directories are not entered
+	// unless Filter.Hidden. Dimension rules are left to the Orchestrator.
+	Filter filter.Rules
+	// FollowSymlinks follows links that resolve inside the filesystem root,
+	// if it implements remotefs.Resolver. Files reached through a link are
+	// sent under their real path, each once; directories already walked are
+	// not entered again, which breaks cycles.
+	FollowSymlinks bool
+	// Skipped, if set, is called for every file and unfollowed link left
+	// out, with its reason.
+	Skipped func(e remotefs.RemoteEntry, reason string)
+	Logger  *slog.Logger // nil = silent
+}
+
+// Walk lists root on fsys and its subdirectories, depth first in name
+// order, and sends a FileTask for every file opts select as soon as its
+// directory is listed, so a run (see RunStream) starts before the walk
+// ends. The task channel is closed when the walk is over; then its error is
+// sent on the second. A subdirectory that cannot be listed is logged and
+// passed over; root failing to resolve or list, or ctx ending, is the
+// walk's error.
+func Walk(ctx context.Context, fsys remotefs.RemoteFS, root string, opts WalkOptions) (<-chan FileTask, <-chan error) {
+	out := make(chan FileTask)
+	done := make(chan error, 1)
+	w := &walker{ctx: ctx, fsys: fsys, opts: opts, out: out, log: logging.OrDiscard(opts.Logger), abs: path.IsAbs(root), base: path.Clean("/" + fsys.Root())}
+	if res, ok := fsys.(remotefs.Resolver); ok && opts.FollowSymlinks {
+		w.res = res
+		w.dirs, w.files = map[string]bool{}, map[string]bool{}
+	}
+	go func() {
+		defer close(done)
+		err := w.walk(root)
+		close(out)
+		done <- err
+	}()
+	return out, done
+}
+
+type walker struct {
+	ctx  context.Context
+	fsys remotefs.RemoteFS
+	res  remotefs.Resolver // nil = symlinks are not followed
+	opts WalkOptions
+	out  chan<- FileTask
+	log  *slog.Logger
+	abs  bool   // paths are sent absolute, as root was given
+	base string // the filesystem root, absolute
+
+	dirs, files map[string]bool // real paths seen, when following links
+	walked      int             // directories listed
+	sent        int             // tasks sent
+}
+
+func (w *walker) walk(root string) error {
+	var resolved string
+	if w.res != nil {
+		var err error
+		if resolved, err = w.res.RealPath(w.ctx, root); err != nil {
+			return err
+		}
+		w.dirs[resolved] = true
+	}
+	w.log.Debug("walk started", "root", root, "follow_symlinks", w.res != nil, "max_depth", w.opts.MaxDepth)
+	defer func() { w.log.Debug("walk finished", "root", root, "dirs", w.walked, "files", w.sent) }()
+	return w.dir(root, resolved, 0)
+}
+
+// dir sends the selected files of directory p, whose real path is resolved
+// (when following links), and walks its subdirectories.
+func (w *walker) dir(p, resolved string, depth int) error {
+	entries, err := w.fsys.List(w.ctx, p)
+	if err != nil {
+		return err
+	}
+	w.walked++
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	for _, e := range entries {
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+		if own(e) {
+			continue
+		}
+		eResolved := path.Join(resolved, e.Name)
+		if e.Symlink {
+			if !w.opts.Filter.Hidden && strings.HasPrefix(e.Name, ".") {
+				continue
+			}
+			var ok bool
+			if e, eResolved, ok = w.follow(e); !ok {
+				continue
+			}
+		}
+		if e.IsDir {
+			if !w.opts.Filter.Hidden && strings.HasPrefix(e.Name, ".") {
+				continue
+			}
+			if w.opts.MaxDepth > 0 && depth+1 >= w.opts.MaxDepth {
+				continue
+			}
+			sub, ok := w.enter(e.Path)
+			if !ok {
+				continue
+			}
+			if err := w.dir(e.Path, sub, depth+1); err != nil {
+				if w.ctx.Err() != nil {
+					return w.ctx.Err()
+				}
+				w.log.Warn("walk: list failed", "dir", e.Path, "err", err)
+			}
+			continue
+		}
+		if reason := w.opts.Filter.Match(e); reason != "" {
+			w.skip(e, reason)
+			continue
+		}
+		if w.files != nil {
+			if w.files[eResolved] {
+				continue
+			}
+			w.files[eResolved] = true
+		}
+		select {
+		case w.out <- FileTask{Entry: e}:
+			w.sent++
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		}
+	}
+	return nil
+}
+
+// own reports whether e is photoptim's own: the backup directory or an
+// upload in progress. They are never walked, even with Filter.Hidden.
+func own(e remotefs.RemoteEntry) bool {
+	if e.IsDir {
+		return e.Name == backup.RemoteDir
+	}
+	return remotefs.IsTempName(e.Name)
+}
+
+// enter returns the real path of directory p and whether to walk it: not
+// when following links and it was walked before.
+func (w *walker) enter(p string) (string, bool) {
+	if w.res == nil {
+		return "", true
+	}
+	resolved, err := w.res.RealPath(w.ctx, p)
+	if err != nil {
+		w.log.Warn("walk: realpath failed", "dir", p, "err", err)
+		return "", false
+	}
+	if w.dirs[resolved] {
+		w.log.Debug("walk: directory already walked", "dir", p, "resolved", resolved)
+		return "", false
+	}
+	w.dirs[resolved] = true
+	return resolved, true
+}
+
+// follow returns what the link e points to, under the path files are sent
+// with, and its real path; false (after reporting files) when the link is
+// not followed.
+func (w *walker) follow(e remotefs.RemoteEntry) (remotefs.RemoteEntry, string, bool) {
+	if w.res == nil {
+		w.skip(e, ReasonSymlink)
+		return e, "", false
+	}
+	resolved, err := w.res.RealPath(w.ctx, e.Path)
+	if err != nil {
+		w.skip(e, ReasonSymlinkUnresolved)
+		return e, "", false
+	}
+	if resolved != w.base && !strings.HasPrefix(resolved, strings.TrimSuffix(w.base, "/")+"/") {
+		w.skip(e, ReasonSymlinkOutside)
+		return e, "", false
+	}
+	target, err := w.fsys.Stat(w.ctx, w.path(resolved))
+	if err != nil {
+		w.skip(e, ReasonSymlinkUnresolved)
+		return e, "", false
+	}
+	target.Path, target.Name, target.TargetPath = w.path(resolved), path.Base(resolved), resolved
+	return target, resolved, true
+}
+
+// path returns the real path resolved in the form paths are sent in:
+// absolute, or relative to the filesystem root.
+func (w *walker) path(resolved string) string {
+	if w.abs {
+		return resolved
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(resolved, w.base), "/")
+	if rel == "" {
+		return "."
+	}
+	return rel
+}
+
+func (w *walker) skip(e remotefs.RemoteEntry, reason string) {
+	w.log.Debug("walk: skipped", "file", e.Path, "reason", reason)
+	if w.opts.Skipped != nil {
+		w.opts.Skipped(e, reason)
+	}
+}
@@ -0,0 +1,88 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/juparave/photoptim/internal/backup"
+	"github.com/juparave/photoptim/internal/filter"
+	"github.com/juparave/photoptim/internal/optimizer"
+	"github.com/juparave/photoptim/internal/remotefs"
+)
+
+func TestWalk(t *testing.T) {
+	img := genJPEG()
+	fs := remotefs.NewMockFS("/media")
+	fs.PutTestFile("/media/2024/01/a.jpg", img)
+	fs.PutTestFile("/media/2024/02/b.jpg", img)
+	fs.PutTestFile("/media/.cache/c.jpg", img)
+	fs.PutTestFile("/media/notes.txt", []byte("hi"))
+	fs.PutTestSymlink("/media/latest", "2024/02")        // a directory walked anyway
+	fs.PutTestSymlink("/media/2024/01/loop", "/media")   // a cycle
+	fs.PutTestSymlink("/media/top.jpg", "2024/01/a.jpg") // a file sent anyway
+	fs.PutTestSymlink("/media/passwd.jpg", "/etc/passwd.jpg")
+
+	walk := func(opts WalkOptions) ([]string, map[string]string) {
+		skipped := map[string]string{}
+		opts.Skipped = func(e remotefs.RemoteEntry, reason string) { skipped[e.Path] = reason }
+		tasks, done := Walk(context.Background(), fs, "/media", opts)
+		var paths []string
+		for task := range tasks {
+			paths = append(paths, task.Entry.Path)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("%+v: walk: %v", opts, err)
+		}
+		return paths, skipped
+	}
+
+	paths, skipped := walk(WalkOptions{FollowSymlinks: true})
+	if want := []string{"/media/2024/01/a.jpg", "/media/2024/02/b.jpg"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("following links: got %v, want %v", paths, want)
+	}
+	if want := map[string]string{"/media/notes.txt": filter.ReasonFormat, "/media/passwd.jpg": ReasonSymlinkOutside}; !reflect.DeepEqual(skipped, want) {
+		t.Fatalf("following links: skipped %v, want %v", skipped, want)
+	}
+
+	paths, skipped = walk(WalkOptions{})
+	if len(paths) != 2 || skipped["/media/latest"] != ReasonSymlink || skipped["/media/top.jpg"] != ReasonSymlink {
+		t.Fatalf("not following links: got %v, skipped %v", paths, skipped)
+	}
+
+	if paths, _ = walk(WalkOptions{MaxDepth: 2}); len(paths) != 0 {
+		t.Fatalf("MaxDepth 2: got %v, want nothing", paths)
+	}
+	if paths, _ = walk(WalkOptions{Filter: filter.Rules{Hidden: true}}); len(paths) != 3 {
+		t.Fatalf("hidden: got %v, want the .cache file too", paths)
+	}
+	// Backups and uploads in progress are never walked.
+	fs.PutTestFile("/media/"+backup.RemoteDir+"/20240101-000000-1a2b3c/2024/01/a.jpg", img)
+	fs.PutTestFile(remotefs.TempName("/media/2024/01/a.jpg"), img)
+	if paths, _ = walk(WalkOptions{Filter: filter.Rules{Hidden: true}}); len(paths) != 3 {
+		t.Fatalf("hidden with backups: got %v, want the .cache file only", paths)
+	}
+
+	// A root that does not resolve or list is the walk's error.
+	for _, follow := range []bool{false, true} {
+		tasks, done := Walk(context.Background(), fs, "/media/missing", WalkOptions{FollowSymlinks: follow})
+		for range tasks {
+		}
+		if err := <-done; err == nil {
+			t.Fatalf("follow=%v: missing root walked without error", follow)
+		}
+	}
+
+	// A walk feeds a run directly.
+	tasks, walked := Walk(context.Background(), fs, "/media", WalkOptions{})
+	orch := Orchestrator{FS: fs, Opt: optimizer.New(), TinyThreshold: -1}
+	prog, done := orch.RunStream(context.Background(), tasks)
+	for range prog {
+	}
+	if err := <-walked; err != nil {
+		t.Fatal(err)
+	}
+	if sum := <-done; sum.Files != 2 || len(sum.Outcomes) != 2 || sum.NotProcessed != 0 {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+}
@@ -0,0 +1,91 @@
+// Package progress counts streamed bytes and aggregates them into overall
+// percentage, throughput and ETA.
+package progress
+
+import (
+	"io"
+	"time"
+)
+
+// DefaultInterval is how often Reader and Writer report by default.
+const DefaultInterval = 100 * time.Millisecond
+
+// chunk caps the size of a single write so large buffered writes still
+// report progress while they go out.
+const chunk = 32 << 10
+
+// Reader counts the bytes read through it and reports the running total to
+// fn at most once per interval. The first report comes one interval after
+// creation, so short transfers produce none. Not safe for concurrent use.
+type Reader struct {
+	r        io.Reader
+	n        int64
+	fn       func(n int64)
+	interval time.Duration
+	last     time.Time
+}
+
+// NewReader wraps r. interval <= 0 uses DefaultInterval; a nil fn only counts.
+func NewReader(r io.Reader, interval time.Duration, fn func(n int64)) *Reader {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Reader{r: r, fn: fn, interval: interval, last: time.Now()}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if n > 0 && r.fn != nil {
+		if now := time.Now(); now.Sub(r.last) >= r.interval {
+			r.last = now
+			r.fn(r.n)
+		}
+	}
+	return n, err
+}
+
+// N returns the number of bytes read so far.
+func (r *Reader) N() int64 { return r.n }
+
+// Writer counts the bytes written through it and reports the running total
+// like Reader. Writes larger than 32 KiB are split so progress keeps moving.
+type Writer struct {
+	w        io.Writer
+	n        int64
+	fn       func(n int64)
+	interval time.Duration
+	last     time.Time
+}
+
+// NewWriter wraps w. interval <= 0 uses DefaultInterval; a nil fn only counts.
+func NewWriter(w io.Writer, interval time.Duration, fn func(n int64)) *Writer {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Writer{w: w, fn: fn, interval: interval, last: time.Now()}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		k := min(len(p), chunk)
+		n, err := w.w.Write(p[:k])
+		written += n
+		w.n += int64(n)
+		if n > 0 && w.fn != nil {
+			if now := time.Now(); now.Sub(w.last) >= w.interval {
+				w.last = now
+				w.fn(w.n)
+			}
+		}
+		if err != nil {
+			return written, err
+		}
+		p = p[k:]
+	}
+	return written, nil
+}
+
+// N returns the number of bytes written so far.
+func (w *Writer) N() int64 { return w.n }
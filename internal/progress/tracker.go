@@ -0,0 +1,119 @@
+package progress
+
+import (
+	"sync"
+	"time"
+)
+
+// rateWindow is the span throughput is averaged over.
+const rateWindow = 5 * time.Second
+
+// Snapshot is the overall state of a Tracker.
+type Snapshot struct {
+	Done    int64
+	Total   int64
+	Percent float64       // 0-1
+	Rate    float64       // bytes per second over the last few seconds
+	ETA     time.Duration // 0 when unknown
+	Elapsed time.Duration
+}
+
+// Tracker aggregates byte counters ("units", e.g. one per file transfer)
+// into an overall percentage, throughput and ETA. Totals may be estimates
+// and can be corrected while a unit is in flight. Safe for concurrent use.
+type Tracker struct {
+	mu      sync.Mutex
+	start   time.Time
+	units   map[string]*unit
+	samples []sample
+	now     func() time.Time
+}
+
+type unit struct {
+	done, total int64
+	finished    bool
+}
+
+type sample struct {
+	at   time.Time
+	done int64
+}
+
+// NewTracker returns an empty tracker; the clock starts now.
+func NewTracker() *Tracker {
+	return &Tracker{start: time.Now(), units: map[string]*unit{}, now: time.Now}
+}
+
+// Add registers a unit expecting total bytes.
+func (t *Tracker) Add(key string, total int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.units[key] = &unit{total: total}
+}
+
+// SetTotal corrects the expected size of a unit.
+func (t *Tracker) SetTotal(key string, total int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if u := t.unit(key); !u.finished {
+		u.total = total
+	}
+}
+
+// Set records that n bytes of the unit are done. Finished units ignore it.
+func (t *Tracker) Set(key string, n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if u := t.unit(key); !u.finished {
+		u.done = n
+	}
+}
+
+// Finish marks a unit as complete with whatever it transferred: its total
+// becomes its done count. Use it for completed, failed and skipped units.
+func (t *Tracker) Finish(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u := t.unit(key)
+	u.total, u.finished = u.done, true
+}
+
+func (t *Tracker) unit(key string) *unit {
+	u := t.units[key]
+	if u == nil {
+		u = &unit{}
+		t.units[key] = u
+	}
+	return u
+}
+
+// Snapshot returns the current totals, throughput and ETA.
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	s := Snapshot{Elapsed: now.Sub(t.start)}
+	for _, u := range t.units {
+		s.Done += u.done
+		s.Total += max(u.total, u.done)
+	}
+	if s.Total > 0 {
+		s.Percent = float64(s.Done) / float64(s.Total)
+	}
+
+	t.samples = append(t.samples, sample{at: now, done: s.Done})
+	for len(t.samples) > 2 && now.Sub(t.samples[1].at) >= rateWindow {
+		t.samples = t.samples[1:]
+	}
+	first := sample{at: t.start}
+	if len(t.samples) > 1 && now.Sub(t.samples[0].at) >= rateWindow/2 {
+		first = t.samples[0]
+	}
+	if dt := now.Sub(first.at).Seconds(); dt > 0 {
+		s.Rate = float64(s.Done-first.done) / dt
+	}
+	if s.Rate > 0 && s.Total > s.Done {
+		s.ETA = time.Duration(float64(s.Total-s.Done) / s.Rate * float64(time.Second))
+	}
+	return s
+}
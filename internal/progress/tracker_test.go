@@ -0,0 +1,33 @@
+package progress
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackerSnapshot(t *testing.T) {
+	tr := NewTracker()
+	clock := tr.start
+	tr.now = func() time.Time { return clock }
+	tr.Add("a", 100)
+	tr.Add("b", 300)
+
+	clock = clock.Add(2 * time.Second)
+	tr.Set("a", 100)
+	tr.Finish("a")
+	tr.Set("b", 100)
+	s := tr.Snapshot()
+	if s.Done != 200 || s.Total != 400 || s.Percent != 0.5 {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+	if s.Rate != 100 || s.ETA != 2*time.Second {
+		t.Fatalf("rate %v ETA %v, want 100 B/s and 2s", s.Rate, s.ETA)
+	}
+
+	// A unit that stops early no longer counts its missing bytes.
+	tr.Finish("b")
+	tr.Set("b", 250) // ignored once finished
+	if s := tr.Snapshot(); s.Done != 200 || s.Total != 200 || s.Percent != 1 || s.ETA != 0 {
+		t.Fatalf("after finish: %+v", s)
+	}
+}
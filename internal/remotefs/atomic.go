@@ -0,0 +1,199 @@
+package remotefs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrVerify is wrapped by AtomicFile.Commit when the uploaded temporary file
+// does not match what was written. It is transient: uploading again may fix it.
+var ErrVerify = errors.New("upload verification failed")
+
+// CommitOptions control how an AtomicFile replaces its target.
+type CommitOptions struct {
+	Mode       fs.FileMode // permission bits for the new file; 0 = server default
+	ModTime    time.Time   // modification time for the new file; zero = time of upload
+	VerifyHash bool        // read the temporary file back and compare its SHA-256
+	// Owner sets UID and GID on the new file where the server permits it;
+	// a refused chown leaves the uploading user as owner (see OwnerDenied).
+	Owner    bool
+	UID, GID int
+}
+
+// Preserve returns options that give the new file e's mode, mtime and, if
+// known, owner.
+func Preserve(e RemoteEntry) CommitOptions {
+	return CommitOptions{Mode: e.Mode, ModTime: e.ModTime, Owner: e.HasOwner, UID: e.UID, GID: e.GID}
+}
+
+// AtomicFile is written under a hidden temporary name next to its target and
+// only renamed over the target by Commit, so an interrupted upload never
+// leaves a truncated file at the target path.
+type AtomicFile struct {
+	fs        RemoteFS
+	path, tmp string
+	w         io.WriteCloser
+	sum       hash.Hash
+	n         int64
+	closed    bool
+	done      bool // committed or discarded
+	denied    bool // the server refused the chown
+	kept      bool // the target went missing: tmp holds the only copy
+}
+
+// CreateAtomic creates the temporary file for replacing target on fsys.
+func CreateAtomic(ctx context.Context, fsys RemoteFS, target string) (*AtomicFile, error) {
+	tmp := TempName(target)
+	w, err := fsys.Create(ctx, tmp, false)
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicFile{fs: fsys, path: target, tmp: tmp, w: w, sum: sha256.New()}, nil
+}
+
+// TempName returns a hidden, randomized name in target's directory, e.g.
+// "photos/.beach.jpg.photoptim-1a2b3c4d.tmp".
+func TempName(target string) string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	dir, name := path.Split(target)
+	return dir + "." + name + ".photoptim-" + hex.EncodeToString(b[:]) + ".tmp"
+}
+
+// IsTempName reports whether name is a file name TempName returns.
+func IsTempName(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".tmp") && strings.Contains(name, ".photoptim-")
+}
+
+func (a *AtomicFile) Write(p []byte) (int, error) {
+	n, err := a.w.Write(p)
+	a.sum.Write(p[:n])
+	a.n += int64(n)
+	return n, err
+}
+
+// Commit closes the temporary file, checks its size (and hash with
+// opts.VerifyHash), applies mode and mtime, and renames it over the target.
+// On failure the temporary file is removed and the target left untouched,
+// except when the failed rename lost a target that existed: then the
+// temporary file is kept, as the only copy, and the error names it (see
+// Kept).
+func (a *AtomicFile) Commit(ctx context.Context, opts CommitOptions) error {
+	if a.done {
+		return errors.New("atomic file already closed")
+	}
+	err := a.commit(ctx, opts)
+	if err != nil {
+		if a.kept {
+			a.done = true
+			return err
+		}
+		_ = a.Close()
+		return err
+	}
+	a.done = true
+	return nil
+}
+
+func (a *AtomicFile) commit(ctx context.Context, opts CommitOptions) error {
+	a.closed = true
+	if err := a.w.Close(); err != nil {
+		return err
+	}
+	e, err := a.fs.Stat(ctx, a.tmp)
+	if err != nil {
+		return err
+	}
+	if e.Size != a.n {
+		return fmt.Errorf("%w: %s is %d bytes, wrote %d", ErrVerify, a.tmp, e.Size, a.n)
+	}
+	if opts.VerifyHash {
+		if err := a.verifyHash(ctx); err != nil {
+			return err
+		}
+	}
+	// Chown first: it may clear setuid and setgid bits.
+	if opts.Owner && e.HasOwner && (e.UID != opts.UID || e.GID != opts.GID) {
+		err := a.fs.Chown(ctx, a.tmp, opts.UID, opts.GID)
+		switch {
+		case errors.Is(err, fs.ErrPermission):
+			a.denied = true
+		case err != nil:
+			return fmt.Errorf("chown: %w", err)
+		}
+	}
+	if opts.Mode != 0 {
+		if err := a.fs.Chmod(ctx, a.tmp, opts.Mode.Perm()); err != nil {
+			return fmt.Errorf("chmod: %w", err)
+		}
+	}
+	if !opts.ModTime.IsZero() {
+		if err := a.fs.Chtimes(ctx, a.tmp, time.Now(), opts.ModTime); err != nil {
+			return fmt.Errorf("chtimes: %w", err)
+		}
+	}
+	_, statErr := a.fs.Stat(ctx, a.path)
+	existed := statErr == nil
+	if err := a.fs.Rename(ctx, a.tmp, a.path); err != nil {
+		if _, serr := a.fs.Stat(ctx, a.path); existed && errors.Is(serr, fs.ErrNotExist) {
+			a.kept = true
+			return fmt.Errorf("rename: %w; %s is gone, its new content is kept at %s", err, a.path, a.tmp)
+		}
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+// Kept returns the temporary file's path when Commit failed after the
+// target went missing, so the file must be recovered from there; "" else.
+func (a *AtomicFile) Kept() string {
+	if a.kept {
+		return a.tmp
+	}
+	return ""
+}
+
+// OwnerDenied reports whether Commit could not keep the owner because the
+// server does not allow the chown.
+func (a *AtomicFile) OwnerDenied() bool { return a.denied }
+
+func (a *AtomicFile) verifyHash(ctx context.Context) error {
+	rc, _, err := a.fs.Open(ctx, a.tmp)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), a.sum.Sum(nil)) {
+		return fmt.Errorf("%w: %s has a different SHA-256", ErrVerify, a.tmp)
+	}
+	return nil
+}
+
+// Close discards the temporary file unless Commit succeeded or kept it; it
+// is safe to defer.
+func (a *AtomicFile) Close() error {
+	if a.done {
+		return nil
+	}
+	a.done = true
+	if !a.closed {
+		a.closed = true
+		_ = a.w.Close()
+	}
+	return a.fs.Remove(context.Background(), a.tmp)
+}
@@ -0,0 +1,88 @@
+package remotefs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAtomicFile(t *testing.T) {
+	ctx := context.Background()
+	mock := NewMockFS("/")
+	mock.PutTestFile("/a.jpg", []byte("original"))
+
+	// Discarding leaves the target alone and removes the temp file.
+	af, err := CreateAtomic(ctx, mock, "/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = af.Write([]byte("partial"))
+	_ = af.Close()
+	if e, err := mock.Stat(ctx, "/a.jpg"); err != nil || e.Size != int64(len("original")) {
+		t.Fatalf("target after discard: %+v, %v", e, err)
+	}
+	if _, err := mock.Stat(ctx, af.tmp); err == nil {
+		t.Fatal("temp file left behind")
+	}
+
+	af, err = CreateAtomic(ctx, mock, "/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = af.Write([]byte("new"))
+	if err := af.Commit(ctx, CommitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_ = af.Close()
+	if e, err := mock.Stat(ctx, "/a.jpg"); err != nil || e.Size != 3 {
+		t.Fatalf("target after commit: %+v, %v", e, err)
+	}
+	if entries, _ := mock.List(ctx, "/"); len(entries) != 1 {
+		t.Fatalf("unexpected files %+v", entries)
+	}
+}
+
+// lossyRenameFS removes the target of a rename and then fails, like a
+// non-atomic rename interrupted halfway.
+type lossyRenameFS struct{ *MockFS }
+
+func (l lossyRenameFS) Rename(ctx context.Context, oldpath, newpath string) error {
+	_ = l.Remove(ctx, newpath)
+	return errors.New("connection lost")
+}
+
+func TestAtomicFileKeepsTempWhenTargetLost(t *testing.T) {
+	ctx := context.Background()
+	mock := NewMockFS("/")
+	mock.PutTestFile("/a.jpg", []byte("original"))
+
+	// A failed rename that leaves the target alone discards the temp file.
+	mock.FailTestOp("rename", "/.a.jpg.*.tmp", 1, errors.New("refused"))
+	af, err := CreateAtomic(ctx, mock, "/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = af.Write([]byte("new"))
+	if err := af.Commit(ctx, CommitOptions{}); err == nil || af.Kept() != "" {
+		t.Fatalf("commit: err %v, kept %q", err, af.Kept())
+	}
+	if _, err := mock.Stat(ctx, af.tmp); err == nil {
+		t.Fatal("temp file left behind")
+	}
+
+	lossy := lossyRenameFS{mock}
+	af, err = CreateAtomic(ctx, lossy, "/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = af.Write([]byte("new"))
+	err = af.Commit(ctx, CommitOptions{})
+	if err == nil || af.Kept() == "" || !strings.Contains(err.Error(), af.Kept()) {
+		t.Fatalf("commit: err %v, kept %q", err, af.Kept())
+	}
+	_ = af.Close()
+	if e, err := mock.Stat(ctx, af.Kept()); err != nil || e.Size != 3 {
+		t.Fatalf("kept temp file: %+v, %v", e, err)
+	}
+}
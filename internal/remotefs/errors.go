@@ -0,0 +1,45 @@
+package remotefs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"syscall"
+)
+
+// ErrConnectionLost is wrapped by implementations around errors caused by a
+// dropped session, so callers can tell them from errors about the file.
+var ErrConnectionLost = errors.New("remote connection lost")
+
+// Reconnector is implemented by RemoteFS implementations that can replace a
+// dead session with a new one using the original ConnectionConfig.
+type Reconnector interface {
+	// Reconnect re-establishes the session unless it is still alive. It is
+	// safe to call concurrently with other operations.
+	Reconnect(ctx context.Context) error
+}
+
+// IsTransient reports whether err may go away if the operation is retried:
+// lost connections, resets, timeouts, truncated transfers and uploads that
+// failed verification. Missing files, permission errors and cancellations
+// are permanent.
+func IsTransient(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrPermission), errors.Is(err, fs.ErrExist):
+		return false
+	case errors.Is(err, ErrConnectionLost), errors.Is(err, ErrVerify), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+		return true
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE), errors.Is(err, syscall.ETIMEDOUT), errors.Is(err, syscall.EHOSTUNREACH),
+		errors.Is(err, syscall.ENETUNREACH):
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
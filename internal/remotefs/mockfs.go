@@ -3,26 +3,53 @@ package remotefs
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"io/fs"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
 
 type MockFS struct {
-	root  string
-	mu    sync.Mutex
-	files map[string]*mockFile
+	root       string
+	mu         sync.Mutex
+	files      map[string]*mockFile
+	dirs       map[string]bool // created by MkdirAll; parents of files are implied
+	links      map[string]string
+	faults     []*mockFault
+	reconnects int
+}
+
+// mockFault fails the next n calls of op on paths matching pattern.
+type mockFault struct {
+	op, pattern string
+	n           int
+	err         error
 }
 
 type mockFile struct {
-	data    []byte
-	mode    fs.FileMode
-	modTime time.Time
+	data     []byte
+	mode     fs.FileMode
+	modTime  time.Time
+	uid, gid int
 }
 
-func NewMockFS(root string) *MockFS                                       { return &MockFS{root: root, files: map[string]*mockFile{}} }
+// MockUID and MockGID own the files MockFS creates, as if they were the
+// connected user's.
+const (
+	MockUID = 1000
+	MockGID = 1000
+)
+
+func (f *mockFile) entry(path string) RemoteEntry {
+	return RemoteEntry{Path: path, Name: filepath.Base(path), Size: int64(len(f.data)), Mode: f.mode, ModTime: f.modTime, UID: f.uid, GID: f.gid, HasOwner: true}
+}
+
+func NewMockFS(root string) *MockFS {
+	return &MockFS{root: root, files: map[string]*mockFile{}, dirs: map[string]bool{}, links: map[string]string{}}
+}
 func (m *MockFS) Connect(ctx context.Context, cfg ConnectionConfig) error { return nil }
 func (m *MockFS) Close() error                                            { return nil }
 func (m *MockFS) Root() string                                            { return m.root }
@@ -31,28 +58,80 @@ func (m *MockFS) Join(elem ...string) string                              { retu
 func (m *MockFS) put(path string, data []byte) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.files[path] = &mockFile{data: append([]byte(nil), data...), mode: 0o644, modTime: time.Now()}
+	m.files[path] = &mockFile{data: append([]byte(nil), data...), mode: 0o644, modTime: time.Now(), uid: MockUID, gid: MockGID}
 }
 
+// List returns the files directly in path and its subdirectories.
 func (m *MockFS) List(ctx context.Context, path string) ([]RemoteEntry, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	out := []RemoteEntry{}
+	seen := map[string]bool{}
+	// addDir lists the ancestor of d (or d) that sits directly in path.
+	addDir := func(d string) {
+		for ; d != "." && d != "/"; d = filepath.Dir(d) {
+			if filepath.Dir(d) != path {
+				continue
+			}
+			if !seen[d] {
+				seen[d] = true
+				out = append(out, RemoteEntry{Path: d, Name: filepath.Base(d), Mode: fs.ModeDir | 0o755, IsDir: true})
+			}
+			return
+		}
+	}
 	for p, f := range m.files {
 		if filepath.Dir(p) == path {
-			out = append(out, RemoteEntry{Path: p, Name: filepath.Base(p), Size: int64(len(f.data)), Mode: f.mode, ModTime: f.modTime})
+			out = append(out, f.entry(p))
+		} else {
+			addDir(filepath.Dir(p))
 		}
 	}
+	for d := range m.dirs {
+		addDir(d)
+	}
+	for l, target := range m.links {
+		if filepath.Dir(l) == path {
+			out = append(out, RemoteEntry{Path: l, Name: filepath.Base(l), Mode: fs.ModeSymlink | 0o777, Symlink: true, TargetPath: target})
+		} else {
+			addDir(filepath.Dir(l))
+		}
+	}
+	if len(out) == 0 && path != "." && path != "/" && path != m.root && !m.isDir(path) {
+		return nil, fs.ErrNotExist
+	}
 	return out, nil
 }
+
 func (m *MockFS) Stat(ctx context.Context, path string) (RemoteEntry, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	f := m.files[path]
 	if f == nil {
+		if m.isDir(path) {
+			return RemoteEntry{Path: path, Name: filepath.Base(path), Mode: fs.ModeDir | 0o755, IsDir: true}, nil
+		}
 		return RemoteEntry{}, fs.ErrNotExist
 	}
-	return RemoteEntry{Path: path, Name: filepath.Base(path), Size: int64(len(f.data)), Mode: f.mode, ModTime: f.modTime}, nil
+	return f.entry(path), nil
+}
+
+// isDir reports whether path was created by MkdirAll or holds files.
+func (m *MockFS) isDir(path string) bool {
+	if m.dirs[path] {
+		return true
+	}
+	for p := range m.files {
+		for d := filepath.Dir(p); ; d = filepath.Dir(d) {
+			if d == path {
+				return true
+			}
+			if d == "." || d == "/" {
+				break
+			}
+		}
+	}
+	return false
 }
 
 type nopCloser struct{ *bytes.Reader }
@@ -60,26 +139,220 @@ type nopCloser struct{ *bytes.Reader }
 func (n nopCloser) Close() error { return nil }
 
 func (m *MockFS) Open(ctx context.Context, path string) (io.ReadCloser, RemoteEntry, error) {
+	if err := m.fault("open", path); err != nil {
+		return nil, RemoteEntry{}, err
+	}
 	m.mu.Lock()
 	f := m.files[path]
 	m.mu.Unlock()
 	if f == nil {
 		return nil, RemoteEntry{}, fs.ErrNotExist
 	}
-	r := nopCloser{bytes.NewReader(f.data)}
-	e := RemoteEntry{Path: path, Name: filepath.Base(path), Size: int64(len(f.data)), Mode: f.mode, ModTime: f.modTime}
-	return r, e, nil
+	var r io.ReadCloser = nopCloser{bytes.NewReader(f.data)}
+	if err := m.fault("read", path); err != nil {
+		r = &failingReader{r: r, after: int64(len(f.data) / 2), err: err}
+	}
+	return r, f.entry(path), nil
+}
+
+// failingReader returns err once after bytes have been read.
+type failingReader struct {
+	r     io.ReadCloser
+	after int64
+	err   error
 }
 
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.after <= 0 {
+		return 0, f.err
+	}
+	if int64(len(p)) > f.after {
+		p = p[:f.after]
+	}
+	n, err := f.r.Read(p)
+	f.after -= int64(n)
+	return n, err
+}
+
+func (f *failingReader) Close() error { return f.r.Close() }
+
 type writeBuffer struct {
 	bytes.Buffer
 	commit func([]byte)
+	err    error // returned by Write; the file is then never committed
 }
 
-func (w *writeBuffer) Close() error { w.commit(w.Bytes()); return nil }
+func (w *writeBuffer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *writeBuffer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.commit(w.Bytes())
+	return nil
+}
 
 func (m *MockFS) Create(ctx context.Context, path string, overwrite bool) (io.WriteCloser, error) {
-	return &writeBuffer{commit: func(b []byte) { m.put(path, b) }}, nil
+	if err := m.fault("create", path); err != nil {
+		return nil, err
+	}
+	return &writeBuffer{commit: func(b []byte) { m.put(path, b) }, err: m.fault("write", path)}, nil
+}
+
+func (m *MockFS) Rename(ctx context.Context, oldpath, newpath string) error {
+	if err := m.fault("rename", oldpath); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.files[oldpath]
+	if f == nil {
+		return fs.ErrNotExist
+	}
+	delete(m.files, oldpath)
+	m.files[newpath] = f
+	return nil
+}
+
+func (m *MockFS) Remove(ctx context.Context, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files[path] == nil {
+		return fs.ErrNotExist
+	}
+	delete(m.files, path)
+	return nil
+}
+
+func (m *MockFS) MkdirAll(ctx context.Context, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for d := filepath.Clean(path); d != "." && d != "/"; d = filepath.Dir(d) {
+		m.dirs[d] = true
+	}
+	return nil
+}
+
+func (m *MockFS) Chmod(ctx context.Context, path string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.files[path]
+	if f == nil {
+		return fs.ErrNotExist
+	}
+	f.mode = mode
+	return nil
+}
+
+func (m *MockFS) Chtimes(ctx context.Context, path string, atime, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.files[path]
+	if f == nil {
+		return fs.ErrNotExist
+	}
+	f.modTime = mtime
+	return nil
+}
+
+func (m *MockFS) Chown(ctx context.Context, path string, uid, gid int) error {
+	if err := m.fault("chown", path); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.files[path]
+	if f == nil {
+		return fs.ErrNotExist
+	}
+	f.uid, f.gid = uid, gid
+	return nil
+}
+
+// PutTestSymlink makes link a symbolic link to target, which is absolute or
+// relative to link's directory.
+func (m *MockFS) PutTestSymlink(link, target string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.links[link] = target
+}
+
+// RealPath resolves the symlinks in p; relative paths are taken from the
+// root.
+func (m *MockFS) RealPath(ctx context.Context, p string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(m.root, p)
+	}
+	p = filepath.Clean(p)
+	for hops := 0; ; hops++ {
+		if hops > 40 {
+			return "", fmt.Errorf("realpath %s: too many levels of symbolic links", p)
+		}
+		resolved := false
+		// Replace the shortest linked prefix of p with its target.
+		parts := strings.Split(p, "/")
+		for i := 2; i <= len(parts); i++ {
+			prefix := strings.Join(parts[:i], "/")
+			target, ok := m.links[prefix]
+			if !ok {
+				continue
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(prefix), target)
+			}
+			p = filepath.Join(append([]string{target}, parts[i:]...)...)
+			resolved = true
+			break
+		}
+		if !resolved {
+			return p, nil
+		}
+	}
+}
+
+// Reconnect counts reconnections; see Reconnects.
+func (m *MockFS) Reconnect(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reconnects++
+	return nil
+}
+
+// Reconnects returns how often Reconnect was called.
+func (m *MockFS) Reconnects() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reconnects
+}
+
+// FailTestOp makes the next n calls of op on paths matching pattern (see
+// filepath.Match) fail with err. op is "open", "read" (the opened file fails
+// halfway), "create", "write" (the created file fails on every write),
+// "rename" (matched against the old path) or "chown".
+func (m *MockFS) FailTestOp(op, pattern string, n int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.faults = append(m.faults, &mockFault{op: op, pattern: pattern, n: n, err: err})
+}
+
+// fault consumes one pending failure for op on path.
+func (m *MockFS) fault(op, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.faults {
+		if ok, _ := filepath.Match(f.pattern, path); ok && f.op == op && f.n > 0 {
+			f.n--
+			return f.err
+		}
+	}
+	return nil
 }
 
 // Test helper
@@ -27,6 +27,8 @@ type RemoteEntry struct {
 	IsDir      bool
 	Symlink    bool
 	TargetPath string // resolved target if symlink (within chroot)
+	UID, GID   int    // owner, when HasOwner
+	HasOwner   bool
 }
 
 // RemoteFS is a protocol‑agnostic filesystem abstraction.
@@ -37,6 +39,23 @@ type RemoteFS interface {
 	Stat(ctx context.Context, path string) (RemoteEntry, error)
 	Open(ctx context.Context, path string) (io.ReadCloser, RemoteEntry, error)
 	Create(ctx context.Context, path string, overwrite bool) (io.WriteCloser, error)
+	// Rename moves oldpath to newpath, replacing newpath atomically where the
+	// protocol allows it.
+	Rename(ctx context.Context, oldpath, newpath string) error
+	Remove(ctx context.Context, path string) error
+	MkdirAll(ctx context.Context, path string) error
+	Chmod(ctx context.Context, path string, mode fs.FileMode) error
+	Chtimes(ctx context.Context, path string, atime, mtime time.Time) error
+	// Chown fails with fs.ErrPermission where the server does not allow it.
+	Chown(ctx context.Context, path string, uid, gid int) error
 	Join(elem ...string) string
 	Root() string
 }
+
+// Resolver is implemented by RemoteFS implementations that can resolve
+// symbolic links.
+type Resolver interface {
+	// RealPath returns the absolute path of p with every symlink in it
+	// resolved.
+	RealPath(ctx context.Context, p string) (string, error)
+}
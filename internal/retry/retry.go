@@ -0,0 +1,77 @@
+// Package retry re-runs operations that failed for transient reasons, waiting
+// with exponential backoff between attempts.
+package retry
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Policy describes how often and how long to wait before retrying.
+type Policy struct {
+	Retries int           // retries after the first attempt; <0 disables retrying
+	Base    time.Duration // wait before the first retry; doubled for each further retry
+	Max     time.Duration // cap on a single wait; 0 = none
+}
+
+// Default retries four times, after 250ms, 500ms, 1s and 2s.
+var Default = Policy{Retries: 4, Base: 250 * time.Millisecond, Max: 2 * time.Second}
+
+// Delay returns the wait before retry n (1-based).
+func (p Policy) Delay(n int) time.Duration {
+	d := p.Base
+	for i := 1; i < n && (p.Max <= 0 || d < p.Max); i++ {
+		d *= 2
+	}
+	if p.Max > 0 && d > p.Max {
+		d = p.Max
+	}
+	return d
+}
+
+// stopError marks an error that must not be retried.
+type stopError struct{ err error }
+
+func (s stopError) Error() string { return s.err.Error() }
+func (s stopError) Unwrap() error { return s.err }
+
+// Stop wraps err so Do returns it without retrying, whatever its class.
+func Stop(err error) error {
+	if err == nil {
+		return nil
+	}
+	return stopError{err}
+}
+
+// Do calls fn until it succeeds, returns an error for which transient is
+// false, or the retries are used up. onRetry (optional) is called with the
+// retry number, the error and the wait before each retry. Do returns the
+// number of retries made and fn's last error; a context cancelled while
+// waiting ends the loop with the context's error.
+func (p Policy) Do(ctx context.Context, fn func() error, transient func(error) bool, onRetry func(n int, err error, wait time.Duration)) (int, error) {
+	for n := 0; ; n++ {
+		err := fn()
+		if err == nil {
+			return n, nil
+		}
+		var stop stopError
+		if errors.As(err, &stop) {
+			return n, stop.err
+		}
+		if n >= p.Retries || !transient(err) || ctx.Err() != nil {
+			return n, err
+		}
+		wait := p.Delay(n + 1)
+		if onRetry != nil {
+			onRetry(n+1, err, wait)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return n, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDefaultDelays(t *testing.T) {
+	want := []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, time.Second, 2 * time.Second, 2 * time.Second}
+	for i, w := range want {
+		if got := Default.Delay(i + 1); got != w {
+			t.Errorf("Delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	errFlaky, errFatal := errors.New("flaky"), errors.New("fatal")
+	transient := func(err error) bool { return errors.Is(err, errFlaky) }
+	p := Policy{Retries: 3, Base: time.Millisecond}
+
+	calls := 0
+	n, err := p.Do(context.Background(), func() error {
+		if calls++; calls < 3 {
+			return errFlaky
+		}
+		return nil
+	}, transient, nil)
+	if err != nil || n != 2 {
+		t.Fatalf("flaky: retries=%d err=%v, want 2 <nil>", n, err)
+	}
+
+	calls = 0
+	n, err = p.Do(context.Background(), func() error { calls++; return errFlaky }, transient, nil)
+	if !errors.Is(err, errFlaky) || n != 3 || calls != 4 {
+		t.Fatalf("exhausted: retries=%d calls=%d err=%v", n, calls, err)
+	}
+
+	calls = 0
+	n, err = p.Do(context.Background(), func() error { calls++; return errFatal }, transient, nil)
+	if !errors.Is(err, errFatal) || n != 0 || calls != 1 {
+		t.Fatalf("permanent: retries=%d calls=%d err=%v", n, calls, err)
+	}
+
+	n, err = p.Do(context.Background(), func() error { return Stop(errFlaky) }, transient, nil)
+	if err != errFlaky || n != 0 {
+		t.Fatalf("stop: retries=%d err=%v", n, err)
+	}
+}
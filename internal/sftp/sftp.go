@@ -5,38 +5,105 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	pkgsftp "github.com/pkg/sftp"
 	gossh "golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 
+	"github.com/juparave/photoptim/internal/logging"
 	"github.com/juparave/photoptim/internal/remotefs"
 )
 
-// Client implements remotefs.RemoteFS over SFTP.
+// Client implements remotefs.RemoteFS over SFTP. Errors caused by a dropped
+// session wrap remotefs.ErrConnectionLost; Reconnect replaces the session.
 type Client struct {
-	cfg        remotefs.ConnectionConfig
+	cfg remotefs.ConnectionConfig
+
+	mu         sync.RWMutex // guards the session; root is fixed by Connect
 	sshClient  *gossh.Client
 	sftpClient *pkgsftp.Client
 	root       string
+
+	// Logger receives connection and authentication details; nil = silent.
+	Logger *slog.Logger
 }
 
 // Connect establishes an SFTP session.
 func (c *Client) Connect(ctx context.Context, cfg remotefs.ConnectionConfig) error {
 	c.cfg = cfg
+	sshClient, s, err := c.dial(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	// Determine chroot: user-specified path OR user's home (working directory) by default
+	wd, wdErr := s.Getwd()
+	root := cfg.RemotePath
+	if root == "" || root == "." {
+		if wdErr == nil && wd != "" {
+			root = wd
+		} else {
+			root = "/"
+		}
+	}
+	c.mu.Lock()
+	c.sshClient, c.sftpClient, c.root = sshClient, s, root
+	c.mu.Unlock()
+	c.log().Info("sftp root", "root", root)
+	return nil
+}
+
+// Reconnect replaces the session with a new one if the current one no
+// longer answers. Concurrent callers share one reconnection; the root found
+// by Connect is kept.
+func (c *Client) Reconnect(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sftpClient != nil {
+		if _, err := c.sftpClient.Getwd(); err == nil {
+			return nil
+		}
+		_ = c.sftpClient.Close()
+		_ = c.sshClient.Close()
+	}
+	c.log().Info("sftp reconnect", "host", c.cfg.Host)
+	sshClient, s, err := c.dial(ctx, c.cfg)
+	if err != nil {
+		return err
+	}
+	c.sshClient, c.sftpClient = sshClient, s
+	return nil
+}
+
+// dial opens an SSH connection and an SFTP session on it.
+func (c *Client) dial(ctx context.Context, cfg remotefs.ConnectionConfig) (*gossh.Client, *pkgsftp.Client, error) {
 	if cfg.Port == 0 {
 		cfg.Port = 22
 	}
+	log := c.log().With("host", cfg.Host, "port", cfg.Port, "user", cfg.User)
+	start := time.Now()
+	log.Info("sftp connect")
 
-	authMethods, err := buildAuth(cfg)
+	// lastAuth records the method the server asked for last; once the
+	// handshake succeeds that is the method that authenticated us.
+	var lastAuth string
+	authMethods, names, err := buildAuth(cfg, func(name string) {
+		lastAuth = name
+		log.Debug("ssh auth attempt", "method", name)
+	})
 	if err != nil {
-		return err
+		log.Warn("sftp connect failed", "err", err)
+		return nil, nil, err
 	}
+	log.Debug("ssh auth methods available", "methods", names)
 	sshConfig := &gossh.ClientConfig{
 		User:            cfg.User,
 		Auth:            authMethods,
@@ -48,36 +115,40 @@ func (c *Client) Connect(ctx context.Context, cfg remotefs.ConnectionConfig) err
 	d := net.Dialer{}
 	conn, err := d.DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return fmt.Errorf("dial: %w", err)
+		log.Warn("sftp connect failed", "stage", "dial", "err", err)
+		return nil, nil, fmt.Errorf("dial: %w", err)
 	}
 
 	sshConn, chans, reqs, err := gossh.NewClientConn(conn, addr, sshConfig)
 	if err != nil {
-		return fmt.Errorf("ssh handshake: %w", err)
+		log.Warn("sftp connect failed", "stage", "ssh-handshake", "err", err)
+		return nil, nil, fmt.Errorf("ssh handshake: %w", err)
 	}
-	c.sshClient = gossh.NewClient(sshConn, chans, reqs)
+	log.Info("ssh authenticated", "method", lastAuth)
+	sshClient := gossh.NewClient(sshConn, chans, reqs)
 
-	s, err := pkgsftp.NewClient(c.sshClient)
+	s, err := pkgsftp.NewClient(sshClient)
 	if err != nil {
-		return fmt.Errorf("new sftp: %w", err)
+		_ = sshClient.Close()
+		log.Warn("sftp connect failed", "stage", "sftp-session", "err", err)
+		return nil, nil, fmt.Errorf("new sftp: %w", err)
 	}
-	c.sftpClient = s
+	log.Info("sftp connected", "duration", time.Since(start))
+	return sshClient, s, nil
+}
 
-	// Determine chroot: user-specified path OR user's home (working directory) by default
-	wd, wdErr := s.Getwd()
-	root := cfg.RemotePath
-	if root == "" || root == "." {
-		if wdErr == nil && wd != "" {
-			root = wd
-		} else {
-			root = "/"
-		}
-	}
-	c.root = root
-	return nil
+func (c *Client) log() *slog.Logger { return logging.OrDiscard(c.Logger) }
+
+// session returns the current SFTP session.
+func (c *Client) session() *pkgsftp.Client {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sftpClient
 }
 
 func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.sftpClient != nil {
 		_ = c.sftpClient.Close()
 	}
@@ -89,51 +160,132 @@ func (c *Client) Close() error {
 
 func (c *Client) List(ctx context.Context, path string) ([]remotefs.RemoteEntry, error) {
 	p := c.abs(path)
-	fis, err := c.sftpClient.ReadDir(p)
+	fis, err := c.session().ReadDir(p)
 	if err != nil {
-		return nil, err
+		return nil, wrapErr(err)
 	}
 	out := make([]remotefs.RemoteEntry, 0, len(fis))
 	for _, fi := range fis {
-		e := remotefs.RemoteEntry{
-			Path:    filepath.Join(path, fi.Name()),
-			Name:    fi.Name(),
-			Size:    fi.Size(),
-			Mode:    fi.Mode(),
-			ModTime: fi.ModTime(),
-			IsDir:   fi.IsDir(),
-		}
-		out = append(out, e)
+		out = append(out, toEntry(filepath.Join(path, fi.Name()), fi))
 	}
 	return out, nil
 }
 
 func (c *Client) Stat(ctx context.Context, path string) (remotefs.RemoteEntry, error) {
-	fi, err := c.sftpClient.Stat(c.abs(path))
+	fi, err := c.session().Stat(c.abs(path))
 	if err != nil {
-		return remotefs.RemoteEntry{}, err
+		return remotefs.RemoteEntry{}, wrapErr(err)
 	}
-	return remotefs.RemoteEntry{Path: path, Name: filepath.Base(path), Size: fi.Size(), Mode: fi.Mode(), ModTime: fi.ModTime(), IsDir: fi.IsDir()}, nil
+	return toEntry(path, fi), nil
+}
+
+// toEntry describes the file at path, with its owner where the server
+// reports one.
+func toEntry(path string, fi fs.FileInfo) remotefs.RemoteEntry {
+	e := remotefs.RemoteEntry{Path: path, Name: filepath.Base(path), Size: fi.Size(), Mode: fi.Mode(), ModTime: fi.ModTime(), IsDir: fi.IsDir(), Symlink: fi.Mode()&fs.ModeSymlink != 0}
+	if st, ok := fi.Sys().(*pkgsftp.FileStat); ok {
+		e.UID, e.GID, e.HasOwner = int(st.UID), int(st.GID), true
+	}
+	return e
 }
 
 func (c *Client) Open(ctx context.Context, path string) ( /*nolint:ireturn*/ io.ReadCloser, remotefs.RemoteEntry, error) {
-	f, err := c.sftpClient.Open(c.abs(path))
+	f, err := c.session().Open(c.abs(path))
+	if err != nil {
+		return nil, remotefs.RemoteEntry{}, wrapErr(err)
+	}
+	fi, err := f.Stat()
 	if err != nil {
-		return nil, remotefs.RemoteEntry{}, err
+		_ = f.Close()
+		return nil, remotefs.RemoteEntry{}, wrapErr(err)
 	}
-	fi, _ := f.Stat()
-	entry := remotefs.RemoteEntry{Path: path, Name: filepath.Base(path), Size: fi.Size(), Mode: fi.Mode(), ModTime: fi.ModTime(), IsDir: fi.IsDir()}
-	return f, entry, nil
+	return file{f}, toEntry(path, fi), nil
 }
 
 func (c *Client) Create(ctx context.Context, path string, overwrite bool) ( /*nolint:ireturn*/ io.WriteCloser, error) {
 	full := c.abs(path)
+	s := c.session()
 	if !overwrite {
-		if _, err := c.sftpClient.Stat(full); err == nil {
+		if _, err := s.Stat(full); err == nil {
 			return nil, errors.New("file exists")
 		}
 	}
-	return c.sftpClient.Create(full)
+	f, err := s.Create(full)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return file{f}, nil
+}
+
+// Rename uses the posix-rename@openssh.com extension, which replaces newpath
+// atomically. Servers without it only get a plain SFTP rename, which most of
+// them refuse when newpath exists; newpath is never removed first, so a
+// failed replace leaves it as it was.
+func (c *Client) Rename(ctx context.Context, oldpath, newpath string) error {
+	s := c.session()
+	from, to := c.abs(oldpath), c.abs(newpath)
+	if _, ok := s.HasExtension("posix-rename@openssh.com"); ok {
+		return wrapErr(s.PosixRename(from, to))
+	}
+	if err := s.Rename(from, to); err != nil {
+		return fmt.Errorf("rename without posix-rename@openssh.com: %w", wrapErr(err))
+	}
+	return nil
+}
+
+func (c *Client) Remove(ctx context.Context, path string) error {
+	return wrapErr(c.session().Remove(c.abs(path)))
+}
+
+func (c *Client) MkdirAll(ctx context.Context, path string) error {
+	return wrapErr(c.session().MkdirAll(c.abs(path)))
+}
+
+func (c *Client) Chmod(ctx context.Context, path string, mode fs.FileMode) error {
+	return wrapErr(c.session().Chmod(c.abs(path), mode))
+}
+
+func (c *Client) Chtimes(ctx context.Context, path string, atime, mtime time.Time) error {
+	return wrapErr(c.session().Chtimes(c.abs(path), atime, mtime))
+}
+
+func (c *Client) Chown(ctx context.Context, path string, uid, gid int) error {
+	return wrapErr(c.session().Chown(c.abs(path), uid, gid))
+}
+
+// RealPath asks the server to resolve p; relative paths are taken from the
+// root.
+func (c *Client) RealPath(ctx context.Context, p string) (string, error) {
+	p, err := c.session().RealPath(c.abs(p))
+	return p, wrapErr(err)
+}
+
+// file wraps an open remote file so its errors are classified too.
+type file struct{ f *pkgsftp.File }
+
+func (f file) Read(p []byte) (int, error) {
+	n, err := f.f.Read(p)
+	return n, wrapErr(err)
+}
+
+func (f file) Write(p []byte) (int, error) {
+	n, err := f.f.Write(p)
+	return n, wrapErr(err)
+}
+
+func (f file) Close() error { return wrapErr(f.f.Close()) }
+
+// wrapErr marks errors caused by a dead session with
+// remotefs.ErrConnectionLost.
+func wrapErr(err error) error {
+	switch {
+	case err == nil, err == io.EOF:
+		return err
+	case errors.Is(err, pkgsftp.ErrSSHFxConnectionLost), errors.Is(err, pkgsftp.ErrSSHFxNoConnection),
+		errors.Is(err, net.ErrClosed), errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%w: %w", remotefs.ErrConnectionLost, err)
+	}
+	return err
 }
 
 func (c *Client) Join(elem ...string) string { return filepath.Join(elem...) }
@@ -164,12 +316,20 @@ func (c *Client) hostKeyCallback() gossh.HostKeyCallback {
 }
 
 // buildAuth builds SSH auth methods (password, ssh-agent, or identity files).
-func buildAuth(cfg remotefs.ConnectionConfig) ([]gossh.AuthMethod, error) {
+// It also returns a name per method; tried is called with that name whenever
+// the server asks for the corresponding credentials.
+func buildAuth(cfg remotefs.ConnectionConfig, tried func(name string)) ([]gossh.AuthMethod, []string, error) {
 	methods := []gossh.AuthMethod{}
+	names := []string{}
 
 	// 1. SSH Agent Support (Highest priority, as it's the standard for seamless login)
 	if agentConn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err == nil {
-		methods = append(methods, gossh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+		signers := agent.NewClient(agentConn).Signers
+		methods = append(methods, gossh.PublicKeysCallback(func() ([]gossh.Signer, error) {
+			tried("agent")
+			return signers()
+		}))
+		names = append(names, "agent")
 	}
 
 	// 2. Identity Files Support
@@ -212,17 +372,26 @@ func buildAuth(cfg remotefs.ConnectionConfig) ([]gossh.AuthMethod, error) {
 					continue
 				}
 			}
-			methods = append(methods, gossh.PublicKeys(signer))
+			name := "key:" + kp
+			methods = append(methods, gossh.PublicKeysCallback(func() ([]gossh.Signer, error) {
+				tried(name)
+				return []gossh.Signer{signer}, nil
+			}))
+			names = append(names, name)
 		}
 	}
 
 	// 3. Password Auth (Fallback)
 	if cfg.Password != "" {
-		methods = append(methods, gossh.Password(cfg.Password))
+		methods = append(methods, gossh.PasswordCallback(func() (string, error) {
+			tried("password")
+			return cfg.Password, nil
+		}))
+		names = append(names, "password")
 	}
 
 	if len(methods) == 0 {
-		return nil, errors.New("no auth methods available (ensure ssh-agent is running, default keys exist in ~/.ssh, or provide a password/key)")
+		return nil, nil, errors.New("no auth methods available (ensure ssh-agent is running, default keys exist in ~/.ssh, or provide a password/key)")
 	}
-	return methods, nil
+	return methods, names, nil
 }
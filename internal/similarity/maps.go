@@ -0,0 +1,135 @@
+package similarity
+
+import (
+	"image"
+	"math"
+)
+
+// Map is a per-pixel error map. V holds W*H values in [0,1], row-major;
+// 0 means identical.
+type Map struct {
+	W, H int
+	V    []float64
+}
+
+// At returns the value at (x, y).
+func (m Map) At(x, y int) float64 { return m.V[y*m.W+x] }
+
+// Mean returns the average error.
+func (m Map) Mean() float64 {
+	if len(m.V) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range m.V {
+		sum += v
+	}
+	return sum / float64(len(m.V))
+}
+
+// Max returns the largest error and where it occurs.
+func (m Map) Max() (v float64, at image.Point) {
+	for i, e := range m.V {
+		if e > v {
+			v, at = e, image.Pt(i%m.W, i/m.W)
+		}
+	}
+	return v, at
+}
+
+// Above returns the fraction of pixels whose error exceeds t.
+func (m Map) Above(t float64) float64 {
+	if len(m.V) == 0 {
+		return 0
+	}
+	n := 0
+	for _, v := range m.V {
+		if v > t {
+			n++
+		}
+	}
+	return float64(n) / float64(len(m.V))
+}
+
+// DiffMap returns the largest absolute RGB channel difference of each pixel,
+// scaled to [0,1].
+func DiffMap(ref, dist image.Image) (Map, error) {
+	if !sameSize(ref, dist) {
+		return Map{}, ErrBoundsMismatch
+	}
+	ra, _ := planes(ref)
+	rb, _ := planes(dist)
+	w, h := ref.Bounds().Dx(), ref.Bounds().Dy()
+	m := Map{W: w, H: h, V: make([]float64, w*h)}
+	for i := range m.V {
+		var d float64
+		for c := 0; c < 3; c++ {
+			d = math.Max(d, math.Abs(ra[i*3+c]-rb[i*3+c]))
+		}
+		m.V[i] = d / 255
+	}
+	return m, nil
+}
+
+// SSIMMap returns 1-SSIM of the luma window centered on each pixel (clipped
+// at the borders), clamped to [0,1]. Window sums come from integral images,
+// so the cost does not depend on the window size.
+func SSIMMap(ref, dist image.Image) (Map, error) {
+	if !sameSize(ref, dist) {
+		return Map{}, ErrBoundsMismatch
+	}
+	_, la := planes(ref)
+	_, lb := planes(dist)
+	w, h := la.w, la.h
+	sa := integral(la, lb, func(a, _ float64) float64 { return a })
+	sb := integral(la, lb, func(_, b float64) float64 { return b })
+	saa := integral(la, lb, func(a, _ float64) float64 { return a * a })
+	sbb := integral(la, lb, func(_, b float64) float64 { return b * b })
+	sab := integral(la, lb, func(a, b float64) float64 { return a * b })
+
+	m := Map{W: w, H: h, V: make([]float64, w*h)}
+	half := ssimWindow / 2
+	for y := 0; y < h; y++ {
+		y0, y1 := max(0, y-half), min(h, y+half)
+		for x := 0; x < w; x++ {
+			x0, x1 := max(0, x-half), min(w, x+half)
+			n := float64((x1 - x0) * (y1 - y0))
+			ma := sa.sum(x0, y0, x1, y1) / n
+			mb := sb.sum(x0, y0, x1, y1) / n
+			va := saa.sum(x0, y0, x1, y1)/n - ma*ma
+			vb := sbb.sum(x0, y0, x1, y1)/n - mb*mb
+			cov := sab.sum(x0, y0, x1, y1)/n - ma*mb
+			s := ((2*ma*mb + ssimC1) * (2*cov + ssimC2)) / ((ma*ma + mb*mb + ssimC1) * (va + vb + ssimC2))
+			m.V[y*w+x] = math.Min(1, math.Max(0, 1-s))
+		}
+	}
+	return m, nil
+}
+
+// table is a summed-area table with a zero first row and column.
+type table struct {
+	w int // table width, plane width + 1
+	v []float64
+}
+
+func integral(a, b plane, f func(a, b float64) float64) table {
+	t := table{w: a.w + 1, v: make([]float64, (a.w+1)*(a.h+1))}
+	for y := 0; y < a.h; y++ {
+		var row float64
+		for x := 0; x < a.w; x++ {
+			i := y*a.w + x
+			row += f(a.v[i], b.v[i])
+			t.v[(y+1)*t.w+x+1] = t.v[y*t.w+x+1] + row
+		}
+	}
+	return t
+}
+
+// sum returns the sum over [x0,x1)×[y0,y1).
+func (t table) sum(x0, y0, x1, y1 int) float64 {
+	return t.v[y1*t.w+x1] - t.v[y0*t.w+x1] - t.v[y1*t.w+x0] + t.v[y0*t.w+x0]
+}
+
+func sameSize(a, b image.Image) bool {
+	return a.Bounds().Dx() == b.Bounds().Dx() && a.Bounds().Dy() == b.Bounds().Dy()
+}
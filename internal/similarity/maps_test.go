@@ -0,0 +1,45 @@
+package similarity
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestMapsLocalizeError(t *testing.T) {
+	ref := image.NewGray(image.Rect(0, 0, 64, 64))
+	for i := range ref.Pix {
+		ref.Pix[i] = uint8(i * 7)
+	}
+	dist := image.NewGray(ref.Bounds())
+	copy(dist.Pix, ref.Pix)
+	for y := 40; y < 48; y++ {
+		for x := 8; x < 16; x++ {
+			dist.SetGray(x, y, color.Gray{Y: 255 - ref.GrayAt(x, y).Y})
+		}
+	}
+
+	same, err := SSIMMap(ref, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := same.Max(); v > 1e-9 {
+		t.Fatalf("identical images: max 1-SSIM %g, want 0", v)
+	}
+	for name, build := range map[string]func(image.Image, image.Image) (Map, error){"diff": DiffMap, "ssim": SSIMMap} {
+		m, err := build(ref, dist)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, at := m.Max()
+		if !at.In(image.Rect(4, 36, 20, 52)) {
+			t.Errorf("%s map: worst pixel at %v, want inside the changed block", name, at)
+		}
+		if m.At(60, 4) > 1e-9 {
+			t.Errorf("%s map: untouched corner has error %g", name, m.At(60, 4))
+		}
+	}
+	if _, err := DiffMap(ref, image.NewGray(image.Rect(0, 0, 8, 8))); err != ErrBoundsMismatch {
+		t.Fatalf("size mismatch: got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package similarity
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// heatStops is the heatmap color ramp, from no error to the scale maximum.
+var heatStops = []color.RGBA{
+	{0, 0, 0, 255},
+	{72, 12, 120, 255},
+	{200, 40, 60, 255},
+	{250, 150, 20, 255},
+	{255, 255, 200, 255},
+}
+
+// Heatmap renders m as a color image. Values at or above scale get the
+// hottest color; scale <= 0 uses the map's maximum so faint errors stay
+// visible.
+func Heatmap(m Map, scale float64) *image.RGBA {
+	if scale <= 0 {
+		scale, _ = m.Max()
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, m.W, m.H))
+	for i, v := range m.V {
+		t := 0.0
+		if scale > 0 {
+			t = math.Min(1, v/scale)
+		}
+		dst.SetRGBA(i%m.W, i/m.W, ramp(t))
+	}
+	return dst
+}
+
+func ramp(t float64) color.RGBA {
+	pos := t * float64(len(heatStops)-1)
+	i := int(pos)
+	if i >= len(heatStops)-1 {
+		return heatStops[len(heatStops)-1]
+	}
+	f := pos - float64(i)
+	a, b := heatStops[i], heatStops[i+1]
+	lerp := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*f + 0.5) }
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), 255}
+}
+
+// SideBySide places imgs left to right, top-aligned.
+func SideBySide(imgs ...image.Image) *image.RGBA {
+	w, h := 0, 0
+	for _, img := range imgs {
+		w += img.Bounds().Dx()
+		h = max(h, img.Bounds().Dy())
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	x := 0
+	for _, img := range imgs {
+		b := img.Bounds()
+		draw.Draw(dst, image.Rect(x, 0, x+b.Dx(), b.Dy()), img, b.Min, draw.Src)
+		x += b.Dx()
+	}
+	return dst
+}
+
+// Slider shows left up to split (0-1 of the width) and right after it, with a
+// divider line, like a before/after slider frozen at one position.
+func Slider(left, right image.Image, split float64) *image.RGBA {
+	b := left.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	cut := int(math.Round(math.Min(1, math.Max(0, split)) * float64(b.Dx())))
+	draw.Draw(dst, image.Rect(0, 0, cut, b.Dy()), left, b.Min, draw.Src)
+	draw.Draw(dst, image.Rect(cut, 0, b.Dx(), b.Dy()), right, right.Bounds().Min.Add(image.Pt(cut, 0)), draw.Src)
+	line := image.Rect(max(0, cut-1), 0, min(b.Dx(), cut+1), b.Dy())
+	draw.Draw(dst, line, image.NewUniform(color.RGBA{255, 0, 255, 255}), image.Point{}, draw.Src)
+	return dst
+}
@@ -0,0 +1,117 @@
+package similarity
+
+import (
+	"errors"
+	"image"
+	"math"
+)
+
+// MaxPSNR is reported for identical images so scores stay finite (and JSON encodable).
+const MaxPSNR = 100.0
+
+// ssimWindow is the side of the square window SSIM statistics are computed over.
+const ssimWindow = 8
+
+var (
+	ssimC1 = math.Pow(0.01*255, 2)
+	ssimC2 = math.Pow(0.03*255, 2)
+)
+
+// ErrBoundsMismatch is returned when the compared images differ in size.
+var ErrBoundsMismatch = errors.New("images differ in size")
+
+// Scores holds full-reference quality scores for a pair of images.
+type Scores struct {
+	PSNR float64 // dB over RGB, capped at MaxPSNR
+	SSIM float64 // mean structural similarity of luma, 1 = identical
+}
+
+// Compare computes PSNR and SSIM between a reference and a distorted image.
+func Compare(ref, dist image.Image) (Scores, error) {
+	if !sameSize(ref, dist) {
+		return Scores{}, ErrBoundsMismatch
+	}
+	ra, la := planes(ref)
+	rb, lb := planes(dist)
+	return Scores{PSNR: psnr(ra, rb), SSIM: meanSSIM(la, lb)}, nil
+}
+
+// plane is a dense single-channel float image.
+type plane struct {
+	w, h int
+	v    []float64
+}
+
+// planes returns the interleaved RGB samples and the luma plane of img.
+func planes(img image.Image) ([]float64, plane) {
+	b := img.Bounds()
+	rgb := make([]float64, 0, b.Dx()*b.Dy()*3)
+	luma := plane{w: b.Dx(), h: b.Dy(), v: make([]float64, 0, b.Dx()*b.Dy())}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			fr, fg, fb := float64(r>>8), float64(g>>8), float64(bl>>8)
+			rgb = append(rgb, fr, fg, fb)
+			luma.v = append(luma.v, 0.299*fr+0.587*fg+0.114*fb)
+		}
+	}
+	return rgb, luma
+}
+
+func psnr(a, b []float64) float64 {
+	if len(a) == 0 {
+		return MaxPSNR
+	}
+	var sum float64
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	mse := sum / float64(len(a))
+	if mse == 0 {
+		return MaxPSNR
+	}
+	return math.Min(MaxPSNR, 10*math.Log10(255*255/mse))
+}
+
+// meanSSIM averages SSIM over windows placed every half window.
+func meanSSIM(a, b plane) float64 {
+	if a.w < ssimWindow || a.h < ssimWindow {
+		return windowSSIM(a, b, 0, 0, a.w, a.h)
+	}
+	var sum float64
+	var n int
+	step := ssimWindow / 2
+	for y := 0; y+ssimWindow <= a.h; y += step {
+		for x := 0; x+ssimWindow <= a.w; x += step {
+			sum += windowSSIM(a, b, x, y, ssimWindow, ssimWindow)
+			n++
+		}
+	}
+	return sum / float64(n)
+}
+
+// windowSSIM computes SSIM over the w×h window at (x0, y0).
+func windowSSIM(a, b plane, x0, y0, w, h int) float64 {
+	n := float64(w * h)
+	if n == 0 {
+		return 1
+	}
+	var sa, sb, saa, sbb, sab float64
+	for y := y0; y < y0+h; y++ {
+		row := y * a.w
+		for x := x0; x < x0+w; x++ {
+			va, vb := a.v[row+x], b.v[row+x]
+			sa += va
+			sb += vb
+			saa += va * va
+			sbb += vb * vb
+			sab += va * vb
+		}
+	}
+	ma, mb := sa/n, sb/n
+	va := saa/n - ma*ma
+	vb := sbb/n - mb*mb
+	cov := sab/n - ma*mb
+	return ((2*ma*mb + ssimC1) * (2*cov + ssimC2)) / ((ma*ma + mb*mb + ssimC1) * (va + vb + ssimC2))
+}
@@ -0,0 +1,78 @@
+package throttle
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a daily time range with its own overall limit. Start and End
+// are offsets from local midnight; a window with End before Start runs past
+// midnight. Start and End differ (ParseSchedule rejects empty windows).
+type Window struct {
+	Start, End time.Duration
+	Rate       int64 // bytes per second
+	Off        bool  // no limits at all
+}
+
+// Schedule holds windows; the first that covers a time applies.
+type Schedule []Window
+
+// At returns the window covering t.
+func (s Schedule) At(t time.Time) (Window, bool) {
+	h, m, sec := t.Clock()
+	now := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second
+	for _, w := range s {
+		if w.Start <= w.End && now >= w.Start && now < w.End {
+			return w, true
+		}
+		if w.Start > w.End && (now >= w.Start || now < w.End) {
+			return w, true
+		}
+	}
+	return Window{}, false
+}
+
+// ParseSchedule parses comma-separated "HH:MM-HH:MM=RATE" windows, e.g.
+// "22:00-06:00=off,12:00-13:00=4MB". RATE is as for ParseRate; "off" lifts
+// every limit.
+func ParseSchedule(s string) (Schedule, error) {
+	var sched Schedule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		span, rate, ok := strings.Cut(part, "=")
+		from, to, ok2 := strings.Cut(span, "-")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid schedule window %q (want HH:MM-HH:MM=RATE)", part)
+		}
+		var w Window
+		var err error
+		if w.Start, err = parseClock(from); err != nil {
+			return nil, err
+		}
+		if w.End, err = parseClock(to); err != nil {
+			return nil, err
+		}
+		if w.Start == w.End {
+			return nil, fmt.Errorf("empty schedule window %q: start and end are the same", part)
+		}
+		if strings.EqualFold(strings.TrimSpace(rate), "off") {
+			w.Off = true
+		} else if w.Rate, err = ParseRate(rate); err != nil {
+			return nil, err
+		}
+		sched = append(sched, w)
+	}
+	return sched, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (want HH:MM)", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,191 @@
+// Package throttle limits transfer rates. A Limiter holds token buckets
+// for all traffic and for each direction; they are shared by every reader
+// and writer it wraps, so the limits hold across concurrent transfers. An
+// optional Schedule changes the overall limit by time of day.
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juparave/photoptim/internal/filter"
+)
+
+// chunk is the most a single read or write moves before being throttled,
+// which keeps transfers smooth instead of bursty.
+const chunk = 32 << 10
+
+// Config sets rates in bytes per second; 0 = unlimited.
+type Config struct {
+	Limit    int64 // all traffic
+	Up       int64 // uploads
+	Down     int64 // downloads
+	Schedule Schedule
+}
+
+// Enabled reports whether c limits anything.
+func (c Config) Enabled() bool {
+	return c.Limit > 0 || c.Up > 0 || c.Down > 0 || len(c.Schedule) > 0
+}
+
+// Limiter throttles readers and writers. A nil *Limiter does not throttle.
+type Limiter struct {
+	cfg           Config
+	all, up, down bucket
+	now           func() time.Time
+	sleep         func(context.Context, time.Duration) error
+}
+
+// New returns a Limiter for cfg, or nil if cfg limits nothing.
+func New(cfg Config) *Limiter {
+	if !cfg.Enabled() {
+		return nil
+	}
+	return &Limiter{cfg: cfg, now: time.Now, sleep: sleep}
+}
+
+// rates returns the limits in force at t: the schedule's window, if one
+// covers t, replaces Limit, and an "off" window lifts every limit.
+func (l *Limiter) rates(t time.Time) (all, up, down int64) {
+	all, up, down = l.cfg.Limit, l.cfg.Up, l.cfg.Down
+	if w, ok := l.cfg.Schedule.At(t); ok {
+		if w.Off {
+			return 0, 0, 0
+		}
+		all = w.Rate
+	}
+	return all, up, down
+}
+
+// wait blocks until n bytes may move in the direction of dir.
+func (l *Limiter) wait(ctx context.Context, dir *bucket, n int) error {
+	t := l.now()
+	all, up, down := l.rates(t)
+	rate := down
+	if dir == &l.up {
+		rate = up
+	}
+	d := max(l.all.take(t, all, n), dir.take(t, rate, n))
+	if d <= 0 {
+		return nil
+	}
+	return l.sleep(ctx, d)
+}
+
+// Reader throttles downloads read from r.
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, l: l}
+}
+
+// Writer throttles uploads written to w.
+func (l *Limiter) Writer(ctx context.Context, w io.Writer) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &writer{ctx: ctx, w: w, l: l}
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.wait(r.ctx, &r.l.down, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type writer struct {
+	ctx context.Context
+	w   io.Writer
+	l   *Limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := min(len(p), chunk)
+		if err := w.l.wait(w.ctx, &w.l.up, n); err != nil {
+			return written, err
+		}
+		m, err := w.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// bucket is a token bucket that may go into debt: a taker is told how long
+// to wait for the tokens it took, so later takers queue up behind it.
+type bucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// take removes n tokens at rate bytes per second (0 = unlimited) and
+// returns how long to wait before using them. The bucket holds at most a
+// quarter second's worth, or one chunk.
+func (b *bucket) take(t time.Time, rate int64, n int) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	burst := max(float64(rate)/4, chunk)
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if elapsed := t.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(burst, b.tokens+elapsed*float64(rate))
+	}
+	b.last = t
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(rate) * float64(time.Second))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ParseRate parses a rate such as "2MB", "512KB/s" or "off" (0).
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "", "off", "0":
+		return 0, nil
+	}
+	n, err := filter.ParseSize(strings.TrimSuffix(strings.TrimSuffix(s, "/s"), "/S"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q (want e.g. 512KB, 2MB/s or off)", s)
+	}
+	return n, nil
+}
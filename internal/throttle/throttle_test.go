@@ -0,0 +1,87 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// fakeLimiter returns a Limiter on a fake clock that advances by every
+// wait instead of sleeping.
+func fakeLimiter(cfg Config, start time.Time) (*Limiter, *time.Time) {
+	now := start
+	l := New(cfg)
+	l.now = func() time.Time { return now }
+	l.sleep = func(_ context.Context, d time.Duration) error {
+		now = now.Add(d)
+		return nil
+	}
+	return l, &now
+}
+
+func TestLimiter(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	data := make([]byte, 1<<20)
+
+	tests := []struct {
+		name string
+		cfg  Config
+		up   bool
+		want time.Duration // approximate time to move data
+	}{
+		{"down limit", Config{Down: 256 << 10}, false, 4 * time.Second},
+		{"up limit ignores down", Config{Down: 1 << 10, Up: 512 << 10}, true, 2 * time.Second},
+		{"overall wins when lower", Config{Limit: 128 << 10, Down: 1 << 20}, false, 8 * time.Second},
+		{"schedule off", Config{Limit: 1 << 10, Schedule: Schedule{{Start: 11 * time.Hour, End: 13 * time.Hour, Off: true}}}, false, 0},
+		{"schedule rate", Config{Limit: 1 << 10, Schedule: Schedule{{Start: 22 * time.Hour, End: 13 * time.Hour, Rate: 1 << 20}}}, false, time.Second},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l, now := fakeLimiter(tc.cfg, start)
+			var err error
+			if tc.up {
+				_, err = l.Writer(context.Background(), io.Discard).Write(data)
+			} else {
+				_, err = io.Copy(io.Discard, l.Reader(context.Background(), bytes.NewReader(data)))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The initial burst (a quarter second) is free.
+			got := now.Sub(start)
+			if got < tc.want*3/4-time.Millisecond || got > tc.want {
+				t.Fatalf("took %v, want about %v", got, tc.want)
+			}
+		})
+	}
+	if New(Config{}) != nil {
+		t.Fatal("New(Config{}) should not limit")
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	s, err := ParseSchedule("22:00-06:00=off, 12:00-13:00=4MB/s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(h, m int) time.Time { return time.Date(2024, 5, 1, h, m, 0, 0, time.Local) }
+	if w, ok := s.At(at(23, 30)); !ok || !w.Off {
+		t.Fatalf("23:30: %+v %v, want off", w, ok)
+	}
+	if w, ok := s.At(at(5, 59)); !ok || !w.Off {
+		t.Fatalf("05:59: %+v %v, want off", w, ok)
+	}
+	if w, ok := s.At(at(12, 30)); !ok || w.Rate != 4<<20 {
+		t.Fatalf("12:30: %+v %v, want 4MB", w, ok)
+	}
+	if _, ok := s.At(at(13, 0)); ok {
+		t.Fatal("13:00 should be outside every window")
+	}
+	for _, bad := range []string{"22:00=off", "25:00-06:00=off", "22:00-06:00=fast", "00:00-00:00=1MB/s", "12:00-12:00=off"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Fatalf("ParseSchedule(%q) succeeded", bad)
+		}
+	}
+}
@@ -12,6 +12,9 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+
+	"github.com/juparave/photoptim/internal/filter"
+	"github.com/juparave/photoptim/internal/ledger"
 )
 
 // KeyMap defines the keybindings for our application
@@ -84,6 +87,18 @@ type Model struct {
 	selectedFiles  map[string]struct{}
 	currentPath    string
 	width, height  int
+	ledger         *ledger.Ledger
+	filter         filter.Rules
+}
+
+// Options configures a Model.
+type Options struct {
+	// Ledger, if set, records optimized files and skips those unchanged
+	// since; nil = optimize everything.
+	Ledger *ledger.Ledger
+	// Filter leaves out selected files it does not match; they are listed
+	// with their skip reason when optimization finishes.
+	Filter filter.Rules
 }
 
 type state int
@@ -96,8 +111,10 @@ const (
 	optimizingState
 )
 
-func NewModel() Model {
+func NewModel(opts Options) Model {
 	m := Model{
+		ledger:        opts.Ledger,
+		filter:        opts.Filter,
 		state:         filePickerState,
 		selectedFiles: make(map[string]struct{}),
 		currentPath:   ".",
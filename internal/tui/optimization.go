@@ -25,15 +25,31 @@ func runOptimization(msg optimizationMsg) tea.Cmd {
 		opt.Quality = quality
 
 		// Process files
+		var skipped []string
 		for _, file := range msg.selectedFiles {
 			filename := filepath.Base(file)
 			outputPath := filepath.Join(msg.outputDir, filename)
 
-			if err := opt.Optimize(file, outputPath); err != nil {
+			src, err := os.Stat(file)
+			if err != nil {
 				return updateStatusMsg(fmt.Sprintf("Error optimizing %s: %v", filename, err))
 			}
+			if reason := msg.filter.MatchLocal(file, filename, src); reason != "" {
+				skipped = append(skipped, fmt.Sprintf("%s (%s)", filename, reason))
+				continue
+			}
+			if msg.ledger.LocalUnchanged(file, outputPath) {
+				skipped = append(skipped, fmt.Sprintf("%s (previously optimized)", filename))
+				continue
+			}
+			res, err := opt.Optimize(file, outputPath)
+			if err != nil {
+				return updateStatusMsg(fmt.Sprintf("Error optimizing %s: %v", filename, err))
+			}
+			// A ledger failure only costs a re-encode next time.
+			_ = msg.ledger.RecordLocal(file, src, outputPath, res.Reason)
 		}
 
-		return finishedMsg{}
+		return finishedMsg{skipped: skipped}
 	}
 }
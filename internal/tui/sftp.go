@@ -1,9 +1,13 @@
 package tui
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"log/slog"
 	"path"
 	"path/filepath"
 	"sort"
@@ -11,9 +15,14 @@ import (
 	"strings"
 	"time"
 
+	"github.com/juparave/photoptim/internal/filter"
+	"github.com/juparave/photoptim/internal/ledger"
+	"github.com/juparave/photoptim/internal/logging"
 	"github.com/juparave/photoptim/internal/optimizer"
+	transfer "github.com/juparave/photoptim/internal/progress"
 	"github.com/juparave/photoptim/internal/remotefs"
 	sftpfs "github.com/juparave/photoptim/internal/sftp"
+	"github.com/juparave/photoptim/internal/throttle"
 
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/progress"
@@ -25,10 +34,11 @@ import (
 
 // sftpItem represents a file or directory in the SFTP list.
 type sftpItem struct {
-	name     string
-	isDir    bool
-	size     int64
-	selected bool
+	name      string
+	isDir     bool
+	size      int64
+	selected  bool
+	optimized bool // in the ledger and unchanged since
 }
 
 func (i sftpItem) FilterValue() string { return i.name }
@@ -41,6 +51,9 @@ func (i sftpItem) Title() string {
 	}
 
 	sizeStr := formatFileSize(i.size)
+	if i.optimized {
+		return fmt.Sprintf("%s %-30s %-8s %s", icon, i.name, sizeStr, optimizedIcon+" previously optimized")
+	}
 	return fmt.Sprintf("%s %-30s %s", icon, i.name, sizeStr)
 }
 
@@ -56,6 +69,24 @@ func formatFileSize(size int64) string {
 	}
 }
 
+// resultDetails summarizes what the optimizer did: dimensions, encoder
+// settings and quality scores.
+func resultDetails(res optimizer.Result) string {
+	parts := []string{}
+	if res.Resized {
+		parts = append(parts, fmt.Sprintf("%dx%d→%dx%d", res.SourceWidth, res.SourceHeight, res.Width, res.Height))
+	} else {
+		parts = append(parts, fmt.Sprintf("%dx%d", res.Width, res.Height))
+	}
+	if res.Quality > 0 {
+		parts = append(parts, fmt.Sprintf("q%d", res.Quality))
+	}
+	if res.SSIM > 0 {
+		parts = append(parts, fmt.Sprintf("SSIM %.3f", res.SSIM), fmt.Sprintf("PSNR %.1fdB", res.PSNR))
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
 // Mobile device size presets
 var resizePresets = []struct {
 	name   string
@@ -150,10 +181,11 @@ type SFTPModel struct {
 
 	sftpClient    *sftpfs.Client
 	fileList      list.Model
-	selectedFiles map[string]struct{}
+	selectedFiles map[string]int64 // path -> listed size
 
 	// Progress tracking for optimization
 	progress            progress.Model
+	tracker             *transfer.Tracker // bytes moved across the selected files
 	optimizing          bool
 	optimizationResults []string
 	currentFile         string
@@ -166,6 +198,31 @@ type SFTPModel struct {
 	maxWidth     int
 	maxHeight    int
 	resizePreset int // 0 = disabled, 1+ = preset index
+
+	ledger     *ledger.Ledger
+	ledgerHost string // user@host:port of the connection
+	filter     filter.Rules
+	throttle   *throttle.Limiter
+	touchMTime bool
+
+	logger *slog.Logger
+}
+
+// SFTPOptions configures an SFTPModel.
+type SFTPOptions struct {
+	// Logger receives SFTP and optimizer logs. It must not write to the
+	// terminal the TUI is drawing on; nil = silent.
+	Logger *slog.Logger
+	// Ledger, if set, marks files optimized before and unchanged since,
+	// skips them and records the files optimized; nil = no ledger.
+	Ledger *ledger.Ledger
+	// Filter skips selected files it does not match, with their reason.
+	Filter filter.Rules
+	// Throttle, if set, limits download and upload rates; nil = unlimited.
+	Throttle *throttle.Limiter
+	// TouchModTime gives replaced files the upload time as mtime instead of
+	// keeping the original's.
+	TouchModTime bool
 }
 
 // --- Bubble Tea Messages ---
@@ -173,6 +230,7 @@ type (
 	sftpConnectSuccessMsg struct {
 		client *sftpfs.Client
 		path   string
+		host   string // ledger host
 	}
 	sftpConnectErrorMsg     struct{ err error }
 	filesListedMsg          struct{ files []list.Item }
@@ -190,6 +248,7 @@ type (
 		result   string
 	}
 	startOptimizationMsg struct{ files []string }
+	transferTickMsg      struct{}
 	optimizeFileMsg      struct {
 		filePath string
 		index    int
@@ -220,7 +279,7 @@ func (m SFTPModel) connectCmd() tea.Cmd {
 			KeyPath:    keyPath,
 			RemotePath: remotePath,
 		}
-		client := &sftpfs.Client{}
+		client := &sftpfs.Client{Logger: m.logger}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
@@ -230,7 +289,7 @@ func (m SFTPModel) connectCmd() tea.Cmd {
 		}
 
 		// After successful connection, the client.Root() will have the resolved path (home or specified)
-		return sftpConnectSuccessMsg{client: client, path: "."}
+		return sftpConnectSuccessMsg{client: client, path: ".", host: ledger.Host(user, host, port)}
 	}
 }
 
@@ -258,7 +317,8 @@ func (m SFTPModel) listFilesCmd() tea.Cmd {
 		for _, entry := range filteredEntries {
 			filePath := path.Join(m.currentPath, entry.Name)
 			_, isSelected := m.selectedFiles[filePath]
-			items = append(items, sftpItem{name: entry.Name, isDir: entry.IsDir, size: entry.Size, selected: isSelected})
+			optimized := !entry.IsDir && m.ledger.Unchanged(m.ledgerHost, ledger.Path(m.sftpClient.Root(), filePath), entry.Size, entry.ModTime)
+			items = append(items, sftpItem{name: entry.Name, isDir: entry.IsDir, size: entry.Size, selected: isSelected, optimized: optimized})
 		}
 
 		sort.Slice(items, func(i, j int) bool {
@@ -297,6 +357,11 @@ func (m SFTPModel) listFilesCmd() tea.Cmd {
 func (m SFTPModel) optimizeFileCmd(filePath string, index int, total int) tea.Cmd {
 	return func() tea.Msg {
 		filename := filepath.Base(filePath)
+		// Whatever happens, this file stops counting towards the remaining bytes.
+		tracker := m.tracker
+		dl, ul := filePath+"/download", filePath+"/upload"
+		defer tracker.Finish(dl)
+		defer tracker.Finish(ul)
 
 		if m.sftpClient == nil {
 			return fileOptimizedMsg{
@@ -308,46 +373,66 @@ func (m SFTPModel) optimizeFileCmd(filePath string, index int, total int) tea.Cm
 		ctx := context.Background()
 		opt := optimizer.New()
 		opt.Quality = 80
+		opt.Logger = m.logger
 
-		reader, _, err := m.sftpClient.Open(ctx, filePath)
+		ext := strings.ToLower(filepath.Ext(filePath))
+		reader, entry, err := m.sftpClient.Open(ctx, filePath)
 		if err != nil {
 			return fileOptimizedMsg{
 				result:  fmt.Sprintf("❌ %s: failed to open (%v)", filename, err),
 				success: false,
 			}
 		}
-
-		data, err := io.ReadAll(reader)
-		reader.Close()
-		if err != nil {
+		if reason := m.filter.MatchRemote(ctx, m.sftpClient, entry); reason != "" {
+			reader.Close()
 			return fileOptimizedMsg{
-				result:  fmt.Sprintf("❌ %s: failed to read (%v)", filename, err),
-				success: false,
+				result:  fmt.Sprintf("⏭  %s: skipped (%s)", filename, reason),
+				success: true,
 			}
 		}
-
-		ext := strings.ToLower(filepath.Ext(filePath))
-		if ext == "" || (ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".webp") {
+		key := ledger.Path(m.sftpClient.Root(), filePath)
+		if m.ledger.Unchanged(m.ledgerHost, key, entry.Size, entry.ModTime) {
+			reader.Close()
 			return fileOptimizedMsg{
-				result:  fmt.Sprintf("❌ %s: unsupported format (%s)", filename, ext),
-				success: false,
+				result:  fmt.Sprintf("ℹ️  %s: previously optimized, unchanged since", filename),
+				success: true,
 			}
 		}
 
+		// The output is kept in memory and only replaces the remote file,
+		// through a verified temporary file, once it is complete.
+		var out bytes.Buffer
+		sum := sha256.New()
+		src := transfer.NewReader(io.TeeReader(m.throttle.Reader(ctx, reader), sum), 0, func(n int64) { tracker.Set(dl, n) })
 		format := strings.TrimPrefix(ext, ".")
-		optimizedData, res, err := opt.OptimizeBytes(data, format, optimizer.Params{
+		res, err := opt.OptimizeStream(ctx, src, &out, format, optimizer.Params{
 			JPEGQuality: opt.Quality,
 			MaxWidth:    m.maxWidth,
 			MaxHeight:   m.maxHeight,
 		})
+		reader.Close()
+		tracker.Set(dl, src.N())
 		if err != nil && !res.Skipped {
 			return fileOptimizedMsg{
 				result:  fmt.Sprintf("❌ %s: optimization failed (%v)", filename, err),
 				success: false,
 			}
 		}
+		if out.Len() > 0 {
+			if werr := replaceRemote(ctx, m.sftpClient, m.throttle, m.touchMTime, entry, out.Bytes(), func(n int64) { tracker.Set(ul, n) }); werr != nil {
+				return fileOptimizedMsg{
+					result:  fmt.Sprintf("❌ %s: failed to write (%v)", filename, werr),
+					success: false,
+				}
+			}
+			if e, err := m.sftpClient.Stat(ctx, filePath); err == nil {
+				h := sha256.Sum256(out.Bytes())
+				m.remember(key, ledger.Record{Size: e.Size, ModTime: e.ModTime, Hash: hex.EncodeToString(h[:]), Reason: res.Reason})
+			}
+		}
 
-		if res.Skipped && res.Reason == "no-compression-gain" {
+		if res.KeptOriginal() {
+			m.remember(key, ledger.Record{Size: entry.Size, ModTime: entry.ModTime, Hash: hex.EncodeToString(sum.Sum(nil)), Reason: res.Reason})
 			return fileOptimizedMsg{
 				result:  fmt.Sprintf("ℹ️  %s: original is already optimal", filename),
 				success: true,
@@ -364,41 +449,78 @@ func (m SFTPModel) optimizeFileCmd(filePath string, index int, total int) tea.Cm
 		originalSize := res.OriginalSize
 		optimizedSize := res.OptimizedSize
 
-		writer, err := m.sftpClient.Create(ctx, filePath, true)
-		if err != nil {
-			return fileOptimizedMsg{
-				result:  fmt.Sprintf("❌ %s: failed to create output file (%v)", filename, err),
-				success: false,
-			}
-		}
-
-		_, err = writer.Write(optimizedData)
-		writer.Close()
-		if err != nil {
-			return fileOptimizedMsg{
-				result:  fmt.Sprintf("❌ %s: failed to write (%v)", filename, err),
-				success: false,
-			}
-		}
-
 		savings := originalSize - optimizedSize
 		savingsPercent := float64(savings) / float64(originalSize) * 100
 		return fileOptimizedMsg{
-			result: fmt.Sprintf("✅ %s: %s -> %s (%.1f%% saved)", filename,
-				formatFileSize(originalSize), formatFileSize(optimizedSize), savingsPercent),
+			result: fmt.Sprintf("✅ %s: %s -> %s (%.1f%% saved) %s", filename,
+				formatFileSize(originalSize), formatFileSize(optimizedSize), savingsPercent, resultDetails(res)),
 			success: true,
 		}
 	}
 }
 
+// remember records a file in the ledger; failures only cost a re-encode
+// next time.
+func (m SFTPModel) remember(key string, rec ledger.Record) {
+	if err := m.ledger.Put(m.ledgerHost, key, rec); err != nil {
+		m.logger.Warn("ledger update failed", "file", key, "err", err)
+	}
+}
+
+// replaceRemote verifies data and atomically replaces entry with it, keeping
+// the original mode, owner where permitted and, unless touch, mtime; the
+// upload is throttled by lim.
+func replaceRemote(ctx context.Context, fsys remotefs.RemoteFS, lim *throttle.Limiter, touch bool, entry remotefs.RemoteEntry, data []byte, report func(int64)) error {
+	if err := optimizer.Verify(data); err != nil {
+		return err
+	}
+	af, err := remotefs.CreateAtomic(ctx, fsys, entry.Path)
+	if err != nil {
+		return err
+	}
+	defer af.Close()
+	dst := transfer.NewWriter(lim.Writer(ctx, af), 0, report)
+	if _, err := dst.Write(data); err != nil {
+		return err
+	}
+	report(dst.N())
+	opts := remotefs.Preserve(entry)
+	if touch {
+		opts.ModTime = time.Time{}
+	}
+	return af.Commit(ctx, opts)
+}
+
+// transferTick refreshes the byte progress while files are optimized.
+func transferTick() tea.Cmd {
+	return tea.Tick(200*time.Millisecond, func(time.Time) tea.Msg { return transferTickMsg{} })
+}
+
+// transferStatus renders overall percentage, throughput and ETA.
+func transferStatus(s transfer.Snapshot) string {
+	str := fmt.Sprintf("%.0f%%", s.Percent*100)
+	if s.Rate > 0 {
+		str += fmt.Sprintf(" · %s/s", formatFileSize(int64(s.Rate)))
+	}
+	if s.ETA > 0 {
+		str += fmt.Sprintf(" · ETA %s", s.ETA.Round(time.Second))
+	}
+	return str
+}
+
 // --- Model Initialization and Methods ---
 
-func NewSFTPModel() SFTPModel {
+func NewSFTPModel(opts SFTPOptions) SFTPModel {
 	m := SFTPModel{
 		state:         ConnectionState,
 		focusIndex:    0,
 		currentPath:   ".",
-		selectedFiles: make(map[string]struct{}),
+		selectedFiles: make(map[string]int64),
+		ledger:        opts.Ledger,
+		filter:        opts.Filter,
+		throttle:      opts.Throttle,
+		touchMTime:    opts.TouchModTime,
+		logger:        logging.OrDiscard(opts.Logger),
 	}
 
 	s := spinner.New()
@@ -483,6 +605,7 @@ func (m *SFTPModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case sftpConnectSuccessMsg:
 		m.sftpClient = msg.client
 		m.currentPath = msg.path
+		m.ledgerHost = msg.host
 		m.state = BrowserState
 		m.loading = true
 		m.status = "Listing files..."
@@ -514,12 +637,25 @@ func (m *SFTPModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.currentFile = ""
 		m.progress.SetPercent(0)
 		m.status = "Starting optimization..."
+		m.tracker = transfer.NewTracker()
+		for _, f := range msg.files {
+			m.tracker.Add(f+"/download", m.selectedFiles[f])
+			m.tracker.Add(f+"/upload", m.selectedFiles[f])
+		}
 
 		if len(msg.files) > 0 {
-			return m, m.optimizeFileCmd(msg.files[0], 0, len(msg.files))
+			return m, tea.Batch(m.optimizeFileCmd(msg.files[0], 0, len(msg.files)), transferTick())
 		}
 		return m, nil
 
+	case transferTickMsg:
+		if !m.optimizing {
+			return m, nil
+		}
+		snap := m.tracker.Snapshot()
+		m.status = fmt.Sprintf("Optimizing %d/%d files · %s", min(m.filesProcessed+1, m.totalFiles), m.totalFiles, transferStatus(snap))
+		return m, tea.Batch(m.progress.SetPercent(snap.Percent), transferTick())
+
 	case fileOptimizedMsg:
 		m.filesProcessed++
 		if msg.success {
@@ -529,8 +665,7 @@ func (m *SFTPModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		m.optimizationResults = append(m.optimizationResults, msg.result)
-		progressPercent := float64(m.filesProcessed) / float64(m.totalFiles)
-		cmd = m.progress.SetPercent(progressPercent)
+		cmd = m.progress.SetPercent(m.tracker.Snapshot().Percent)
 
 		if m.filesProcessed >= m.totalFiles {
 			m.loading = false
@@ -540,7 +675,7 @@ func (m *SFTPModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			} else {
 				m.status = fmt.Sprintf("Optimization complete: %d files optimized successfully", m.optimizedCount)
 			}
-			m.selectedFiles = make(map[string]struct{})
+			m.selectedFiles = make(map[string]int64)
 			return m, tea.Batch(cmd, m.listFilesCmd())
 		} else {
 			files := m.getSelectedFiles()
@@ -770,7 +905,13 @@ func (m *SFTPModel) toggleFileSelection(filename string) {
 	if _, ok := m.selectedFiles[filePath]; ok {
 		delete(m.selectedFiles, filePath)
 	} else {
-		m.selectedFiles[filePath] = struct{}{}
+		var size int64
+		for _, item := range m.fileList.Items() {
+			if it, ok := item.(sftpItem); ok && it.name == filename {
+				size = it.size
+			}
+		}
+		m.selectedFiles[filePath] = size
 	}
 
 	items := m.fileList.Items()
@@ -62,4 +62,5 @@ var (
 	folderIcon    = "📁"
 	fileIcon      = "📄"
 	arrowIcon     = "❯"
+	optimizedIcon = "✓"
 )
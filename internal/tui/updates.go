@@ -1,12 +1,17 @@
 package tui
 
 import (
+	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/juparave/photoptim/internal/filter"
+	"github.com/juparave/photoptim/internal/ledger"
 )
 
 func (m Model) updateFilePicker(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -125,6 +130,9 @@ func (m Model) updateOptimizing(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 	case finishedMsg:
 		m.statusMessage = "Optimization completed! Press 'q' to quit."
+		if len(msg.skipped) > 0 {
+			m.statusMessage = fmt.Sprintf("Optimization completed, skipped %s. Press 'q' to quit.", strings.Join(msg.skipped, ", "))
+		}
 		return m, nil
 	case optimizationMsg:
 		return m, runOptimization(msg)
@@ -137,13 +145,17 @@ func (m Model) updateOptimizing(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 // Messages for progress updates
 type progressMsg float64
-type finishedMsg struct{}
+type finishedMsg struct {
+	skipped []string // "name (reason)"
+}
 type updateStatusMsg string
 
 type optimizationMsg struct {
 	selectedFiles []string
 	quality       string
 	outputDir     string
+	ledger        *ledger.Ledger
+	filter        filter.Rules
 }
 
 func startOptimization(m Model) tea.Cmd {
@@ -152,6 +164,8 @@ func startOptimization(m Model) tea.Cmd {
 			selectedFiles: m.getSelectedFiles(),
 			quality:       m.qualityInput.Value(),
 			outputDir:     m.outputDirInput.Value(),
+			ledger:        m.ledger,
+			filter:        m.filter,
 		}
 	}
 }